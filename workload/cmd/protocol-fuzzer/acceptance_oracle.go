@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// ---------------------------------------------------------------------------
+// Invalid-data acceptance oracle
+//
+// Every block header the fuzzer fabricates and every block/message it
+// publishes on GossipSub is registered here with the attack that produced
+// it. Periodically the checker asks every node whether it accepted any of
+// them:
+//   - ChainGetBlock + ChainGetTipSetByHeight: the block is on the canonical chain
+//   - ChainGetBlock: a gossiped block reached the blockstore
+//   - MpoolPending: a gossiped message sits in the mempool
+//   - StateSearchMsg: a message was included on chain
+//
+// Messages are not checked against the blockstore: the Lotus mempool stores
+// a message before its nonce-gap and replace-by-fee checks, so a rejected
+// message can still be there.
+//
+// Blocks served over ChainExchange are only checked against the chain: the
+// Lotus syncer persists fetched headers before validating them, so their
+// presence in the blockstore alone is not an acceptance.
+// ---------------------------------------------------------------------------
+
+const (
+	fabricatedMaxEntries  = 256
+	fabricatedTTL         = 10 * time.Minute
+	fabricatedMsgLookback = 200 // epochs searched for an included message, > fabricatedTTL
+)
+
+type fabricatedKind int
+
+const (
+	fabBlock fabricatedKind = iota
+	fabMessage
+)
+
+func (k fabricatedKind) String() string {
+	if k == fabMessage {
+		return "message"
+	}
+	return "block"
+}
+
+// fabricatedObject is one invalid object the fuzzer produced.
+type fabricatedObject struct {
+	cid      cid.Cid
+	kind     fabricatedKind
+	gossiped bool // published on GossipSub, so validation precedes storage
+	attack   string
+	created  time.Time
+}
+
+var (
+	fabricatedMu  sync.Mutex
+	fabricated    []*fabricatedObject
+	fabricatedIdx = make(map[cid.Cid]*fabricatedObject)
+)
+
+// registerFabricated records an invalid object. Re-registering an existing
+// CID only upgrades it to gossiped.
+func registerFabricated(c cid.Cid, kind fabricatedKind, gossiped bool) {
+	if !c.Defined() {
+		return
+	}
+
+	attack := ""
+	oracleMu.Lock()
+	if currentAttack != nil {
+		attack = currentAttack.Name
+	}
+	oracleMu.Unlock()
+
+	fabricatedMu.Lock()
+	defer fabricatedMu.Unlock()
+
+	if obj, ok := fabricatedIdx[c]; ok {
+		obj.gossiped = obj.gossiped || gossiped
+		return
+	}
+	obj := &fabricatedObject{cid: c, kind: kind, gossiped: gossiped, attack: attack, created: time.Now()}
+	fabricated = append(fabricated, obj)
+	fabricatedIdx[c] = obj
+	if len(fabricated) > fabricatedMaxEntries {
+		delete(fabricatedIdx, fabricated[0].cid)
+		fabricated = fabricated[1:]
+	}
+}
+
+// registerGossipPayload extracts the CIDs a node would derive from a
+// /fil/blocks or /fil/msgs payload. Undecodable payloads are skipped — they
+// can't be stored under any CID.
+func registerGossipPayload(topicName string, data []byte) {
+	switch {
+	case strings.HasPrefix(topicName, "/fil/blocks/"):
+		// BlockMsg: [Header, BlsMessages, SecpkMessages]
+		if header := firstArrayElement(data); header != nil {
+			registerFabricated(blockCIDFromCBOR(header), fabBlock, true)
+		}
+	case strings.HasPrefix(topicName, "/fil/msgs/"):
+		// SignedMessage: [Message, Signature]. Secp messages are keyed by the
+		// signed CID, BLS messages by the inner Message CID.
+		if msg := firstArrayElement(data); msg != nil {
+			registerFabricated(blockCIDFromCBOR(data), fabMessage, true)
+			registerFabricated(blockCIDFromCBOR(msg), fabMessage, true)
+		}
+	}
+}
+
+// firstArrayElement returns the raw CBOR of the first element of a CBOR
+// array, or nil if data isn't a well-formed non-empty array prefix.
+func firstArrayElement(data []byte) []byte {
+	r := bytes.NewReader(data)
+	maj, n, err := cbg.CborReadHeader(r)
+	if err != nil || maj != cbg.MajArray || n == 0 {
+		return nil
+	}
+	var first cbg.Deferred
+	if err := first.UnmarshalCBOR(r); err != nil {
+		return nil
+	}
+	return first.Raw
+}
+
+// liveFabricated returns registered objects younger than the TTL.
+func liveFabricated() []fabricatedObject {
+	fabricatedMu.Lock()
+	defer fabricatedMu.Unlock()
+
+	var out []fabricatedObject
+	for _, obj := range fabricated {
+		if time.Since(obj.created) < fabricatedTTL {
+			out = append(out, *obj)
+		}
+	}
+	return out
+}
+
+// acceptanceViolation describes one node accepting one fabricated object.
+type acceptanceViolation struct {
+	Node   string `json:"node"`
+	CID    string `json:"cid"`
+	Kind   string `json:"kind"`
+	Attack string `json:"attack"`
+	Where  string `json:"where"`
+}
+
+// checkFabricatedAcceptance queries every node for every live fabricated
+// object and asserts none was accepted.
+func checkFabricatedAcceptance() {
+	objs := liveFabricated()
+	if len(objs) == 0 {
+		return
+	}
+
+	var onChain, stored, pending, included []acceptanceViolation
+	violation := func(node string, obj fabricatedObject, where string) acceptanceViolation {
+		return acceptanceViolation{Node: node, CID: obj.cid.String(), Kind: obj.kind.String(), Attack: obj.attack, Where: where}
+	}
+
+	for _, t := range targets {
+		pendingCIDs := mpoolPendingCIDs(t.Name)
+
+		for _, obj := range objs {
+			switch obj.kind {
+			case fabBlock:
+				var hdr struct{ Height int64 }
+				if err := callRPC(t.Name, "Filecoin.ChainGetBlock", []any{obj.cid}, &hdr); err != nil {
+					continue // not in the blockstore
+				}
+				if obj.gossiped {
+					stored = append(stored, violation(t.Name, obj, "blockstore"))
+				}
+				if blockOnChain(t.Name, obj.cid, hdr.Height) {
+					onChain = append(onChain, violation(t.Name, obj, "chain"))
+				}
+
+			case fabMessage:
+				if pendingCIDs[obj.cid] {
+					pending = append(pending, violation(t.Name, obj, "mpool"))
+				}
+				var lookup *struct{ Height int64 }
+				if err := callRPC(t.Name, "Filecoin.StateSearchMsg", []any{nil, obj.cid, fabricatedMsgLookback, true}, &lookup); err == nil && lookup != nil {
+					included = append(included, violation(t.Name, obj, "chain"))
+				}
+			}
+		}
+	}
+
+	details := map[string]any{
+		"checked":  len(objs),
+		"nodes":    len(targets),
+		"on_chain": onChain,
+		"stored":   stored,
+		"pending":  pending,
+		"included": included,
+	}
+	assert.Always(len(onChain) == 0, "Fabricated block never lands on a node's canonical chain", details)
+	assert.Always(len(stored) == 0, "Gossiped invalid block never reaches a node's blockstore", details)
+	assert.Always(len(pending) == 0, "Gossiped invalid message never enters a node's mempool", details)
+	assert.Always(len(included) == 0, "Fabricated message is never included on a node's chain", details)
+
+	if n := len(onChain) + len(stored) + len(pending) + len(included); n > 0 {
+		log.Printf("[acceptance] %d violations across %d fabricated objects: chain=%d store=%d mpool=%d msg_chain=%d",
+			n, len(objs), len(onChain), len(stored), len(pending), len(included))
+	} else {
+		debugLog("[acceptance] %d fabricated objects rejected by all %d nodes", len(objs), len(targets))
+	}
+}
+
+// blockOnChain reports whether c is part of the node's canonical tipset at
+// the given height.
+func blockOnChain(node string, c cid.Cid, height int64) bool {
+	var ts struct {
+		Cids []cid.Cid
+	}
+	if err := callRPC(node, "Filecoin.ChainGetTipSetByHeight", []any{height, nil}, &ts); err != nil {
+		debugLog("[acceptance] ChainGetTipSetByHeight(%d) on %s failed: %v", height, node, err)
+		return false
+	}
+	for _, k := range ts.Cids {
+		if k.Equals(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// mpoolPendingCIDs returns the CIDs of every message pending on the node.
+func mpoolPendingCIDs(node string) map[cid.Cid]bool {
+	var msgs []struct {
+		CID cid.Cid
+	}
+	if err := callRPC(node, "Filecoin.MpoolPending", []any{nil}, &msgs); err != nil {
+		debugLog("[acceptance] MpoolPending on %s failed: %v", node, err)
+		return nil
+	}
+	out := make(map[cid.Cid]bool, len(msgs))
+	for _, m := range msgs {
+		out[m.CID] = true
+	}
+	return out
+}
@@ -124,11 +124,14 @@ func buildResponseCBOR(status uint64, errMsg string, chain [][]byte) []byte {
 	for _, ts := range chain {
 		chainArray.Write(ts)
 	}
-	return cborArray(
+	resp := cborArray(
 		cborUint64(status),
 		cborTextString(errMsg),
 		chainArray.Bytes(),
 	)
+	tapPayload(channelXchgResponse, resp)
+	recordPayload(channelXchgResponse, resp)
+	return resp
 }
 
 // buildBSTipSetCBOR constructs a BSTipSet.
@@ -373,11 +376,13 @@ func buildBlockHeaderCBOR(opts blockHeaderOpts) []byte {
 	// Field 15: ParentBaseFee — BigInt bytes
 	parentBaseFee := cborBytes(bigIntBytes(100))
 
-	return cborArray(
+	header := cborArray(
 		miner, ticket, electionProof, beaconEntries, winPoStProof,
 		parents, parentWeight, height, parentStateRoot, parentMsgReceipts,
 		messages, blsAggregate, timestamp, blockSig, forkSignaling, parentBaseFee,
 	)
+	registerFabricated(blockCIDFromCBOR(header), fabBlock, false)
+	return header
 }
 
 // blockCIDFromCBOR computes the CID of a CBOR-encoded BlockHeader.
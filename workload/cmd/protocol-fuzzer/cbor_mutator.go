@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// ---------------------------------------------------------------------------
+// Structure-aware CBOR mutator
+//
+// The hand-written CBOR vectors each target one field of one type. This
+// mutator instead parses any valid encoded object into a CBOR AST that keeps
+// the original header widths, applies a few typed mutations anywhere in the
+// tree, and re-encodes it. Headers carry their declared count separately
+// from the actual children, so length lies survive re-encoding.
+// ---------------------------------------------------------------------------
+
+const (
+	cborMaxParseDepth = 256
+	cborIndefinite    = -1 // width marker for indefinite-length items
+	cborBreak         = 0xff
+	cborTagCID        = 42
+)
+
+// cborNode is one CBOR data item.
+type cborNode struct {
+	major byte   // 0..7
+	arg   uint64 // value (major 0/1/7), length (2/3), count (4), pairs (5), tag (6)
+	width int    // 0 = inline/minimal, 1/2/4/8 = forced header width, -1 = indefinite
+	raw   []byte // byte/text string payload
+	items []*cborNode
+}
+
+// ---------------------------------------------------------------------------
+// Parsing
+// ---------------------------------------------------------------------------
+
+type cborParser struct {
+	data []byte
+	pos  int
+}
+
+// parseCBOR parses exactly one CBOR item occupying all of data.
+func parseCBOR(data []byte) (*cborNode, error) {
+	p := &cborParser{data: data}
+	n, err := p.node(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(data) {
+		return nil, fmt.Errorf("%d trailing bytes", len(data)-p.pos)
+	}
+	return n, nil
+}
+
+func (p *cborParser) header() (major byte, arg uint64, width int, err error) {
+	if p.pos >= len(p.data) {
+		return 0, 0, 0, errors.New("unexpected end of input")
+	}
+	b := p.data[p.pos]
+	p.pos++
+	major, info := b>>5, b&0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), 0, nil
+	case info == 31:
+		return major, 0, cborIndefinite, nil
+	case info > 27:
+		return 0, 0, 0, fmt.Errorf("reserved additional info %d", info)
+	}
+
+	width = 1 << (info - 24)
+	if p.pos+width > len(p.data) {
+		return 0, 0, 0, errors.New("truncated header")
+	}
+	for _, c := range p.data[p.pos : p.pos+width] {
+		arg = arg<<8 | uint64(c)
+	}
+	p.pos += width
+	return major, arg, width, nil
+}
+
+func (p *cborParser) node(depth int) (*cborNode, error) {
+	if depth > cborMaxParseDepth {
+		return nil, errors.New("nesting too deep")
+	}
+	major, arg, width, err := p.header()
+	if err != nil {
+		return nil, err
+	}
+	n := &cborNode{major: major, arg: arg, width: width}
+	remaining := uint64(len(p.data) - p.pos)
+
+	switch major {
+	case 2, 3:
+		if width == cborIndefinite {
+			for p.pos < len(p.data) && p.data[p.pos] != cborBreak {
+				chunk, err := p.node(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				n.raw = append(n.raw, chunk.raw...)
+			}
+			if err := p.expectBreak(); err != nil {
+				return nil, err
+			}
+			n.arg = uint64(len(n.raw))
+			return n, nil
+		}
+		if arg > remaining {
+			return nil, errors.New("string length exceeds input")
+		}
+		n.raw = p.data[p.pos : p.pos+int(arg)]
+		p.pos += int(arg)
+
+	case 4, 5:
+		if width == cborIndefinite {
+			for p.pos < len(p.data) && p.data[p.pos] != cborBreak {
+				item, err := p.node(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				n.items = append(n.items, item)
+			}
+			if err := p.expectBreak(); err != nil {
+				return nil, err
+			}
+			n.arg = uint64(len(n.items))
+			if major == 5 {
+				n.arg /= 2
+			}
+			return n, nil
+		}
+		count := arg
+		if major == 5 {
+			count *= 2
+		}
+		if count > remaining {
+			return nil, errors.New("container count exceeds input")
+		}
+		for i := uint64(0); i < count; i++ {
+			item, err := p.node(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, item)
+		}
+
+	case 6:
+		item, err := p.node(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		n.items = []*cborNode{item}
+
+	case 7:
+		if width == cborIndefinite {
+			return nil, errors.New("unexpected break")
+		}
+	}
+	return n, nil
+}
+
+func (p *cborParser) expectBreak() error {
+	if p.pos >= len(p.data) {
+		return errors.New("missing break")
+	}
+	p.pos++
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Encoding
+// ---------------------------------------------------------------------------
+
+func (n *cborNode) encode() []byte {
+	var buf bytes.Buffer
+	n.writeTo(&buf)
+	return buf.Bytes()
+}
+
+func (n *cborNode) writeTo(buf *bytes.Buffer) {
+	writeCBORHeader(buf, n.major, n.arg, n.width)
+	switch n.major {
+	case 2, 3:
+		if n.width == cborIndefinite {
+			writeCBORHeader(buf, n.major, uint64(len(n.raw)), 0)
+			buf.Write(n.raw)
+			buf.WriteByte(cborBreak)
+			return
+		}
+		buf.Write(n.raw)
+	case 4, 5, 6:
+		for _, item := range n.items {
+			item.writeTo(buf)
+		}
+		if n.width == cborIndefinite && n.major != 6 {
+			buf.WriteByte(cborBreak)
+		}
+	}
+}
+
+// writeCBORHeader writes a header with the requested width. Width 0 (or an
+// indefinite marker on a type that can't be indefinite) means minimal.
+func writeCBORHeader(buf *bytes.Buffer, major byte, arg uint64, width int) {
+	switch {
+	case width == cborIndefinite && major >= 2 && major <= 5:
+		buf.WriteByte(major<<5 | 31)
+	case width == 1:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(arg))
+	case width == 2:
+		buf.WriteByte(major<<5 | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(arg)))
+	case width == 4:
+		buf.WriteByte(major<<5 | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(arg)))
+	case width == 8:
+		buf.WriteByte(major<<5 | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, arg))
+	default:
+		cbg.WriteMajorTypeHeader(buf, major, arg)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Mutations
+// ---------------------------------------------------------------------------
+
+// cborSite locates a node so mutations can replace it in its parent.
+type cborSite struct {
+	node   *cborNode
+	parent *cborNode
+	idx    int
+}
+
+func collectSites(root *cborNode) []cborSite {
+	var sites []cborSite
+	var walk func(n, parent *cborNode, idx int)
+	walk = func(n, parent *cborNode, idx int) {
+		sites = append(sites, cborSite{node: n, parent: parent, idx: idx})
+		for i, c := range n.items {
+			walk(c, n, i)
+		}
+	}
+	walk(root, nil, 0)
+	return sites
+}
+
+// pickSite returns a random site matching keep, or false if none does.
+func pickSite(root *cborNode, keep func(*cborNode) bool) (cborSite, bool) {
+	var matching []cborSite
+	for _, s := range collectSites(root) {
+		if keep(s.node) {
+			matching = append(matching, s)
+		}
+	}
+	if len(matching) == 0 {
+		return cborSite{}, false
+	}
+	return matching[rngIntn(len(matching))], true
+}
+
+// replace swaps the node at s for n, returning the (possibly new) root.
+func (s cborSite) replace(root, n *cborNode) *cborNode {
+	if s.parent == nil {
+		return n
+	}
+	s.parent.items[s.idx] = n
+	return root
+}
+
+func isContainer(n *cborNode) bool { return n.major == 4 || n.major == 5 }
+func isString(n *cborNode) bool    { return n.major == 2 || n.major == 3 }
+func isCIDTag(n *cborNode) bool {
+	return n.major == 6 && n.arg == cborTagCID && len(n.items) == 1 && n.items[0].major == 2
+}
+
+// cborMutation applies one typed change and returns the new root, or nil if
+// the tree has no suitable site.
+type cborMutation struct {
+	name  string
+	apply func(root *cborNode) *cborNode
+}
+
+var cborMutations = []cborMutation{
+	{"length-lie", mutLengthLie},
+	{"major-type-swap", mutMajorTypeSwap},
+	{"non-minimal-header", mutNonMinimalHeader},
+	{"indefinite-length", mutIndefiniteLength},
+	{"map-key-order", mutMapKeyOrder},
+	{"depth-inflation", mutDepthInflation},
+	{"field-drop", mutFieldDrop},
+	{"field-duplicate", mutFieldDuplicate},
+	{"cid-codec-swap", mutCIDCodecSwap},
+}
+
+// mutateCBOR parses seed and applies rounds random mutations. It returns the
+// re-encoded bytes and the names of the mutations that applied.
+func mutateCBOR(seed []byte, rounds int) ([]byte, []string, error) {
+	root, err := parseCBOR(seed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse seed: %w", err)
+	}
+	var applied []string
+	for i := 0; i < rounds; i++ {
+		m := cborMutations[rngIntn(len(cborMutations))]
+		if r := m.apply(root); r != nil {
+			root = r
+			applied = append(applied, m.name)
+		}
+	}
+	return root.encode(), applied, nil
+}
+
+// mutLengthLie declares a count or length that disagrees with the content.
+func mutLengthLie(root *cborNode) *cborNode {
+	s, ok := pickSite(root, func(n *cborNode) bool { return isContainer(n) || isString(n) })
+	if !ok {
+		return nil
+	}
+	n := s.node
+	switch rngIntn(4) {
+	case 0:
+		n.arg++
+	case 1:
+		if n.arg > 0 {
+			n.arg--
+		} else {
+			n.arg = 1
+		}
+	case 2:
+		n.arg = 1<<32 - 1
+	case 3:
+		n.arg = 1<<63 - 1
+	}
+	if n.width == cborIndefinite {
+		n.width = 0
+	}
+	return root
+}
+
+// mutMajorTypeSwap keeps the header argument but changes the major type.
+func mutMajorTypeSwap(root *cborNode) *cborNode {
+	s, ok := pickSite(root, func(*cborNode) bool { return true })
+	if !ok {
+		return nil
+	}
+	n := s.node
+	swapped := byte(rngIntn(7))
+	if swapped >= n.major {
+		swapped++
+	}
+	n.major = swapped
+	if n.width == cborIndefinite {
+		n.width = 0
+	}
+	return root
+}
+
+// mutNonMinimalHeader re-encodes a header wider than canonical CBOR allows.
+func mutNonMinimalHeader(root *cborNode) *cborNode {
+	s, ok := pickSite(root, func(n *cborNode) bool { return n.major != 7 && n.width != cborIndefinite })
+	if !ok {
+		return nil
+	}
+	n := s.node
+
+	current := n.width
+	if current == 0 {
+		switch {
+		case n.arg < 24:
+			current = 0
+		case n.arg <= 0xff:
+			current = 1
+		case n.arg <= 0xffff:
+			current = 2
+		case n.arg <= 0xffffffff:
+			current = 4
+		default:
+			current = 8
+		}
+	}
+	var wider []int
+	for _, w := range []int{1, 2, 4, 8} {
+		if w > current {
+			wider = append(wider, w)
+		}
+	}
+	if len(wider) == 0 {
+		return nil
+	}
+	n.width = wider[rngIntn(len(wider))]
+	return root
+}
+
+// mutIndefiniteLength turns a definite container or string indefinite.
+func mutIndefiniteLength(root *cborNode) *cborNode {
+	s, ok := pickSite(root, func(n *cborNode) bool {
+		return (isContainer(n) || isString(n)) && n.width != cborIndefinite
+	})
+	if !ok {
+		return nil
+	}
+	s.node.width = cborIndefinite
+	return root
+}
+
+// mutMapKeyOrder swaps two map entries or duplicates one.
+func mutMapKeyOrder(root *cborNode) *cborNode {
+	s, ok := pickSite(root, func(n *cborNode) bool { return n.major == 5 && len(n.items) >= 2 })
+	if !ok {
+		return nil
+	}
+	n := s.node
+	pairs := len(n.items) / 2
+	i := rngIntn(pairs)
+	if pairs >= 2 && rngIntn(2) == 0 {
+		j := (i + 1 + rngIntn(pairs-1)) % pairs
+		n.items[2*i], n.items[2*j] = n.items[2*j], n.items[2*i]
+		n.items[2*i+1], n.items[2*j+1] = n.items[2*j+1], n.items[2*i+1]
+		return root
+	}
+	n.items = append(n.items, n.items[2*i], n.items[2*i+1])
+	n.arg++
+	return root
+}
+
+// mutDepthInflation wraps a node in many single-element arrays.
+func mutDepthInflation(root *cborNode) *cborNode {
+	s, ok := pickSite(root, func(*cborNode) bool { return true })
+	if !ok {
+		return nil
+	}
+	depth := rngChoice([]int{16, 64, 256, 1024, 8192})
+	wrapped := s.node
+	for i := 0; i < depth; i++ {
+		wrapped = &cborNode{major: 4, arg: 1, items: []*cborNode{wrapped}}
+	}
+	return s.replace(root, wrapped)
+}
+
+// mutFieldDrop removes one array element (or map entry) and fixes the count.
+func mutFieldDrop(root *cborNode) *cborNode {
+	s, ok := pickSite(root, func(n *cborNode) bool { return isContainer(n) && len(n.items) > 0 })
+	if !ok {
+		return nil
+	}
+	n := s.node
+	step := 1
+	if n.major == 5 {
+		step = 2
+	}
+	i := rngIntn(len(n.items)/step) * step
+	n.items = append(n.items[:i:i], n.items[i+step:]...)
+	n.arg--
+	return root
+}
+
+// mutFieldDuplicate repeats one array element (or map entry) in place.
+func mutFieldDuplicate(root *cborNode) *cborNode {
+	s, ok := pickSite(root, func(n *cborNode) bool { return isContainer(n) && len(n.items) > 0 })
+	if !ok {
+		return nil
+	}
+	n := s.node
+	step := 1
+	if n.major == 5 {
+		step = 2
+	}
+	i := rngIntn(len(n.items)/step) * step
+	dup := append([]*cborNode{}, n.items[i:i+step]...)
+	n.items = append(n.items[:i+step:i+step], append(dup, n.items[i+step:]...)...)
+	n.arg++
+	return root
+}
+
+// mutCIDCodecSwap rewrites a tag-42 CID: codec, version or multibase prefix.
+func mutCIDCodecSwap(root *cborNode) *cborNode {
+	s, ok := pickSite(root, isCIDTag)
+	if !ok {
+		return nil
+	}
+	content := s.node.items[0]
+	raw := content.raw
+	// tag-42 bytes: 0x00 multibase prefix, then version varint, codec varint, multihash
+	if len(raw) < 3 || raw[0] != 0x00 {
+		return nil
+	}
+	version, vn := binary.Uvarint(raw[1:])
+	if vn <= 0 {
+		return nil
+	}
+	_, cn := binary.Uvarint(raw[1+vn:])
+	if cn <= 0 {
+		return nil
+	}
+	rest := raw[1+vn+cn:]
+
+	var out []byte
+	switch rngIntn(3) {
+	case 0: // codec swap: raw, dag-pb, dag-json, or an unassigned code
+		codec := rngChoice([]uint64{0x55, 0x70, 0x0129, 0x71, 0xb220, 0xffff})
+		out = binary.AppendUvarint([]byte{0x00}, version)
+		out = binary.AppendUvarint(out, codec)
+		out = append(out, rest...)
+	case 1: // version swap
+		out = append([]byte{0x00}, binary.AppendUvarint(nil, rngChoice([]uint64{0, 2, 0x7f}))...)
+		out = append(out, raw[1+vn:]...)
+	case 2: // missing multibase prefix
+		out = append([]byte{}, raw[1:]...)
+	}
+	content.raw = out
+	content.arg = uint64(len(out))
+	return root
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Rolling payload corpus
+//
+// Every payload that passes through recordPayload is written to
+// FUZZER_CORPUS_DIR as one JSON file named <seq>-<hash>.json, where hash is
+// the hex half of payloadHash (the same id the liveness oracle reports).
+// Only the newest FUZZER_CORPUS_MAX entries are kept. Payloads larger than
+// FUZZER_CORPUS_MAX_BYTES are logged with their hash but no bytes.
+// ---------------------------------------------------------------------------
+
+// Channels a payload can be sent on. GossipSub payloads use the topic name.
+const (
+	channelHello        = "hello"
+	channelXchgRequest  = "xchg-request"
+	channelXchgResponse = "xchg-response"
+	channelCertEx       = "f3-certex"
+	channelDHT          = "dht"
+	channelGossipRPC    = "gossipsub-rpc"
+)
+
+// corpusEntry is one persisted payload.
+type corpusEntry struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Attack    string    `json:"attack"`
+	Target    string    `json:"target,omitempty"`
+	Channel   string    `json:"channel"`
+	Hash      string    `json:"hash"`
+	Size      int       `json:"size"`
+	Truncated bool      `json:"truncated,omitempty"`
+	Data      []byte    `json:"data,omitempty"`
+}
+
+type payloadCorpus struct {
+	mu       sync.Mutex
+	dir      string
+	max      int
+	maxBytes int
+	seq      uint64
+	files    []string // oldest first
+}
+
+// corpus is nil when persistence is disabled (FUZZER_CORPUS_MAX=0).
+var corpus *payloadCorpus
+
+// openCorpus creates the corpus directory and picks up existing entries so
+// sequence numbers keep increasing across restarts.
+func openCorpus(dir string, max, maxBytes int) (*payloadCorpus, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create corpus dir: %w", err)
+	}
+	c := &payloadCorpus{dir: dir, max: max, maxBytes: maxBytes}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		seq, err := strconv.ParseUint(strings.SplitN(filepath.Base(name), "-", 2)[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		c.files = append(c.files, name)
+		if seq > c.seq {
+			c.seq = seq
+		}
+	}
+	c.trim()
+	log.Printf("[corpus] %s: %d existing entries, next seq=%d", dir, len(c.files), c.seq+1)
+	return c, nil
+}
+
+// save assigns the next sequence number and writes the entry to disk.
+func (c *payloadCorpus) save(e corpusEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	e.Seq = c.seq
+	e.Time = time.Now()
+	e.Size = len(e.Data)
+	if len(e.Data) > c.maxBytes {
+		e.Data = nil
+		e.Truncated = true
+	}
+
+	raw, err := json.Marshal(e)
+	if err != nil {
+		debugLog("[corpus] marshal seq=%d failed: %v", e.Seq, err)
+		return
+	}
+	name := filepath.Join(c.dir, fmt.Sprintf("%012d-%s.json", e.Seq, strings.SplitN(e.Hash, "/", 2)[0]))
+	if err := os.WriteFile(name, raw, 0o644); err != nil {
+		debugLog("[corpus] write %s failed: %v", name, err)
+		return
+	}
+	c.files = append(c.files, name)
+	c.trim()
+}
+
+// trim removes the oldest entries beyond the rolling limit. Caller holds mu
+// (or owns c exclusively).
+func (c *payloadCorpus) trim() {
+	for len(c.files) > c.max {
+		os.Remove(c.files[0])
+		c.files = c.files[1:]
+	}
+}
+
+// loadCorpusEntry resolves ref as a file path, or as a sequence number or
+// hash prefix inside dir (newest match wins).
+func loadCorpusEntry(dir, ref string) (*corpusEntry, error) {
+	path := ref
+	if _, err := os.Stat(path); err != nil {
+		names, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		sort.Strings(names)
+		prefix := strings.SplitN(ref, "/", 2)[0]
+		path = ""
+		for i := len(names) - 1; i >= 0; i-- {
+			parts := strings.SplitN(strings.TrimSuffix(filepath.Base(names[i]), ".json"), "-", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if seq, err := strconv.ParseUint(ref, 10, 64); err == nil {
+				if n, _ := strconv.ParseUint(parts[0], 10, 64); n == seq {
+					path = names[i]
+					break
+				}
+			}
+			if strings.HasPrefix(parts[1], prefix) {
+				path = names[i]
+				break
+			}
+		}
+		if path == "" {
+			return nil, fmt.Errorf("no corpus entry matches %q in %s", ref, dir)
+		}
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var e corpusEntry
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if e.Truncated {
+		return nil, fmt.Errorf("entry %s was too large to store (%d bytes)", path, e.Size)
+	}
+	return &e, nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/go-f3/gpbft"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/exchange"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// ---------------------------------------------------------------------------
+// Offline decoder fuzzing
+//
+// These harnesses feed protocol-fuzzer payloads straight into the decoders a
+// Lotus node runs on the same bytes, so decoder panics show up in seconds
+// without a devnet:
+//
+//   go test ./cmd/protocol-fuzzer -run '^$' -fuzz FuzzDecodeBlockMsg
+//
+// Seed corpora are harvested by running the untargeted attack functions
+// against an unreachable node with payloadTap set, plus the builder seeds
+// from mutation_attacks.go and a few structured mutations of each.
+// ---------------------------------------------------------------------------
+
+type fuzzSeedSet string
+
+const (
+	fuzzBlockMsg         fuzzSeedSet = "block-msg"
+	fuzzSignedMessage    fuzzSeedSet = "signed-message"
+	fuzzHello            fuzzSeedSet = "hello"
+	fuzzExchangeResponse fuzzSeedSet = "exchange-response"
+	fuzzPartialGMessage  fuzzSeedSet = "partial-gmessage"
+	fuzzF3Compressed     fuzzSeedSet = "f3-compressed"
+)
+
+const fuzzMutantsPerSeed = 2
+
+var (
+	harvestOnce sync.Once
+	fuzzSeeds   map[fuzzSeedSet][][]byte
+)
+
+// routeFuzzSeed files a tapped payload under the decoder that would see it.
+func routeFuzzSeed(channel string, data []byte) {
+	data = append([]byte(nil), data...)
+	switch {
+	case strings.HasPrefix(channel, "/fil/blocks/"):
+		fuzzSeeds[fuzzBlockMsg] = append(fuzzSeeds[fuzzBlockMsg], data)
+	case strings.HasPrefix(channel, "/fil/msgs/"):
+		fuzzSeeds[fuzzSignedMessage] = append(fuzzSeeds[fuzzSignedMessage], data)
+	case strings.HasPrefix(channel, "/f3/granite/"):
+		fuzzSeeds[fuzzF3Compressed] = append(fuzzSeeds[fuzzF3Compressed], data)
+		if raw, err := zstdDecompress(data); err == nil {
+			fuzzSeeds[fuzzPartialGMessage] = append(fuzzSeeds[fuzzPartialGMessage], raw)
+		}
+	case channel == channelHello:
+		fuzzSeeds[fuzzHello] = append(fuzzSeeds[fuzzHello], data)
+	case channel == channelXchgResponse:
+		fuzzSeeds[fuzzExchangeResponse] = append(fuzzSeeds[fuzzExchangeResponse], data)
+	}
+}
+
+// harvestFuzzSeeds runs every untargeted attack once with the network
+// pointed at a node that refuses connections, collecting what they send.
+func harvestFuzzSeeds(tb testing.TB) map[fuzzSeedSet][][]byte {
+	harvestOnce.Do(func() {
+		fuzzSeeds = make(map[fuzzSeedSet][][]byte)
+
+		priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			tb.Fatalf("generate key: %v", err)
+		}
+		id, _ := peer.IDFromPrivateKey(priv)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		os.Setenv("STRESS_RPC_PORT", "1") // fetchChainHead fails fast
+		networkName = "2k"
+		targets = []TargetNode{{Name: "127.0.0.1", AddrInfo: peer.AddrInfo{ID: id}}}
+		pool = newIdentityPool(1)
+		defer pool.CloseAll()
+		gossipPub = newGossipPublisher(1)
+		defer gossipPub.close()
+
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+		payloadTap = routeFuzzSeed
+		defer func() { payloadTap = nil }()
+
+		var attacks []namedAttack
+		attacks = append(attacks, getAllGossipAttacks()...)
+		attacks = append(attacks, getAllCBORBombAttacks()...)
+		attacks = append(attacks, getAllF3Attacks()...)
+		attacks = append(attacks, getAllHelloAttacks()...)
+		attacks = append(attacks, getAllFVMAddressAttacks()...)
+		for _, a := range attacks {
+			if a.fn != nil {
+				a.fn()
+			}
+		}
+
+		// Builder seeds cover the types whose attacks only build payloads
+		// once a live head is available.
+		builders := map[fuzzSeedSet]seedKind{
+			fuzzBlockMsg:         seedBlockMsg,
+			fuzzSignedMessage:    seedSignedMessage,
+			fuzzHello:            seedHello,
+			fuzzExchangeResponse: seedExchangeResponse,
+			fuzzPartialGMessage:  seedGMessage,
+		}
+		for set, kind := range builders {
+			fuzzSeeds[set] = append(fuzzSeeds[set], fallbackSeed(kind))
+		}
+		fuzzSeeds[fuzzF3Compressed] = append(fuzzSeeds[fuzzF3Compressed], zstdCompress(fallbackSeed(seedGMessage)))
+
+		for set, seeds := range fuzzSeeds {
+			for _, seed := range seeds {
+				for i := 0; i < fuzzMutantsPerSeed; i++ {
+					if m, _, err := mutateCBOR(seed, 1+i); err == nil {
+						fuzzSeeds[set] = append(fuzzSeeds[set], m)
+					}
+				}
+			}
+		}
+	})
+	return fuzzSeeds
+}
+
+func addFuzzSeeds(f *testing.F, set fuzzSeedSet) {
+	seeds := harvestFuzzSeeds(f)[set]
+	if len(seeds) == 0 {
+		f.Fatalf("no %s seeds harvested", set)
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+}
+
+// FuzzDecodeBlockMsg mirrors the /fil/blocks pubsub path: decode, then the
+// header CID the validator computes first.
+func FuzzDecodeBlockMsg(f *testing.F) {
+	addFuzzSeeds(f, fuzzBlockMsg)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		bm, err := types.DecodeBlockMsg(data)
+		if err != nil || bm.Header == nil {
+			return
+		}
+		_ = bm.Cid()
+	})
+}
+
+// FuzzDecodeSignedMessage mirrors the /fil/msgs pubsub path.
+func FuzzDecodeSignedMessage(f *testing.F) {
+	addFuzzSeeds(f, fuzzSignedMessage)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sm, err := types.DecodeSignedMessage(data)
+		if err != nil {
+			return
+		}
+		_ = sm.Cid()
+		_ = sm.ChainLength()
+	})
+}
+
+// FuzzHelloMessage mirrors the Hello stream handler's ReadCborRPC.
+func FuzzHelloMessage(f *testing.F) {
+	addFuzzSeeds(f, fuzzHello)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var hmsg HelloMessage
+		if err := hmsg.UnmarshalCBOR(bytes.NewReader(data)); err != nil {
+			return
+		}
+		_ = types.NewTipSetKey(hmsg.HeaviestTipSet...)
+	})
+}
+
+// HelloMessage and its UnmarshalCBOR are lotus/node/hello's type and
+// cbor-gen output (lotus v1.34.3, cbor_gen.go), copied unchanged except
+// xerrors.Errorf -> fmt.Errorf. That package links filecoin-ffi, which this
+// module does not build against. Re-copy when bumping lotus.
+type HelloMessage struct {
+	HeaviestTipSet       []cid.Cid
+	HeaviestTipSetHeight abi.ChainEpoch
+	HeaviestTipSetWeight big.Int
+	GenesisHash          cid.Cid
+}
+
+func (t *HelloMessage) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = HelloMessage{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajArray {
+		return fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 4 {
+		return fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.HeaviestTipSet ([]cid.Cid) (slice)
+
+	maj, extra, err = cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+
+	if extra > 8192 {
+		return fmt.Errorf("t.HeaviestTipSet: array too large (%d)", extra)
+	}
+
+	if maj != cbg.MajArray {
+		return fmt.Errorf("expected cbor array")
+	}
+
+	if extra > 0 {
+		t.HeaviestTipSet = make([]cid.Cid, extra)
+	}
+
+	for i := 0; i < int(extra); i++ {
+		{
+			var maj byte
+			var extra uint64
+			var err error
+			_ = maj
+			_ = extra
+			_ = err
+
+			{
+
+				c, err := cbg.ReadCid(cr)
+				if err != nil {
+					return fmt.Errorf("failed to read cid field t.HeaviestTipSet[i]: %w", err)
+				}
+
+				t.HeaviestTipSet[i] = c
+
+			}
+
+		}
+	}
+	// t.HeaviestTipSetHeight (abi.ChainEpoch) (int64)
+	{
+		maj, extra, err := cr.ReadHeader()
+		if err != nil {
+			return err
+		}
+		var extraI int64
+		switch maj {
+		case cbg.MajUnsignedInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return fmt.Errorf("int64 positive overflow")
+			}
+		case cbg.MajNegativeInt:
+			extraI = int64(extra)
+			if extraI < 0 {
+				return fmt.Errorf("int64 negative overflow")
+			}
+			extraI = -1 - extraI
+		default:
+			return fmt.Errorf("wrong type for int64 field: %d", maj)
+		}
+
+		t.HeaviestTipSetHeight = abi.ChainEpoch(extraI)
+	}
+	// t.HeaviestTipSetWeight (big.Int) (struct)
+
+	{
+
+		if err := t.HeaviestTipSetWeight.UnmarshalCBOR(cr); err != nil {
+			return fmt.Errorf("unmarshaling t.HeaviestTipSetWeight: %w", err)
+		}
+
+	}
+	// t.GenesisHash (cid.Cid) (struct)
+
+	{
+
+		c, err := cbg.ReadCid(cr)
+		if err != nil {
+			return fmt.Errorf("failed to read cid field t.GenesisHash: %w", err)
+		}
+
+		t.GenesisHash = c
+
+	}
+	return nil
+}
+
+// FuzzExchangeResponse mirrors the ChainExchange client reading a peer's
+// response.
+func FuzzExchangeResponse(f *testing.F) {
+	addFuzzSeeds(f, fuzzExchangeResponse)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var resp exchange.Response
+		_ = resp.UnmarshalCBOR(bytes.NewReader(data))
+	})
+}
+
+// FuzzPartialGMessage mirrors the CBOR half of the F3 pubsub path, after
+// zstd. Inputs are the uncompressed CBOR so mutations stay structural.
+func FuzzPartialGMessage(f *testing.F) {
+	addFuzzSeeds(f, fuzzPartialGMessage)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var pgmsg gpbft.PartialGMessage
+		_ = pgmsg.UnmarshalCBOR(bytes.NewReader(data))
+	})
+}
+
+// FuzzF3Compressed mirrors the whole F3 pubsub path on the wire bytes: zstd
+// (1 MiB decoder limit, as go-f3 configures it), then PartialGMessage CBOR.
+func FuzzF3Compressed(f *testing.F) {
+	addFuzzSeeds(f, fuzzF3Compressed)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		raw, err := zstdDecompress(data)
+		if err != nil {
+			return
+		}
+		var pgmsg gpbft.PartialGMessage
+		_ = pgmsg.UnmarshalCBOR(bytes.NewReader(raw))
+	})
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	dhtpb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"google.golang.org/protobuf/proto"
+)
+
+// ---------------------------------------------------------------------------
+// Kademlia DHT Attacks
+//
+// Lotus and Forest run a Kademlia DHT on /fil/kad/<network>/kad/1.0.0.
+// Wire format: varint-length-prefixed dht.pb.Message protobufs, several
+// requests per stream, limited to 4 MiB each (network.MessageSizeMax).
+// Vectors:
+//   - malformed and oversized FIND_NODE, GET_PROVIDERS and PUT_VALUE requests
+//   - broken framing: huge or overflowing length prefixes, truncated bodies
+//   - sybil flood: fresh pool hosts that answer DHT queries join the
+//     victim's routing table
+//   - poisoned closer-peers: the sybils answer the victim's lookups with
+//     bogus, self-referencing, hijacked and oversized peer lists
+//
+// Every vector probes the victim's routing table for the other nodes before
+// and after. Honest peers are never replaceable in go-libp2p-kbucket and
+// rust-libp2p only evicts disconnected entries, so an honest peer still
+// connected to the victim must still be in its table afterwards.
+// ---------------------------------------------------------------------------
+
+const (
+	dhtMaxMessage     = 4 << 20
+	dhtStreamTimeout  = 10 * time.Second
+	dhtProbePrefixLen = 2 // bytes of Kademlia ID a probe key shares with its peer
+	dhtPoisonMaxPeers = 5000
+)
+
+func dhtProtocol() protocol.ID {
+	return protocol.ID(fmt.Sprintf("/fil/kad/%s/kad/1.0.0", networkName))
+}
+
+func getAllDHTAttacks() []namedAttack {
+	return []namedAttack{
+		{name: "dht/all-find-node-with-malformed-key", targetedFn: dhtChecked(dhtMalformedFindNode), targetType: nodeAny},
+		{name: "dht/all-get-providers-with-malformed-key", targetedFn: dhtChecked(dhtMalformedGetProviders), targetType: nodeAny},
+		{name: "dht/all-put-value-with-malformed-record", targetedFn: dhtChecked(dhtMalformedPutValue), targetType: nodeAny},
+		{name: "dht/all-message-with-broken-framing", targetedFn: dhtChecked(dhtBrokenFraming), targetType: nodeAny},
+		{name: "dht/all-routing-table-sybil-flood", targetedFn: dhtChecked(func(t TargetNode) { dhtSybilFlood(ctx, t, false) }), targetType: nodeAny},
+		{name: "dht/all-lookup-answered-with-poisoned-closer-peers", targetedFn: dhtChecked(func(t TargetNode) { dhtSybilFlood(ctx, t, true) }), targetType: nodeAny},
+	}
+}
+
+// dhtChecked wraps a DHT vector with the routing-table health check.
+func dhtChecked(attack func(TargetNode)) func(TargetNode) {
+	return func(t TargetNode) {
+		before := probeRoutingTable(t)
+		assert.Sometimes(len(before) > 0, "DHT routing table is probed before a DHT attack", map[string]any{"node": t.Name})
+		attack(t)
+		checkRoutingTable(t, before)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Wire helpers
+// ---------------------------------------------------------------------------
+
+func dhtFrame(body []byte) []byte {
+	return append(binary.AppendUvarint(nil, uint64(len(body))), body...)
+}
+
+func dhtMarshal(m *dhtpb.Message) []byte {
+	b, _ := proto.Marshal(m)
+	return b
+}
+
+// sendDHTPayload records data as an attack payload and sends it to target.
+func sendDHTPayload(target TargetNode, data []byte, replies int) []*dhtpb.Message {
+	recordPayload(channelDHT, data)
+	return dhtExchange(target, data, replies)
+}
+
+// dhtExchange writes raw bytes on a DHT stream to target and reads up to
+// replies length-prefixed responses back.
+func dhtExchange(target TargetNode, data []byte, replies int) []*dhtpb.Message {
+	h, err := pool.GetForStream(ctx)
+	if err != nil {
+		debugLog("[dht] stream host failed: %v", err)
+		return nil
+	}
+	streamCtx, cancel := context.WithTimeout(ctx, dhtStreamTimeout)
+	defer cancel()
+	if err := h.Connect(streamCtx, target.AddrInfo); err != nil {
+		debugLog("[dht] connect to %s failed: %v", target.Name, err)
+		return nil
+	}
+	s, err := h.NewStream(streamCtx, target.AddrInfo.ID, dhtProtocol())
+	if err != nil {
+		debugLog("[dht] stream to %s failed: %v", target.Name, err)
+		return nil
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(dhtStreamTimeout))
+
+	if _, err := s.Write(data); err != nil {
+		debugLog("[dht] write to %s failed: %v", target.Name, err)
+		return nil
+	}
+
+	var out []*dhtpb.Message
+	r := bufio.NewReader(s)
+	for i := 0; i < replies; i++ {
+		m, err := readDHTMessage(r)
+		if err != nil {
+			debugLog("[dht] read reply %d from %s: %v", i, target.Name, err)
+			break
+		}
+		out = append(out, m)
+	}
+	s.CloseWrite()
+	return out
+}
+
+func readDHTMessage(r *bufio.Reader) (*dhtpb.Message, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > dhtMaxMessage {
+		return nil, fmt.Errorf("message of %d bytes exceeds limit", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	var m dhtpb.Message
+	if err := proto.Unmarshal(buf, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// sendDHTRequests frames and sends each request on one stream.
+func sendDHTRequests(target TargetNode, tag string, reqs []*dhtpb.Message) {
+	var data []byte
+	for _, m := range reqs {
+		data = append(data, dhtFrame(dhtMarshal(m))...)
+	}
+	replies := sendDHTPayload(target, data, len(reqs))
+	log.Printf("[dht] %s: %d requests (%d bytes) to %s, %d replies", tag, len(reqs), len(data), target.Name, len(replies))
+}
+
+// ---------------------------------------------------------------------------
+// Malformed requests
+// ---------------------------------------------------------------------------
+
+// dhtBadKey returns a key that is empty, truncated, oversized or names a
+// peer the handler should never return.
+func dhtBadKey(target TargetNode) []byte {
+	switch rngIntn(7) {
+	case 0:
+		return nil
+	case 1:
+		return randomBytes(1)
+	case 2: // just over GET_PROVIDERS' 80-byte limit
+		return randomBytes(81)
+	case 3:
+		return randomBytes(64*1024 + rngIntn(1<<20))
+	case 4: // identity multihash claiming more bytes than follow
+		return []byte{0x00, 0x24, 0x08, 0x01}
+	case 5:
+		return []byte(target.AddrInfo.ID)
+	default:
+		return randomCID().Bytes()
+	}
+}
+
+func dhtMalformedFindNode(target TargetNode) {
+	var reqs []*dhtpb.Message
+	for i := 0; i < 1+rngIntn(6); i++ {
+		m := &dhtpb.Message{Type: dhtpb.Message_FIND_NODE, Key: dhtBadKey(target)}
+		switch rngIntn(3) {
+		case 0:
+			m.ClusterLevelRaw = int32(rngChoice([]int{-1, math.MaxInt32, math.MinInt32}))
+		case 1: // closer peers have no meaning in a request
+			m.CloserPeers = poisonedCloserPeers(target, nil)
+		}
+		reqs = append(reqs, m)
+	}
+	sendDHTRequests(target, "malformed FIND_NODE", reqs)
+}
+
+func dhtMalformedGetProviders(target TargetNode) {
+	var reqs []*dhtpb.Message
+	for i := 0; i < 1+rngIntn(6); i++ {
+		m := &dhtpb.Message{Type: dhtpb.Message_GET_PROVIDERS, Key: dhtBadKey(target)}
+		if rngIntn(2) == 0 {
+			m.ProviderPeers = poisonedCloserPeers(target, nil)
+		}
+		reqs = append(reqs, m)
+	}
+	sendDHTRequests(target, "malformed GET_PROVIDERS", reqs)
+}
+
+func dhtMalformedPutValue(target TargetNode) {
+	var reqs []*dhtpb.Message
+	for i := 0; i < 1+rngIntn(4); i++ {
+		key := []byte("/pk/" + string(randomBytes(34)))
+		rec := &recpb.Record{Key: key, Value: randomBytes(64)}
+		switch rngIntn(6) {
+		case 0:
+			rec = nil
+		case 1: // record key differs from the message key
+			rec.Key = []byte("/pk/" + string(randomBytes(34)))
+		case 2:
+			key = []byte("/fuzz/" + string(randomBytes(8)))
+			rec.Key = key
+		case 3: // largest value a 4 MiB frame can hold
+			rec.Value = randomBytes(dhtMaxMessage - 1024)
+		case 4:
+			rec.TimeReceived = string(randomBytes(1 + rngIntn(256)))
+		default:
+			key = []byte("/ipns/" + string(randomBytes(38)))
+			rec.Key = key
+		}
+		reqs = append(reqs, &dhtpb.Message{Type: dhtpb.Message_PUT_VALUE, Key: key, Record: rec})
+	}
+	sendDHTRequests(target, "malformed PUT_VALUE", reqs)
+}
+
+func dhtBrokenFraming(target TargetNode) {
+	valid := dhtMarshal(&dhtpb.Message{Type: dhtpb.Message_FIND_NODE, Key: []byte(target.AddrInfo.ID)})
+	var data []byte
+	switch rngIntn(6) {
+	case 0: // length prefix past the 4 MiB limit
+		data = append(binary.AppendUvarint(nil, dhtMaxMessage+1+uint64(rngIntn(1<<30))), valid...)
+	case 1: // varint that never terminates
+		data = bytes.Repeat([]byte{0xff}, 11)
+	case 2: // declared length longer than the body
+		data = append(binary.AppendUvarint(nil, uint64(len(valid)+1+rngIntn(1000))), valid...)
+	case 3: // zero-length frames
+		data = bytes.Repeat([]byte{0x00}, 1+rngIntn(1000))
+	case 4: // junk protobuf
+		data = dhtFrame(randomBytes(1 + rngIntn(4096)))
+	default: // unknown message type
+		m := &dhtpb.Message{Type: dhtpb.Message_MessageType(6 + rngIntn(1000)), Key: randomBytes(32)}
+		data = dhtFrame(dhtMarshal(m))
+	}
+	replies := sendDHTPayload(target, data, 1)
+	log.Printf("[dht] broken framing: %d bytes to %s, %d replies", len(data), target.Name, len(replies))
+}
+
+// ---------------------------------------------------------------------------
+// Sybils
+// ---------------------------------------------------------------------------
+
+// poisonedCloserPeers returns a peer list that is wrong in one way: the
+// victim itself, unparseable IDs, honest IDs at sybil addresses, malformed
+// or unroutable addresses, or thousands of duplicates.
+func poisonedCloserPeers(victim TargetNode, sybils []peer.AddrInfo) []*dhtpb.Message_Peer {
+	addrBytes := func(addrs []ma.Multiaddr) [][]byte {
+		var out [][]byte
+		for _, a := range addrs {
+			out = append(out, a.Bytes())
+		}
+		return out
+	}
+	sybilAddrs := [][]byte{randomBytes(8)}
+	if len(sybils) > 0 {
+		sybilAddrs = addrBytes(rngChoice(sybils).Addrs)
+	}
+
+	var peers []*dhtpb.Message_Peer
+	switch rngIntn(6) {
+	case 0:
+		peers = append(peers, &dhtpb.Message_Peer{Id: []byte(victim.AddrInfo.ID), Addrs: addrBytes(victim.AddrInfo.Addrs)})
+	case 1:
+		for i := 0; i < 1+rngIntn(50); i++ {
+			peers = append(peers, &dhtpb.Message_Peer{Id: randomBytes(rngIntn(100)), Addrs: sybilAddrs})
+		}
+	case 2: // address hijack: real node IDs pointing at a sybil
+		for _, t := range targets {
+			peers = append(peers, &dhtpb.Message_Peer{Id: []byte(t.AddrInfo.ID), Addrs: sybilAddrs})
+		}
+	case 3:
+		for _, s := range sybils {
+			peers = append(peers, &dhtpb.Message_Peer{Id: []byte(s.ID), Addrs: [][]byte{randomBytes(1 + rngIntn(64))}})
+		}
+	case 4:
+		for _, s := range sybils {
+			peers = append(peers, &dhtpb.Message_Peer{Id: []byte(s.ID), Addrs: [][]byte{
+				ma.StringCast("/ip4/127.0.0.1/tcp/1").Bytes(),
+				ma.StringCast("/ip4/0.0.0.0/tcp/0").Bytes(),
+			}})
+		}
+	default:
+		dup := &dhtpb.Message_Peer{Id: randomBytes(38), Addrs: sybilAddrs}
+		if len(sybils) > 0 {
+			dup.Id = []byte(rngChoice(sybils).ID)
+		}
+		for i := 0; i < dhtPoisonMaxPeers; i++ {
+			peers = append(peers, dup)
+		}
+	}
+	return peers
+}
+
+// honestCloserPeers lists the other sybils, enough to pass go-libp2p-kad-dht's
+// lookup check that admits a peer to the routing table.
+func honestCloserPeers(self peer.ID, sybils []peer.AddrInfo) []*dhtpb.Message_Peer {
+	var peers []*dhtpb.Message_Peer
+	for _, s := range sybils {
+		if s.ID == self {
+			continue
+		}
+		p := &dhtpb.Message_Peer{Id: []byte(s.ID), Connection: dhtpb.Message_CAN_CONNECT}
+		for _, a := range s.Addrs {
+			p.Addrs = append(p.Addrs, a.Bytes())
+		}
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// serveSybilDHT answers every request on a stream from the victim.
+func serveSybilDHT(s network.Stream, victim TargetNode, self peer.ID, sybils []peer.AddrInfo, poison bool, served *atomic.Int64) {
+	defer s.Close()
+	r := bufio.NewReader(s)
+	for {
+		s.SetDeadline(time.Now().Add(dhtStreamTimeout))
+		req, err := readDHTMessage(r)
+		if err != nil {
+			return
+		}
+		resp := &dhtpb.Message{Type: req.Type, Key: req.Key, ClusterLevelRaw: req.ClusterLevelRaw}
+		switch req.Type {
+		case dhtpb.Message_FIND_NODE, dhtpb.Message_GET_PROVIDERS, dhtpb.Message_GET_VALUE:
+			if poison {
+				resp.CloserPeers = poisonedCloserPeers(victim, sybils)
+			} else {
+				resp.CloserPeers = honestCloserPeers(self, sybils)
+			}
+		case dhtpb.Message_PUT_VALUE:
+			resp.Record = req.Record
+		}
+		if _, err := s.Write(dhtFrame(dhtMarshal(resp))); err != nil {
+			return
+		}
+		served.Add(1)
+	}
+}
+
+// dhtSybilFlood connects FUZZER_DHT_SYBILS fresh identities that speak the
+// DHT to the victim and holds them for FUZZER_DHT_HOLD_SEC. With poison,
+// their answers carry poisoned closer-peers lists and the victim is asked to
+// run lookups through them.
+func dhtSybilFlood(ctx context.Context, victim TargetNode, poison bool) {
+	n := envInt("FUZZER_DHT_SYBILS", 16)
+	var hosts []host.Host
+	defer func() {
+		for _, h := range hosts {
+			h.Close()
+		}
+	}()
+	var infos []peer.AddrInfo
+	for i := 0; i < n; i++ {
+		h, err := pool.GetFresh(ctx)
+		if err != nil {
+			debugLog("[dht] create sybil failed: %v", err)
+			break
+		}
+		hosts = append(hosts, h)
+		infos = append(infos, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	}
+
+	var served atomic.Int64
+	connected := 0
+	for _, h := range hosts {
+		self := h.ID()
+		h.SetStreamHandler(dhtProtocol(), func(s network.Stream) {
+			serveSybilDHT(s, victim, self, infos, poison, &served)
+		})
+		connectCtx, cancel := context.WithTimeout(ctx, dhtStreamTimeout)
+		err := h.Connect(connectCtx, victim.AddrInfo)
+		cancel()
+		if err != nil {
+			debugLog("[dht] sybil connect to %s failed: %v", victim.Name, err)
+			continue
+		}
+		connected++
+	}
+	if connected == 0 {
+		return
+	}
+
+	if poison {
+		// Lookups for IDs nobody has walk the whole table, sybils included.
+		for i := 0; i < 3; i++ {
+			var out peer.AddrInfo
+			if err := callRPC(victim.Name, "Filecoin.NetFindPeer", []any{rngChoice(infos).ID.String()}, &out); err != nil {
+				debugLog("[dht] NetFindPeer on %s: %v", victim.Name, err)
+			}
+		}
+	}
+
+	time.Sleep(time.Duration(envInt("FUZZER_DHT_HOLD_SEC", 10)) * time.Second)
+	log.Printf("[dht] %d/%d sybils connected to %s (poison=%v), served %d requests",
+		connected, len(hosts), victim.Name, poison, served.Load())
+}
+
+// ---------------------------------------------------------------------------
+// Routing-table health check
+// ---------------------------------------------------------------------------
+
+// dhtProbeKey returns a key whose Kademlia ID shares its first
+// dhtProbePrefixLen bytes with p's. FIND_NODE for it returns p first if p
+// is in the routing table; unlike a FIND_NODE for p itself, it doesn't fall
+// back to the peerstore.
+func dhtProbeKey(p peer.ID) []byte {
+	want := sha256.Sum256([]byte(p))
+	key := append(randomBytes(8), make([]byte, 8)...)
+	for i := uint64(0); ; i++ {
+		binary.BigEndian.PutUint64(key[8:], i)
+		got := sha256.Sum256(key)
+		if bytes.Equal(got[:dhtProbePrefixLen], want[:dhtProbePrefixLen]) {
+			return key
+		}
+	}
+}
+
+// probeRoutingTable reports which other targets are in victim's routing
+// table. It returns nil if the victim doesn't answer DHT requests.
+func probeRoutingTable(victim TargetNode) map[peer.ID]bool {
+	var honest []peer.ID
+	var reqs []*dhtpb.Message
+	for _, t := range targets {
+		if t.AddrInfo.ID == victim.AddrInfo.ID {
+			continue
+		}
+		honest = append(honest, t.AddrInfo.ID)
+		reqs = append(reqs, &dhtpb.Message{Type: dhtpb.Message_FIND_NODE, Key: dhtProbeKey(t.AddrInfo.ID)})
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	var data []byte
+	for _, m := range reqs {
+		data = append(data, dhtFrame(dhtMarshal(m))...)
+	}
+	replies := dhtExchange(victim, data, len(reqs))
+	if len(replies) != len(reqs) {
+		return nil
+	}
+
+	present := make(map[peer.ID]bool)
+	for i, resp := range replies {
+		for _, p := range resp.CloserPeers {
+			if peer.ID(p.Id) == honest[i] {
+				present[honest[i]] = true
+			}
+		}
+	}
+	return present
+}
+
+// checkRoutingTable asserts every honest peer that was in the victim's
+// table before the attack, and is still connected to it, is still there.
+func checkRoutingTable(victim TargetNode, before map[peer.ID]bool) {
+	if len(before) == 0 {
+		return
+	}
+	// Stress-engine partitions disconnect peers, which may rightly drop them.
+	var blocked netBlockList
+	if err := callRPC(victim.Name, "Filecoin.NetBlockList", nil, &blocked); err == nil && len(blocked.Peers) > 0 {
+		debugLog("[dht] %s has %d blocked peers, skipping routing-table check", victim.Name, len(blocked.Peers))
+		return
+	}
+	var conns []peer.AddrInfo
+	if err := callRPC(victim.Name, "Filecoin.NetPeers", nil, &conns); err != nil {
+		debugLog("[dht] NetPeers on %s failed: %v", victim.Name, err)
+		return
+	}
+	connected := make(map[peer.ID]bool, len(conns))
+	for _, c := range conns {
+		connected[c.ID] = true
+	}
+
+	after := probeRoutingTable(victim)
+	if after == nil {
+		debugLog("[dht] %s stopped answering routing-table probes", victim.Name)
+		return
+	}
+	var missing []string
+	checked := 0
+	for p := range before {
+		if !connected[p] {
+			continue
+		}
+		checked++
+		if !after[p] {
+			missing = append(missing, p.String())
+		}
+	}
+	if checked == 0 {
+		return
+	}
+	assert.Reachable("DHT routing-table check compares honest peers after an attack", map[string]any{
+		"node":    victim.Name,
+		"checked": checked,
+	})
+	assert.Always(len(missing) == 0, "Honest peers stay in a node's DHT routing table through DHT attacks", map[string]any{
+		"node":    victim.Name,
+		"checked": checked,
+		"missing": missing,
+	})
+}
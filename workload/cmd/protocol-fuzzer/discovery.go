@@ -179,6 +179,66 @@ func fetchChainHead(targetName string) *chainHeadInfo {
 	return info
 }
 
+// callRPC invokes a JSON-RPC method on the named node using the JWT its
+// startup script wrote to {devgenDir}/{name}/{name}-jwt, so admin methods
+// like NetBlockAdd are permitted. The result is decoded into out (if non-nil).
+func callRPC(targetName, method string, params []any, out any) error {
+	type rpcRequest struct {
+		Jsonrpc string `json:"jsonrpc"`
+		Method  string `json:"method"`
+		Params  []any  `json:"params"`
+		ID      int    `json:"id"`
+	}
+	type rpcResponse struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	if params == nil {
+		params = []any{}
+	}
+	reqBody, _ := json.Marshal(rpcRequest{
+		Jsonrpc: "2.0",
+		Method:  method,
+		Params:  params,
+		ID:      1,
+	})
+
+	req, err := http.NewRequest(http.MethodPost, rpcURLForTarget(targetName), bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tokenPath := fmt.Sprintf("%s/%s/%s-jwt", devgenDir, targetName, targetName)
+	if token, err := os.ReadFile(tokenPath); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s on %s: %w", method, targetName, err)
+	}
+	defer resp.Body.Close()
+
+	var rpcResp rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return fmt.Errorf("%s on %s: decode: %w", method, targetName, err)
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("%s on %s: %s", method, targetName, rpcResp.Error.Message)
+	}
+	if out != nil && len(rpcResp.Result) > 0 {
+		if err := json.Unmarshal(rpcResp.Result, out); err != nil {
+			return fmt.Errorf("%s on %s: decode result: %w", method, targetName, err)
+		}
+	}
+	return nil
+}
+
 // discoverGenesisCID fetches the genesis CID from a Lotus node's RPC endpoint.
 // Uses unauthenticated HTTP POST to Filecoin.ChainGetGenesis.
 func discoverGenesisCID(rpcURL string) string {
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ---------------------------------------------------------------------------
+// Eclipse Attack
+//
+// Stress-engine partitions only cut honest links; the victim is never
+// surrounded by malicious peers. This vector does both at once:
+// 1. NetBlockAdd every honest peer on the victim (other targets + NetPeers)
+// 2. Several fresh pool hosts connect and send Hello claiming a heavier
+//    fabricated chain built on top of the victim's real head
+// 3. Their ChainExchange handlers serve that chain on request
+// 4. While eclipsed, the victim's head must never contain a fabricated block
+// 5. NetBlockRemove + NetConnect the honest peers; the victim must resume
+//    syncing unless the honest network itself stalled
+// 6. A victim that misses the short recovery window is watched in the
+//    background; still stuck after FUZZER_ORACLE_OUTAGE_SEC is a violation
+// ---------------------------------------------------------------------------
+
+const (
+	eclipseFakeChainLen   = 5
+	eclipseBaseWeight     = 999999999
+	eclipsePollInterval   = 2 * time.Second
+	eclipseHelloEvery     = 5 // re-announce every N polls
+	eclipseSyncTolerance  = 5 // epochs behind the honest tip still counted as synced
+	eclipseHandlerTimeout = 2 * time.Second
+)
+
+func getAllEclipseAttacks() []namedAttack {
+	return []namedAttack{
+		{
+			name:       "eclipse/lotus-honest-peers-blocked-heavier-fake-chain",
+			targetedFn: func(t TargetNode) { runEclipseAttack(ctx, t) },
+			targetType: nodeLotus, // NetBlockAdd is a Lotus admin API
+		},
+	}
+}
+
+// netBlockList mirrors api.NetBlockList for the NetBlock* RPC methods.
+type netBlockList struct {
+	Peers     []peer.ID
+	IPAddrs   []string
+	IPSubnets []string
+}
+
+// eclipseChain is a fabricated chain extending a real head, stored tip-first
+// to match the order ChainExchange responses use.
+type eclipseChain struct {
+	blocks [][]byte
+	cids   []cid.Cid
+	height uint64
+	weight uint64
+}
+
+// buildEclipseChain builds n single-block tipsets on top of head, each one
+// claiming more parent weight than the last.
+func buildEclipseChain(head *chainHeadInfo, n int) *eclipseChain {
+	ec := &eclipseChain{}
+	parents := head.CIDs
+	for i := 1; i <= n; i++ {
+		ec.height = head.Height + uint64(i)
+		ec.weight = eclipseBaseWeight + uint64(i)
+		b := buildBlockHeaderCBOR(blockHeaderOpts{
+			overrideParentCIDs: parents,
+			overrideHeight:     ec.height,
+			overrideWeight:     ec.weight,
+		})
+		c := blockCIDFromCBOR(b)
+		ec.blocks = append([][]byte{b}, ec.blocks...)
+		ec.cids = append([]cid.Cid{c}, ec.cids...)
+		parents = []cid.Cid{c}
+	}
+	return ec
+}
+
+// responseFor serves the fabricated chain starting at whichever of our CIDs
+// the request names, so follow-up fetches walking back toward the fork
+// point get a consistent answer.
+func (ec *eclipseChain) responseFor(req []byte) []byte {
+	start := 0
+	for i, c := range ec.cids {
+		if bytes.Contains(req, c.Bytes()) {
+			start = i
+			break
+		}
+	}
+	var chain [][]byte
+	for _, b := range ec.blocks[start:] {
+		chain = append(chain, buildBSTipSetCBOR([][]byte{b}, buildEmptyCompactedMsgsCBOR()))
+	}
+	return okResponse(chain...)
+}
+
+// fakeIn returns the first fabricated CID found in the given tipset key.
+func (ec *eclipseChain) fakeIn(key []cid.Cid) (cid.Cid, bool) {
+	for _, k := range key {
+		for _, c := range ec.cids {
+			if k.Equals(c) {
+				return c, true
+			}
+		}
+	}
+	return cid.Undef, false
+}
+
+// eclipseHonestPeers collects every peer the victim should be cut off from:
+// all other discovered nodes plus whatever it is currently connected to.
+func eclipseHonestPeers(victim TargetNode) []peer.ID {
+	seen := map[peer.ID]bool{victim.AddrInfo.ID: true}
+	var out []peer.ID
+	add := func(id peer.ID) {
+		if id == "" || seen[id] {
+			return
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+
+	for _, t := range targets {
+		add(t.AddrInfo.ID)
+	}
+	var connected []peer.AddrInfo
+	if err := callRPC(victim.Name, "Filecoin.NetPeers", nil, &connected); err != nil {
+		debugLog("[eclipse] NetPeers on %s failed: %v", victim.Name, err)
+	}
+	for _, ai := range connected {
+		add(ai.ID)
+	}
+	return out
+}
+
+// eclipseBlocksToLift re-reads the victim's block list before healing and
+// returns the peers this attack blocked that are still blocked. foreign is
+// set when someone else (a stress-engine partition) has started blocking on
+// or against the victim since: its entries can't be told apart from ours, so
+// nothing should be lifted.
+func eclipseBlocksToLift(victim TargetNode, honest []peer.ID) ([]peer.ID, bool) {
+	var current netBlockList
+	if err := callRPC(victim.Name, "Filecoin.NetBlockList", nil, &current); err != nil {
+		log.Printf("[eclipse] WARN: NetBlockList on %s failed before heal: %v", victim.Name, err)
+		return honest, false
+	}
+	if len(current.IPAddrs)+len(current.IPSubnets) > 0 {
+		return nil, true
+	}
+
+	ours := make(map[peer.ID]bool, len(honest))
+	for _, id := range honest {
+		ours[id] = true
+	}
+	var lift []peer.ID
+	for _, id := range current.Peers {
+		if !ours[id] {
+			return nil, true
+		}
+		lift = append(lift, id)
+	}
+
+	for _, t := range targets {
+		if t.Name == victim.Name {
+			continue
+		}
+		var blocked netBlockList
+		if err := callRPC(t.Name, "Filecoin.NetBlockList", nil, &blocked); err != nil {
+			continue
+		}
+		for _, id := range blocked.Peers {
+			if id == victim.AddrInfo.ID {
+				return nil, true
+			}
+		}
+	}
+	return lift, false
+}
+
+// eclipseReferenceHeight returns the highest head among the other targets.
+func eclipseReferenceHeight(victim TargetNode) uint64 {
+	var best uint64
+	for _, t := range targets {
+		if t.Name == victim.Name {
+			continue
+		}
+		if h := fetchChainHead(t.Name); h != nil && h.Height > best {
+			best = h.Height
+		}
+	}
+	return best
+}
+
+// startEclipseHost creates a fresh identity that answers Hello and serves the
+// fabricated chain over ChainExchange, then announces it to the victim.
+func startEclipseHost(ctx context.Context, victim TargetNode, ec *eclipseChain, served *atomic.Int64) host.Host {
+	h, err := pool.GetFresh(ctx)
+	if err != nil {
+		debugLog("[eclipse] create host failed: %v", err)
+		return nil
+	}
+
+	h.SetStreamHandler(exchangeProtocol, func(s network.Stream) {
+		defer s.Close()
+		s.SetReadDeadline(time.Now().Add(eclipseHandlerTimeout))
+		req, _ := io.ReadAll(io.LimitReader(s, 64*1024))
+		s.Write(ec.responseFor(req))
+		served.Add(1)
+	})
+	h.SetStreamHandler(helloProtocol, func(s network.Stream) {
+		io.Copy(io.Discard, io.LimitReader(s, 64*1024))
+		s.Write(cborArray(cborInt64(0), cborInt64(0)))
+		s.Close()
+	})
+
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := h.Connect(connectCtx, victim.AddrInfo); err != nil {
+		debugLog("[eclipse] connect to %s failed: %v", victim.Name, err)
+		h.Close()
+		return nil
+	}
+	announceEclipseChain(ctx, h, victim, ec)
+	return h
+}
+
+func announceEclipseChain(ctx context.Context, h host.Host, victim TargetNode, ec *eclipseChain) {
+	sendHelloPayload(ctx, h, victim.AddrInfo.ID, buildHelloMessage(
+		[]cid.Cid{ec.cids[0]}, ec.height, ec.weight, parseGenesisCID(),
+	))
+}
+
+func runEclipseAttack(ctx context.Context, victim TargetNode) {
+	// Never stack on top of a stress-engine partition: NetBlockRemove below
+	// would otherwise heal links we didn't cut.
+	var existing netBlockList
+	if err := callRPC(victim.Name, "Filecoin.NetBlockList", nil, &existing); err != nil {
+		debugLog("[eclipse] NetBlockList on %s failed: %v", victim.Name, err)
+		return
+	}
+	if len(existing.Peers)+len(existing.IPAddrs)+len(existing.IPSubnets) > 0 {
+		debugLog("[eclipse] %s already has a block list, skipping", victim.Name)
+		return
+	}
+
+	head := fetchChainHead(victim.Name)
+	if head == nil {
+		debugLog("[eclipse] cannot fetch chain head for %s, skipping", victim.Name)
+		return
+	}
+	honest := eclipseHonestPeers(victim)
+	if len(honest) == 0 {
+		debugLog("[eclipse] no honest peers known for %s, skipping", victim.Name)
+		return
+	}
+
+	ec := buildEclipseChain(head, eclipseFakeChainLen)
+
+	if err := callRPC(victim.Name, "Filecoin.NetBlockAdd", []any{netBlockList{Peers: honest}}, nil); err != nil {
+		log.Printf("[eclipse] NetBlockAdd on %s failed: %v", victim.Name, err)
+		return
+	}
+	log.Printf("[eclipse] %s eclipsed at height %d: blocked %d honest peers, fake tip %s at height %d",
+		victim.Name, head.Height, len(honest), ec.cids[0].String()[:16], ec.height)
+
+	var served atomic.Int64
+	var hosts []host.Host
+	for i := 0; i < envInt("FUZZER_ECLIPSE_PEERS", 4); i++ {
+		if h := startEclipseHost(ctx, victim, ec, &served); h != nil {
+			hosts = append(hosts, h)
+		}
+	}
+
+	// Eclipsed phase: keep re-announcing and watch the victim's head.
+	var adopted cid.Cid
+	adoptedPhase := ""
+	hold := time.Duration(envInt("FUZZER_ECLIPSE_HOLD_SEC", 30)) * time.Second
+	deadline := time.Now().Add(hold)
+	for poll := 1; time.Now().Before(deadline); poll++ {
+		time.Sleep(eclipsePollInterval)
+		if cur := fetchChainHead(victim.Name); cur != nil && !adopted.Defined() {
+			if c, ok := ec.fakeIn(cur.CIDs); ok {
+				adopted, adoptedPhase = c, "eclipsed"
+			}
+		}
+		if poll%eclipseHelloEvery == 0 {
+			for _, h := range hosts {
+				announceEclipseChain(ctx, h, victim, ec)
+			}
+		}
+	}
+
+	// Heal: drop the malicious peers, lift the blocks and reconnect honest nodes.
+	for _, h := range hosts {
+		h.Close()
+	}
+	lift, foreign := eclipseBlocksToLift(victim, honest)
+	if foreign {
+		log.Printf("[eclipse] %s picked up another block list while eclipsed, leaving its blocks to their owner", victim.Name)
+	} else if len(lift) > 0 {
+		if err := callRPC(victim.Name, "Filecoin.NetBlockRemove", []any{netBlockList{Peers: lift}}, nil); err != nil {
+			log.Printf("[eclipse] WARN: NetBlockRemove on %s failed: %v", victim.Name, err)
+		}
+	}
+	for _, t := range targets {
+		if t.Name == victim.Name {
+			continue
+		}
+		if err := callRPC(victim.Name, "Filecoin.NetConnect", []any{t.AddrInfo}, nil); err != nil {
+			debugLog("[eclipse] NetConnect %s -> %s failed: %v", victim.Name, t.Name, err)
+		}
+	}
+
+	healHeight := head.Height
+	if cur := fetchChainHead(victim.Name); cur != nil {
+		healHeight = cur.Height
+	}
+	refStart := eclipseReferenceHeight(victim)
+	log.Printf("[eclipse] %s healed at height %d (honest tip %d), served=%d fake responses from %d hosts",
+		victim.Name, healHeight, refStart, served.Load(), len(hosts))
+
+	// Recovery phase: the victim must get past where it stalled and close
+	// in on the honest tip.
+	recovered := false
+	var lastHeight uint64
+	recovery := time.Duration(envInt("FUZZER_ECLIPSE_RECOVERY_SEC", 120)) * time.Second
+	deadline = time.Now().Add(recovery)
+	for time.Now().Before(deadline) {
+		time.Sleep(eclipsePollInterval)
+		cur := fetchChainHead(victim.Name)
+		if cur == nil {
+			continue
+		}
+		lastHeight = cur.Height
+		if c, ok := ec.fakeIn(cur.CIDs); ok && !adopted.Defined() {
+			adopted, adoptedPhase = c, "recovery"
+		}
+		if cur.Height > healHeight && cur.Height+eclipseSyncTolerance >= eclipseReferenceHeight(victim) {
+			recovered = true
+			break
+		}
+	}
+	refEnd := eclipseReferenceHeight(victim)
+
+	details := map[string]any{
+		"victim":         victim.Name,
+		"eclipse_height": head.Height,
+		"heal_height":    healHeight,
+		"last_height":    lastHeight,
+		"honest_start":   refStart,
+		"honest_end":     refEnd,
+		"blocked_peers":  len(honest),
+		"fake_hosts":     len(hosts),
+		"fake_tip":       ec.cids[0].String(),
+		"fake_served":    served.Load(),
+		"foreign_blocks": foreign,
+	}
+
+	if adopted.Defined() {
+		details["adopted_cid"] = adopted.String()
+		details["adopted_phase"] = adoptedPhase
+	}
+	assert.Always(!adopted.Defined(), "Eclipsed node never adopts a fabricated chain", details)
+
+	assert.Sometimes(served.Load() > 0, "Eclipsed node fetched the fabricated chain from malicious peers", details)
+
+	// Fault injection can pause or cut the victim during the recovery
+	// window, so a slow recovery is a Sometimes signal, not a violation.
+	// Only counted when we lifted our own blocks and the honest network
+	// itself kept moving.
+	if !foreign && refEnd > refStart {
+		assert.Sometimes(recovered, "Eclipsed node resumes syncing once honest peers return", details)
+		if recovered {
+			assert.Always(true, "Eclipsed node recovers within the outage window", details)
+		} else {
+			go watchEclipseRecovery(victim, healHeight, details)
+		}
+	}
+
+	log.Printf("[eclipse] %s done: recovered=%v adopted=%v last_height=%d honest_tip=%d",
+		victim.Name, recovered, adopted.Defined(), lastHeight, refEnd)
+}
+
+// eclipseWatches holds victims with a watchEclipseRecovery in flight.
+var eclipseWatches sync.Map
+
+// watchEclipseRecovery keeps polling a victim that missed the short recovery
+// window, using the liveness oracle's outage window. A victim still behind a
+// moving honest tip once it expires never recovered from the eclipse. Time
+// spent mid-partition restarts the clock, as in trackOutage.
+func watchEclipseRecovery(victim TargetNode, healHeight uint64, details map[string]any) {
+	if _, busy := eclipseWatches.LoadOrStore(victim.Name, true); busy {
+		return
+	}
+	defer eclipseWatches.Delete(victim.Name)
+
+	window := time.Duration(envInt("FUZZER_ORACLE_OUTAGE_SEC", 600)) * time.Second
+	start, refStart := time.Now(), eclipseReferenceHeight(victim)
+	recovered := false
+	var lastHeight uint64
+	for time.Since(start) < window {
+		time.Sleep(eclipsePollInterval)
+		if targetPartitioned(victim) {
+			start, refStart = time.Now(), eclipseReferenceHeight(victim)
+			continue
+		}
+		cur := fetchChainHead(victim.Name)
+		if cur == nil {
+			continue
+		}
+		lastHeight = cur.Height
+		if cur.Height > healHeight && cur.Height+eclipseSyncTolerance >= eclipseReferenceHeight(victim) {
+			recovered = true
+			break
+		}
+	}
+	refEnd := eclipseReferenceHeight(victim)
+	if !recovered && refEnd <= refStart {
+		debugLog("[eclipse] %s still behind but the honest tip stalled too, not reporting", victim.Name)
+		return
+	}
+
+	d := make(map[string]any, len(details)+4)
+	for k, v := range details {
+		d[k] = v
+	}
+	d["outage_sec"] = int(window.Seconds())
+	d["late_last_height"] = lastHeight
+	d["late_honest_start"] = refStart
+	d["late_honest_end"] = refEnd
+	assert.Always(recovered, "Eclipsed node recovers within the outage window", d)
+
+	log.Printf("[eclipse] %s late recovery: recovered=%v last_height=%d honest_tip=%d",
+		victim.Name, recovered, lastHeight, refEnd)
+}
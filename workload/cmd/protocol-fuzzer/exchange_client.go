@@ -31,11 +31,13 @@ func openExchangeStream(ctx context.Context, h host.Host, target peer.AddrInfo)
 // buildExchangeRequest builds a valid ChainExchange request as CBOR:
 // Request = [Head []CID, Length uint64, Options uint64]
 func buildExchangeRequest(head []cid.Cid, length uint64, options uint64) []byte {
-	return cborArray(
+	req := cborArray(
 		cborCIDArray(head),
 		cborUint64(length),
 		cborUint64(options),
 	)
+	recordPayload(channelXchgRequest, req)
+	return req
 }
 
 // readResponse reads up to 64KB from the stream, discarding the data.
@@ -187,6 +187,7 @@ func sendHelloPayload(ctx context.Context, h host.Host, targetPeer peer.ID, payl
 	}
 	defer s.Close()
 
+	recordPayload(channelHello, payload)
 	s.Write(payload)
 	s.CloseWrite()
 	io.Copy(io.Discard, io.LimitReader(s, 1024))
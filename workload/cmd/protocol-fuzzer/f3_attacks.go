@@ -56,6 +56,13 @@ func zstdCompress(data []byte) []byte {
 	return zstdEncoder.EncodeAll(data, nil)
 }
 
+// zstd decoder (package-level, reusable) for captured F3 traffic
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(1<<20))
+
+func zstdDecompress(data []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(data, nil)
+}
+
 // ---------------------------------------------------------------------------
 // F3 CBOR builders
 // ---------------------------------------------------------------------------
@@ -1027,6 +1034,7 @@ func sendCertExchangeRequest(target TargetNode, request []byte) {
 	}
 	defer s.Close()
 
+	recordPayload(channelCertEx, request)
 	if _, err := s.Write(request); err != nil {
 		debugLog("[f3-certex] write to %s failed: %v", target.Name, err)
 		return
@@ -53,6 +53,18 @@ func runGossipAttack(buildPayload func() gossipPayload) {
 // This is the shared publish mechanism for all gossip-based attacks
 // (gossip vectors, CBOR bombs, F3 attacks).
 func publishGossipPayload(topicName string, data []byte) {
+	tapPayload(topicName, data)
+	registerGossipPayload(topicName, data)
+	if err := gossipPub.publish(ctx, topicName, data); err != nil {
+		debugLog("[gossip] publish to %s failed: %v", topicName, err)
+	}
+}
+
+// publishValidGossipPayload publishes a payload nodes are expected to accept
+// (e.g. a correctly signed equivocating block). It skips
+// registerGossipPayload so the acceptance oracle doesn't flag it.
+func publishValidGossipPayload(topicName string, data []byte) {
+	tapPayload(topicName, data)
 	if err := gossipPub.publish(ctx, topicName, data); err != nil {
 		debugLog("[gossip] publish to %s failed: %v", topicName, err)
 	}
@@ -86,9 +98,53 @@ func (gp *gossipPublisher) publish(ctx context.Context, topicName string, data [
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
 
+	topic, err := gp.topic(ctx, topicName)
+	if err != nil {
+		return err
+	}
+
+	gp.uses++
+	recordPayload(topicName, data)
+	publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return topic.Publish(publishCtx, data)
+}
+
+// capture subscribes to topicName and returns the first message another peer
+// publishes on it within timeout. Used to seed mutations with live traffic.
+func (gp *gossipPublisher) capture(ctx context.Context, topicName string, timeout time.Duration) ([]byte, error) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	topic, err := gp.topic(ctx, topicName)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return nil, fmt.Errorf("subscribe %s: %w", topicName, err)
+	}
+	defer sub.Cancel()
+
+	captureCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		msg, err := sub.Next(captureCtx)
+		if err != nil {
+			return nil, err
+		}
+		if msg.ReceivedFrom != gp.h.ID() {
+			return msg.Data, nil
+		}
+	}
+}
+
+// topic returns the joined topic, rotating the host first if its use budget
+// is spent. Caller holds gp.mu.
+func (gp *gossipPublisher) topic(ctx context.Context, topicName string) (*pubsub.Topic, error) {
 	if gp.h == nil || gp.uses >= gp.maxUse {
 		if err := gp.reset(ctx); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -97,17 +153,13 @@ func (gp *gossipPublisher) publish(ctx context.Context, topicName string, data [
 		var err error
 		topic, err = gp.ps.Join(topicName)
 		if err != nil {
-			return fmt.Errorf("join topic %s: %w", topicName, err)
+			return nil, fmt.Errorf("join topic %s: %w", topicName, err)
 		}
 		gp.topics[topicName] = topic
 		// Wait for mesh formation only on first join of this topic
 		time.Sleep(3 * time.Second)
 	}
-
-	gp.uses++
-	publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-	return topic.Publish(publishCtx, data)
+	return topic, nil
 }
 
 func (gp *gossipPublisher) reset(ctx context.Context) error {
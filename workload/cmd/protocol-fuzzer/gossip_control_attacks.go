@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pubsubpb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/record"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ---------------------------------------------------------------------------
+// GossipSub Control-Plane Attacks
+//
+// The gossip attacks above publish through a well-behaved go-libp2p-pubsub
+// router, so only message payloads vary. These vectors speak /meshsub/1.1.0
+// and /meshsub/1.2.0 directly: varint-length-prefixed pubsub.pb.RPC frames,
+// at most 1 MiB each, carrying hand-built control messages.
+// Vectors:
+//   - IHAVE floods of unknown IDs past MaxIHaveLength/MaxIHaveMessages
+//   - IWANT spam for huge ID lists, including IDs the victim advertised
+//   - GRAFT during the backoff our own PRUNE started, and GRAFT floods
+//   - PRUNE with crafted peer-exchange records and extreme backoffs
+//   - IDONTWANT floods, and IDONTWANT on a 1.1.0 stream that predates it
+//
+// The liveness oracle covers crashes and hangs. On top of that, every vector
+// checks that the victim's block-topic mesh with the other nodes recovers.
+// ---------------------------------------------------------------------------
+
+const (
+	gossipRPCMaxSize    = 1 << 20 // pubsub.DefaultMaxMessageSize
+	gossipMsgIDLen      = 32      // blake2b-256, as Lotus and Forest derive IDs
+	gossipRawDialWait   = 10 * time.Second
+	gossipRawSettleWait = 2 * time.Second // one heartbeat
+	gossipMeshSampleGap = 3 * time.Second // a few score refreshes (DecayInterval 1s)
+)
+
+func getAllGossipControlAttacks() []namedAttack {
+	return []namedAttack{
+		{name: "gossip-ctrl/all-ihave-flood-with-unknown-ids", targetedFn: gossipCtrlChecked(gossipIHaveFlood), targetType: nodeAny},
+		{name: "gossip-ctrl/all-iwant-spam-for-huge-id-lists", targetedFn: gossipCtrlChecked(gossipIWantSpam), targetType: nodeAny},
+		{name: "gossip-ctrl/all-graft-during-prune-backoff", targetedFn: gossipCtrlChecked(gossipGraftDuringBackoff), targetType: nodeAny},
+		{name: "gossip-ctrl/all-prune-with-crafted-peer-exchange", targetedFn: gossipCtrlChecked(gossipPruneCraftedPX), targetType: nodeAny},
+		{name: "gossip-ctrl/all-idontwant-abuse", targetedFn: gossipCtrlChecked(gossipIDontWantAbuse), targetType: nodeAny},
+	}
+}
+
+// gossipCtrlChecked wraps a control-plane vector with the mesh recovery check.
+func gossipCtrlChecked(attack func(TargetNode)) func(TargetNode) {
+	return func(t TargetNode) {
+		meshed := gossipMeshLinks(t) > 0
+		attack(t)
+		if meshed {
+			checkMeshRecovery(t)
+		}
+	}
+}
+
+func gossipTopics() []string {
+	return []string{
+		fmt.Sprintf("/fil/blocks/%s", networkName),
+		fmt.Sprintf("/fil/msgs/%s", networkName),
+		fmt.Sprintf("/f3/granite/0.0.3/%s", networkName),
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Raw GossipSub peer
+// ---------------------------------------------------------------------------
+
+// rawGossipPeer is a fresh identity holding one outbound meshsub stream to
+// the victim. It advertises only proto, so the victim's router opens its
+// own stream back at that version; rawGossipPeer drains it and remembers the
+// message IDs the victim gossips.
+type rawGossipPeer struct {
+	h      host.Host
+	s      network.Stream
+	victim TargetNode
+
+	mu    sync.Mutex
+	ihave []string
+	prune int
+}
+
+func dialRawGossip(victim TargetNode, proto protocol.ID) (*rawGossipPeer, error) {
+	h, err := pool.GetFresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rp := &rawGossipPeer{h: h, victim: victim}
+	h.SetStreamHandler(proto, rp.drain)
+
+	dialCtx, cancel := context.WithTimeout(ctx, gossipRawDialWait)
+	defer cancel()
+	if err := h.Connect(dialCtx, victim.AddrInfo); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	s, err := h.NewStream(dialCtx, victim.AddrInfo.ID, proto)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("open %s: %w", proto, err)
+	}
+	rp.s = s
+	return rp, nil
+}
+
+func (rp *rawGossipPeer) close() {
+	rp.s.Close()
+	rp.h.Close()
+}
+
+// drain reads the victim's RPCs until the stream closes.
+func (rp *rawGossipPeer) drain(s network.Stream) {
+	defer s.Close()
+	r := bufio.NewReader(s)
+	for {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > gossipRPCMaxSize {
+			return
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return
+		}
+		var rpc pubsubpb.RPC
+		if err := rpc.Unmarshal(buf); err != nil {
+			continue
+		}
+		rp.mu.Lock()
+		for _, ih := range rpc.GetControl().GetIhave() {
+			rp.ihave = append(rp.ihave, ih.MessageIDs...)
+		}
+		rp.prune += len(rpc.GetControl().GetPrune())
+		rp.mu.Unlock()
+	}
+}
+
+// advertised returns the message IDs the victim has offered us so far.
+func (rp *rawGossipPeer) advertised() []string {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return append([]string(nil), rp.ihave...)
+}
+
+// send frames rpcs back to back in one write and records them as a single
+// payload.
+func (rp *rawGossipPeer) send(tag string, rpcs ...*pubsubpb.RPC) {
+	var data []byte
+	for _, rpc := range rpcs {
+		b, err := rpc.Marshal()
+		if err != nil {
+			debugLog("[gossip-ctrl] marshal %s: %v", tag, err)
+			return
+		}
+		data = append(binary.AppendUvarint(data, uint64(len(b))), b...)
+	}
+	recordPayload(channelGossipRPC, data)
+	rp.s.SetWriteDeadline(time.Now().Add(gossipRawDialWait))
+	if _, err := rp.s.Write(data); err != nil {
+		debugLog("[gossip-ctrl] %s write to %s failed: %v", tag, rp.victim.Name, err)
+		return
+	}
+	log.Printf("[gossip-ctrl] %s: %d RPCs (%d bytes) to %s", tag, len(rpcs), len(data), rp.victim.Name)
+}
+
+// sendGossipRPCPayload replays recorded RPC frames on a fresh 1.2.0 stream.
+func sendGossipRPCPayload(target TargetNode, data []byte) error {
+	rp, err := dialRawGossip(target, pubsub.GossipSubID_v12)
+	if err != nil {
+		return err
+	}
+	defer rp.close()
+	recordPayload(channelGossipRPC, data)
+	if _, err := rp.s.Write(data); err != nil {
+		return err
+	}
+	time.Sleep(gossipRawSettleWait)
+	return nil
+}
+
+func subscribeRPC(topics ...string) *pubsubpb.RPC {
+	rpc := &pubsubpb.RPC{}
+	for _, t := range topics {
+		rpc.Subscriptions = append(rpc.Subscriptions, &pubsubpb.RPC_SubOpts{Subscribe: boolPtr(true), Topicid: strPtr(t)})
+	}
+	return rpc
+}
+
+func controlRPC(ctl *pubsubpb.ControlMessage) *pubsubpb.RPC {
+	return &pubsubpb.RPC{Control: ctl}
+}
+
+func strPtr(s string) *string    { return &s }
+func boolPtr(b bool) *bool       { return &b }
+func uint64Ptr(v uint64) *uint64 { return &v }
+
+// randomMsgIDs returns n unknown message IDs, mostly of the real length.
+func randomMsgIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		l := gossipMsgIDLen
+		if rngIntn(10) == 0 {
+			l = rngIntn(4 * gossipMsgIDLen)
+		}
+		ids[i] = string(randomBytes(l))
+	}
+	return ids
+}
+
+// ---------------------------------------------------------------------------
+// IHAVE / IWANT
+// ---------------------------------------------------------------------------
+
+func gossipIHaveFlood(victim TargetNode) {
+	rp, err := dialRawGossip(victim, rngChoice([]protocol.ID{pubsub.GossipSubID_v11, pubsub.GossipSubID_v12}))
+	if err != nil {
+		debugLog("[gossip-ctrl] dial %s: %v", victim.Name, err)
+		return
+	}
+	defer rp.close()
+
+	topics := gossipTopics()
+	rp.send("subscribe", subscribeRPC(topics...))
+
+	// MaxIHaveMessages is 10 per heartbeat and MaxIHaveLength 5000 IDs.
+	n := envInt("FUZZER_GOSSIP_CTRL_RPCS", 20)
+	var rpcs []*pubsubpb.RPC
+	for i := 0; i < n; i++ {
+		topic := rngChoice(topics)
+		switch rngIntn(4) {
+		case 0: // topic nobody joined
+			topic = "/fuzz/" + string(randomBytes(8))
+		case 1:
+			topic = ""
+		}
+		ctl := &pubsubpb.ControlMessage{}
+		for j := 0; j < 1+rngIntn(3); j++ {
+			ctl.Ihave = append(ctl.Ihave, &pubsubpb.ControlIHave{TopicID: strPtr(topic), MessageIDs: randomMsgIDs(5000 + rngIntn(1000))})
+		}
+		rpcs = append(rpcs, controlRPC(ctl))
+	}
+	rp.send("IHAVE flood", rpcs...)
+
+	// The victim answers with IWANTs we never fulfil: broken promises.
+	time.Sleep(gossipRawSettleWait)
+}
+
+func gossipIWantSpam(victim TargetNode) {
+	rp, err := dialRawGossip(victim, rngChoice([]protocol.ID{pubsub.GossipSubID_v11, pubsub.GossipSubID_v12}))
+	if err != nil {
+		debugLog("[gossip-ctrl] dial %s: %v", victim.Name, err)
+		return
+	}
+	defer rp.close()
+
+	rp.send("subscribe", subscribeRPC(gossipTopics()...))
+	// Wait for the victim's heartbeat gossip so some IDs are real.
+	time.Sleep(2 * gossipRawSettleWait)
+	real := rp.advertised()
+
+	n := envInt("FUZZER_GOSSIP_CTRL_RPCS", 20)
+	var rpcs []*pubsubpb.RPC
+	for i := 0; i < n; i++ {
+		var ids []string
+		switch rngIntn(4) {
+		case 0: // ~850 KiB of unknown IDs
+			ids = randomMsgIDs(25000)
+		case 1: // real IDs, far past GossipRetransmission
+			for len(real) > 0 && len(ids) < 20000 {
+				ids = append(ids, real...)
+			}
+		case 2: // one ID near the frame limit
+			ids = []string{string(randomBytes(gossipRPCMaxSize - 1024))}
+		default:
+			ids = append(randomMsgIDs(5000), make([]string, 5000)...)
+		}
+		if len(ids) == 0 {
+			ids = randomMsgIDs(25000)
+		}
+		rpcs = append(rpcs, controlRPC(&pubsubpb.ControlMessage{Iwant: []*pubsubpb.ControlIWant{{MessageIDs: ids}}}))
+	}
+	rp.send(fmt.Sprintf("IWANT spam (%d real IDs)", len(real)), rpcs...)
+	time.Sleep(gossipRawSettleWait)
+}
+
+// ---------------------------------------------------------------------------
+// GRAFT / PRUNE
+// ---------------------------------------------------------------------------
+
+// gossipGraftDuringBackoff prunes the victim, which starts a backoff for us,
+// then grafts straight back in and keeps grafting.
+func gossipGraftDuringBackoff(victim TargetNode) {
+	rp, err := dialRawGossip(victim, rngChoice([]protocol.ID{pubsub.GossipSubID_v11, pubsub.GossipSubID_v12}))
+	if err != nil {
+		debugLog("[gossip-ctrl] dial %s: %v", victim.Name, err)
+		return
+	}
+	defer rp.close()
+
+	topics := gossipTopics()
+	rp.send("subscribe", subscribeRPC(topics...))
+	graft := func(topic string) *pubsubpb.RPC {
+		return controlRPC(&pubsubpb.ControlMessage{Graft: []*pubsubpb.ControlGraft{{TopicID: strPtr(topic)}}})
+	}
+	topic := rngChoice(topics)
+	rp.send("GRAFT", graft(topic))
+	time.Sleep(gossipRawSettleWait)
+	rp.send("PRUNE", controlRPC(&pubsubpb.ControlMessage{Prune: []*pubsubpb.ControlPrune{{TopicID: strPtr(topic), Backoff: uint64Ptr(60)}}}))
+
+	n := envInt("FUZZER_GOSSIP_CTRL_RPCS", 20)
+	var rpcs []*pubsubpb.RPC
+	for i := 0; i < n; i++ {
+		switch rngIntn(5) {
+		case 0: // topic the victim isn't in
+			rpcs = append(rpcs, graft("/fuzz/"+string(randomBytes(8))))
+		case 1: // GRAFT and PRUNE for the same topic in one RPC
+			rpcs = append(rpcs, controlRPC(&pubsubpb.ControlMessage{
+				Graft: []*pubsubpb.ControlGraft{{TopicID: strPtr(topic)}},
+				Prune: []*pubsubpb.ControlPrune{{TopicID: strPtr(topic)}},
+			}))
+		case 2: // nil topic
+			rpcs = append(rpcs, controlRPC(&pubsubpb.ControlMessage{Graft: []*pubsubpb.ControlGraft{{}}}))
+		default:
+			rpcs = append(rpcs, graft(topic))
+		}
+	}
+	rp.send("GRAFT during backoff", rpcs...)
+	time.Sleep(gossipRawSettleWait)
+
+	rp.mu.Lock()
+	pruned := rp.prune
+	rp.mu.Unlock()
+	log.Printf("[gossip-ctrl] %s sent %d PRUNEs back", victim.Name, pruned)
+}
+
+// craftedPX returns a peer-exchange list that is wrong in one way.
+func craftedPX(victim TargetNode) []*pubsubpb.PeerInfo {
+	sealed := func(signer crypto.PrivKey, id peer.ID, addrs ...ma.Multiaddr) []byte {
+		rec := peer.NewPeerRecord()
+		rec.PeerID = id
+		rec.Addrs = addrs
+		env, err := record.Seal(rec, signer)
+		if err != nil {
+			return nil
+		}
+		b, _ := env.Marshal()
+		return b
+	}
+	newKey := func() (crypto.PrivKey, peer.ID) {
+		priv, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+		id, _ := peer.IDFromPrivateKey(priv)
+		return priv, id
+	}
+
+	var px []*pubsubpb.PeerInfo
+	switch rngIntn(6) {
+	case 0: // unparseable IDs and records
+		for i := 0; i < 1+rngIntn(32); i++ {
+			px = append(px, &pubsubpb.PeerInfo{PeerID: randomBytes(rngIntn(64)), SignedPeerRecord: randomBytes(rngIntn(512))})
+		}
+	case 1: // the victim itself
+		priv, _ := newKey()
+		px = append(px, &pubsubpb.PeerInfo{PeerID: []byte(victim.AddrInfo.ID), SignedPeerRecord: sealed(priv, victim.AddrInfo.ID, victim.AddrInfo.Addrs...)})
+	case 2: // honest node IDs with records signed by someone else
+		priv, _ := newKey()
+		for _, t := range targets {
+			px = append(px, &pubsubpb.PeerInfo{PeerID: []byte(t.AddrInfo.ID), SignedPeerRecord: sealed(priv, t.AddrInfo.ID, ma.StringCast("/ip4/127.0.0.1/tcp/1"))})
+		}
+	case 3: // record for a different peer than the PeerInfo names
+		priv, id := newKey()
+		_, other := newKey()
+		px = append(px, &pubsubpb.PeerInfo{PeerID: []byte(other), SignedPeerRecord: sealed(priv, id, ma.StringCast("/ip4/10.255.255.1/tcp/1"))})
+	case 4: // validly signed, unroutable addresses, far past PrunePeers
+		for i := 0; i < 1000+rngIntn(1000); i++ {
+			priv, id := newKey()
+			px = append(px, &pubsubpb.PeerInfo{PeerID: []byte(id), SignedPeerRecord: sealed(priv, id, ma.StringCast("/ip4/0.0.0.0/tcp/0"))})
+		}
+	default: // IDs only, no records
+		for i := 0; i < 5000; i++ {
+			_, id := newKey()
+			px = append(px, &pubsubpb.PeerInfo{PeerID: []byte(id)})
+		}
+	}
+	return px
+}
+
+func gossipPruneCraftedPX(victim TargetNode) {
+	rp, err := dialRawGossip(victim, rngChoice([]protocol.ID{pubsub.GossipSubID_v11, pubsub.GossipSubID_v12}))
+	if err != nil {
+		debugLog("[gossip-ctrl] dial %s: %v", victim.Name, err)
+		return
+	}
+	defer rp.close()
+
+	topics := gossipTopics()
+	rp.send("subscribe", subscribeRPC(topics...))
+	ctl := &pubsubpb.ControlMessage{}
+	for _, t := range topics {
+		ctl.Graft = append(ctl.Graft, &pubsubpb.ControlGraft{TopicID: strPtr(t)})
+	}
+	rp.send("GRAFT", controlRPC(ctl))
+	time.Sleep(gossipRawSettleWait)
+
+	// Backoffs that overflow time.Duration once scaled to seconds.
+	backoffs := []uint64{0, 1, math.MaxUint64, math.MaxInt64, uint64(math.MaxInt64/time.Second) + 1}
+	var rpcs []*pubsubpb.RPC
+	for _, t := range topics {
+		rpcs = append(rpcs, controlRPC(&pubsubpb.ControlMessage{Prune: []*pubsubpb.ControlPrune{{
+			TopicID: strPtr(t),
+			Peers:   craftedPX(victim),
+			Backoff: uint64Ptr(rngChoice(backoffs)),
+		}}}))
+	}
+	rp.send("PRUNE with crafted PX", rpcs...)
+	time.Sleep(gossipRawSettleWait)
+}
+
+// ---------------------------------------------------------------------------
+// IDONTWANT
+// ---------------------------------------------------------------------------
+
+func gossipIDontWantAbuse(victim TargetNode) {
+	// 1.1.0 peers never send IDONTWANT; a router that trusts the frame
+	// instead of the negotiated version handles it anyway.
+	proto := pubsub.GossipSubID_v12
+	if rngIntn(4) == 0 {
+		proto = pubsub.GossipSubID_v11
+	}
+	rp, err := dialRawGossip(victim, proto)
+	if err != nil {
+		debugLog("[gossip-ctrl] dial %s: %v", victim.Name, err)
+		return
+	}
+	defer rp.close()
+
+	rp.send("subscribe", subscribeRPC(gossipTopics()...))
+	time.Sleep(2 * gossipRawSettleWait)
+	real := rp.advertised()
+
+	// MaxIDontWantLength is 10 IDs and MaxIDontWantMessages 1000 per
+	// heartbeat.
+	n := envInt("FUZZER_GOSSIP_CTRL_RPCS", 20)
+	var rpcs []*pubsubpb.RPC
+	for i := 0; i < n; i++ {
+		ctl := &pubsubpb.ControlMessage{}
+		switch rngIntn(4) {
+		case 0: // many small IDONTWANTs per RPC
+			for j := 0; j < 1000; j++ {
+				ctl.Idontwant = append(ctl.Idontwant, &pubsubpb.ControlIDontWant{MessageIDs: randomMsgIDs(10)})
+			}
+		case 1:
+			ctl.Idontwant = []*pubsubpb.ControlIDontWant{{MessageIDs: randomMsgIDs(25000)}}
+		case 2: // IDs the victim advertised, then IWANT for the same IDs
+			ids := append(slices.Clone(real), randomMsgIDs(10)...)
+			ctl.Idontwant = []*pubsubpb.ControlIDontWant{{MessageIDs: ids}}
+			ctl.Iwant = []*pubsubpb.ControlIWant{{MessageIDs: ids}}
+		default:
+			ctl.Idontwant = []*pubsubpb.ControlIDontWant{{MessageIDs: []string{"", string(randomBytes(gossipRPCMaxSize / 2))}}}
+		}
+		rpcs = append(rpcs, controlRPC(ctl))
+	}
+	rp.send(fmt.Sprintf("IDONTWANT abuse over %s", proto), rpcs...)
+	time.Sleep(gossipRawSettleWait)
+}
+
+// ---------------------------------------------------------------------------
+// Mesh recovery check
+// ---------------------------------------------------------------------------
+
+// pubsubScore mirrors api.PubsubScore for Filecoin.NetPubsubScores.
+type pubsubScore struct {
+	ID    peer.ID
+	Score *pubsub.PeerScoreSnapshot
+}
+
+// gossipMeshSample is a peer's TimeInMesh as seen by one Lotus node.
+type gossipMeshSample struct {
+	observer string
+	peer     peer.ID
+}
+
+// gossipMeshTimes returns TimeInMesh on the block topic for every link
+// between victim and another node, as seen by every Lotus node (Forest has
+// no NetPubsubScores).
+func gossipMeshTimes(victim TargetNode) map[gossipMeshSample]time.Duration {
+	topic := gossipTopics()[0]
+	out := make(map[gossipMeshSample]time.Duration)
+	for _, t := range lotusTargets {
+		var scores []pubsubScore
+		if err := callRPC(t.Name, "Filecoin.NetPubsubScores", nil, &scores); err != nil {
+			debugLog("[gossip-ctrl] NetPubsubScores on %s: %v", t.Name, err)
+			continue
+		}
+		for _, s := range scores {
+			if s.Score == nil || s.Score.Topics[topic] == nil {
+				continue
+			}
+			if s.ID == victim.AddrInfo.ID || (t.AddrInfo.ID == victim.AddrInfo.ID && isTargetPeer(s.ID)) {
+				out[gossipMeshSample{t.Name, s.ID}] = s.Score.Topics[topic].TimeInMesh
+			}
+		}
+	}
+	return out
+}
+
+// gossipMeshLinks counts block-topic mesh links between victim and the
+// other nodes. TimeInMesh keeps its last value after a PRUNE and only moves
+// while the peer is in the mesh, so a link counts only if it grew across
+// two samples gossipMeshSampleGap apart.
+func gossipMeshLinks(victim TargetNode) int {
+	before := gossipMeshTimes(victim)
+	time.Sleep(gossipMeshSampleGap)
+	links := 0
+	for k, d := range gossipMeshTimes(victim) {
+		if prev, ok := before[k]; ok && d > prev {
+			links++
+		}
+	}
+	return links
+}
+
+func isTargetPeer(id peer.ID) bool {
+	for _, t := range targets {
+		if t.AddrInfo.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMeshRecovery asserts the victim is back in the block-topic mesh
+// with another node within FUZZER_GOSSIP_MESH_GRACE_SEC.
+func checkMeshRecovery(victim TargetNode) {
+	grace := time.Duration(envInt("FUZZER_GOSSIP_MESH_GRACE_SEC", 60)) * time.Second
+	deadline := time.Now().Add(grace)
+	links := gossipMeshLinks(victim)
+	for links == 0 && time.Now().Before(deadline) {
+		time.Sleep(oracleReprobeEvery)
+		links = gossipMeshLinks(victim)
+	}
+	if links == 0 && targetPartitioned(victim) {
+		// Partitions from the stress-engine rightly empty the mesh.
+		debugLog("[gossip-ctrl] %s is mid-partition, skipping mesh check", victim.Name)
+		return
+	}
+	assert.Always(links > 0, "Node's block topic mesh recovers after GossipSub control-plane attacks", map[string]any{
+		"node":       victim.Name,
+		"topic":      gossipTopics()[0],
+		"grace_sec":  grace.Seconds(),
+		"mesh_links": links,
+	})
+}
@@ -96,6 +96,7 @@ func getAllHelloAttacks() []namedAttack {
 // sendHelloAttackPayload connects to a target and sends raw bytes on the
 // Hello protocol stream, then drains the response.
 func sendHelloAttackPayload(target TargetNode, payload []byte) {
+	tapPayload(channelHello, payload)
 	h, err := pool.GetFresh(ctx)
 	if err != nil {
 		debugLog("[hello] create host failed: %v", err)
@@ -119,6 +120,7 @@ func sendHelloAttackPayload(target TargetNode, payload []byte) {
 	}
 	defer s.Close()
 
+	recordPayload(channelHello, payload)
 	s.Write(payload)
 	s.CloseWrite()
 	io.Copy(io.Discard, io.LimitReader(s, 1024))
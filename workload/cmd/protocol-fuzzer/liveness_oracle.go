@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+// ---------------------------------------------------------------------------
+// Post-attack liveness oracle
+//
+// After every targeted attack the main loop probes the target over RPC
+// (ChainHead) and libp2p (connect + identify + ping). Fault injection
+// routinely pauses, partitions and restarts nodes, so a short outage is only
+// logged; a target that stays down past FUZZER_ORACLE_OUTAGE_SEC is reported
+// as a crash (neither RPC nor libp2p answer) or a hang (one side answers, the
+// other doesn't). A target whose head stops moving while the rest of the
+// network advances is reported as a stall. Mid-partition targets are
+// skipped. Each report carries the last N attacks and the hashes of the
+// payloads they sent so the culprit can be replayed.
+// ---------------------------------------------------------------------------
+
+const (
+	oracleMaxPayloads    = 16 // payload hashes kept per attack
+	oracleProbeTimeout   = 5 * time.Second
+	oracleReprobeEvery   = 5 * time.Second
+	oracleStallTolerance = 10 // epochs behind the network before a stall counts
+)
+
+// attackRecord is one entry in the recent-attack history.
+type attackRecord struct {
+	Name     string    `json:"name"`
+	Target   string    `json:"target"`
+	Start    time.Time `json:"start"`
+	Payloads []string  `json:"payloads,omitempty"`
+}
+
+// headProgress tracks when a target's head last moved.
+type headProgress struct {
+	height    uint64
+	changedAt time.Time
+}
+
+var (
+	oracleMu      sync.Mutex
+	attackHistory []attackRecord
+	currentAttack *attackRecord
+	targetHeads   = make(map[string]headProgress)
+	reportedDown  = make(map[string]string)    // target -> failure kind already asserted
+	downSince     = make(map[string]time.Time) // target -> first failed probe of the current outage
+)
+
+// beginAttack opens a history entry that recordPayload appends to.
+// target is empty for broadcast attacks that pick their own recipients.
+func beginAttack(name, target string) {
+	oracleMu.Lock()
+	defer oracleMu.Unlock()
+
+	attackHistory = append(attackHistory, attackRecord{Name: name, Target: target, Start: time.Now()})
+	if limit := envInt("FUZZER_ORACLE_HISTORY", 8); len(attackHistory) > limit {
+		attackHistory = attackHistory[len(attackHistory)-limit:]
+	}
+	currentAttack = &attackHistory[len(attackHistory)-1]
+}
+
+// endAttack closes the current history entry.
+func endAttack() {
+	oracleMu.Lock()
+	currentAttack = nil
+	oracleMu.Unlock()
+}
+
+// recordPayload notes the hash of bytes an attack put on the wire and saves
+// them to the corpus. Called from the shared send paths and builders (Hello,
+// ChainExchange requests and responses, GossipSub, F3 certexchange) so
+// individual attacks don't need to know about the oracle. channel is a
+// channel* constant or, for GossipSub, the topic name.
+func recordPayload(channel string, data []byte) {
+	hash := payloadHash(data)
+
+	oracleMu.Lock()
+	if currentAttack == nil {
+		oracleMu.Unlock()
+		return
+	}
+	if len(currentAttack.Payloads) < oracleMaxPayloads {
+		currentAttack.Payloads = append(currentAttack.Payloads, hash)
+	}
+	attack, target := currentAttack.Name, currentAttack.Target
+	oracleMu.Unlock()
+
+	if corpus != nil {
+		corpus.save(corpusEntry{
+			Attack:  attack,
+			Target:  target,
+			Channel: channel,
+			Hash:    hash,
+			Data:    data,
+		})
+	}
+}
+
+// payloadTap, when set, sees every payload handed to tapPayload, whether or
+// not an attack is running or anything is listening. The offline fuzz
+// harnesses use it to harvest seed corpora from the attack functions.
+var payloadTap func(channel string, data []byte)
+
+// tapPayload offers a freshly built payload to payloadTap. Called where
+// payloads are built or handed to a send path, before any network I/O, so
+// the harnesses see them even when the target refuses connections.
+func tapPayload(channel string, data []byte) {
+	if payloadTap != nil {
+		payloadTap(channel, data)
+	}
+}
+
+// payloadHash is the short identifier used in attack history and corpus
+// file names: the first 8 bytes of the SHA-256 in hex, then the length.
+func payloadHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s/%d", hex.EncodeToString(sum[:8]), len(data))
+}
+
+// recentAttacks returns a copy of the attack history, newest last.
+func recentAttacks() []attackRecord {
+	oracleMu.Lock()
+	defer oracleMu.Unlock()
+
+	out := make([]attackRecord, len(attackHistory))
+	for i, a := range attackHistory {
+		out[i] = a
+		out[i].Payloads = append([]string(nil), a.Payloads...)
+	}
+	return out
+}
+
+// probeResult is the outcome of one RPC + libp2p health probe.
+type probeResult struct {
+	head   *chainHeadInfo
+	p2pErr string
+	agent  string
+	rtt    time.Duration
+}
+
+func (r probeResult) rpcOK() bool { return r.head != nil }
+func (r probeResult) p2pOK() bool { return r.p2pErr == "" }
+
+// probeTarget checks the target's RPC head and its libp2p stack. The libp2p
+// half reuses the stream host so probes don't churn identities.
+func probeTarget(ctx context.Context, target TargetNode) probeResult {
+	r := probeResult{head: fetchChainHead(target.Name)}
+
+	h, err := pool.GetForStream(ctx)
+	if err != nil {
+		r.p2pErr = fmt.Sprintf("probe host: %v", err)
+		return r
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, oracleProbeTimeout)
+	defer cancel()
+	if err := h.Connect(probeCtx, target.AddrInfo); err != nil {
+		r.p2pErr = fmt.Sprintf("connect: %v", err)
+		return r
+	}
+
+	// Identify runs automatically on connect; wait for the agent version.
+	for probeCtx.Err() == nil {
+		if v, err := h.Peerstore().Get(target.AddrInfo.ID, "AgentVersion"); err == nil {
+			r.agent, _ = v.(string)
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if r.agent == "" {
+		r.p2pErr = "identify: no agent version"
+		return r
+	}
+
+	select {
+	case res := <-ping.Ping(probeCtx, h, target.AddrInfo.ID):
+		if res.Error != nil {
+			r.p2pErr = fmt.Sprintf("ping: %v", res.Error)
+		}
+		r.rtt = res.RTT
+	case <-probeCtx.Done():
+		r.p2pErr = "ping: timeout"
+	}
+	return r
+}
+
+// checkTargetLiveness runs after each targeted attack. Failures are re-probed
+// for FUZZER_ORACLE_GRACE_SEC, then tracked as an outage; only an outage that
+// outlasts FUZZER_ORACLE_OUTAGE_SEC is reported, so a node that is slow,
+// paused, or restarted by fault injection doesn't trip the assertion.
+func checkTargetLiveness(ctx context.Context, target TargetNode) {
+	r := probeTarget(ctx, target)
+	if !r.rpcOK() || !r.p2pOK() {
+		grace := time.Duration(envInt("FUZZER_ORACLE_GRACE_SEC", 30)) * time.Second
+		deadline := time.Now().Add(grace)
+		for time.Now().Before(deadline) && (!r.rpcOK() || !r.p2pOK()) {
+			time.Sleep(oracleReprobeEvery)
+			r = probeTarget(ctx, target)
+		}
+	}
+
+	up := r.rpcOK() && r.p2pOK()
+	assert.Sometimes(up, "Fuzzer target answers liveness probe after attack", map[string]any{
+		"target":    target.Name,
+		"rpc_ok":    r.rpcOK(),
+		"p2p_error": r.p2pErr,
+	})
+	if !up {
+		trackOutage(target, r)
+		return
+	}
+
+	oracleMu.Lock()
+	delete(downSince, target.Name)
+	oracleMu.Unlock()
+
+	if stalled, ref := headStalled(target, r.head); stalled {
+		reportLivenessFailure(target, "stall", r, ref)
+		return
+	}
+
+	oracleMu.Lock()
+	if kind, ok := reportedDown[target.Name]; ok {
+		log.Printf("[oracle] %s recovered from %s", target.Name, kind)
+		delete(reportedDown, target.Name)
+	}
+	oracleMu.Unlock()
+	debugLog("[oracle] %s healthy: height=%d rtt=%s agent=%s", target.Name, r.head.Height, r.rtt, r.agent)
+}
+
+// trackOutage records a failed probe and reports a crash or hang once the
+// outage has lasted FUZZER_ORACLE_OUTAGE_SEC. Time spent mid-partition
+// doesn't count towards the window.
+func trackOutage(target TargetNode, r probeResult) {
+	kind := "hang"
+	if !r.rpcOK() && !r.p2pOK() {
+		kind = "crash"
+	}
+
+	if targetPartitioned(target) {
+		oracleMu.Lock()
+		delete(downSince, target.Name)
+		oracleMu.Unlock()
+		debugLog("[oracle] %s unreachable (%s) but mid-partition, skipping", target.Name, kind)
+		return
+	}
+
+	now := time.Now()
+	oracleMu.Lock()
+	since, ok := downSince[target.Name]
+	if !ok {
+		since = now
+		downSince[target.Name] = since
+	}
+	oracleMu.Unlock()
+
+	window := time.Duration(envInt("FUZZER_ORACLE_OUTAGE_SEC", 600)) * time.Second
+	if outage := now.Sub(since); outage < window {
+		log.Printf("[oracle] %s unreachable (%s) for %s, reporting after %s (rpc_ok=%v p2p=%q)",
+			target.Name, kind, outage.Round(time.Second), window, r.rpcOK(), r.p2pErr)
+		return
+	}
+	reportLivenessFailure(target, kind, r, 0)
+}
+
+// targetPartitioned reports whether the target is cut off by a block list,
+// either its own or another node's entry for its peer ID (nsplit blocks the
+// adversary from the honest side). Nodes whose block list can't be read,
+// including a crashed target, are ignored.
+func targetPartitioned(target TargetNode) bool {
+	for _, t := range targets {
+		var blocked netBlockList
+		if err := callRPC(t.Name, "Filecoin.NetBlockList", nil, &blocked); err != nil {
+			continue
+		}
+		if t.Name == target.Name {
+			if len(blocked.Peers)+len(blocked.IPAddrs)+len(blocked.IPSubnets) > 0 {
+				return true
+			}
+			continue
+		}
+		for _, p := range blocked.Peers {
+			if p == target.AddrInfo.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headStalled reports whether the target's head has been stuck for longer
+// than FUZZER_ORACLE_STALL_SEC while another node moved well past it.
+// Mid-partition targets are skipped.
+func headStalled(target TargetNode, head *chainHeadInfo) (bool, uint64) {
+	now := time.Now()
+
+	oracleMu.Lock()
+	prev, seen := targetHeads[target.Name]
+	if !seen || head.Height != prev.height {
+		targetHeads[target.Name] = headProgress{height: head.Height, changedAt: now}
+		oracleMu.Unlock()
+		return false, 0
+	}
+	oracleMu.Unlock()
+
+	window := time.Duration(envInt("FUZZER_ORACLE_STALL_SEC", 120)) * time.Second
+	if now.Sub(prev.changedAt) < window {
+		return false, 0
+	}
+
+	if targetPartitioned(target) {
+		return false, 0
+	}
+
+	var best uint64
+	for _, t := range targets {
+		if t.Name == target.Name {
+			continue
+		}
+		if h := fetchChainHead(t.Name); h != nil && h.Height > best {
+			best = h.Height
+		}
+	}
+	return best > head.Height+oracleStallTolerance, best
+}
+
+// reportLivenessFailure raises the assertion once per target and failure
+// kind until the target is seen healthy again.
+func reportLivenessFailure(target TargetNode, kind string, r probeResult, networkHeight uint64) {
+	oracleMu.Lock()
+	if reportedDown[target.Name] == kind {
+		oracleMu.Unlock()
+		return
+	}
+	reportedDown[target.Name] = kind
+	oracleMu.Unlock()
+
+	history := recentAttacks()
+	last := ""
+	if len(history) > 0 {
+		last = history[len(history)-1].Name
+	}
+
+	details := map[string]any{
+		"target":         target.Name,
+		"peer":           target.AddrInfo.ID.String(),
+		"kind":           kind,
+		"last_attack":    last,
+		"recent_attacks": history,
+		"rpc_ok":         r.rpcOK(),
+		"p2p_error":      r.p2pErr,
+	}
+	if r.head != nil {
+		details["height"] = r.head.Height
+	}
+	if networkHeight > 0 {
+		details["network_height"] = networkHeight
+	}
+
+	log.Printf("[oracle] %s %s after vector=%s (rpc_ok=%v p2p=%q)", target.Name, kind, last, r.rpcOK(), r.p2pErr)
+
+	switch kind {
+	case "crash":
+		assert.Unreachable("Fuzzer target stays up after attack", details)
+	case "hang":
+		assert.Unreachable("Fuzzer target stays responsive after attack", details)
+	case "stall":
+		assert.Unreachable("Fuzzer target head keeps advancing after attack", details)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 	"time"
 
@@ -25,6 +26,7 @@ var (
 	// Network metadata
 	networkName string
 	genesisCID  string
+	devgenDir   string
 
 	// Identity pool for ephemeral libp2p hosts
 	pool *IdentityPool
@@ -58,7 +60,13 @@ func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 	log.Println("[protocol-fuzzer] protocol fuzzer starting")
 
-	if envOrDefault("FUZZER_ENABLED", "1") != "1" {
+	// Subcommands (replay, minimize) run regardless of FUZZER_ENABLED.
+	var subcommand string
+	if len(os.Args) > 1 {
+		subcommand = os.Args[1]
+	}
+
+	if subcommand == "" && envOrDefault("FUZZER_ENABLED", "1") != "1" {
 		log.Println("[protocol-fuzzer] disabled via FUZZER_ENABLED=0, exiting")
 		return
 	}
@@ -68,7 +76,7 @@ func main() {
 
 	// Parse node names from env (same var as stress-engine)
 	nodeNames := strings.Split(envOrDefault("STRESS_NODES", "lotus0"), ",")
-	devgenDir := envOrDefault("FUZZER_DEVGEN_DIR", "/root/devgen")
+	devgenDir = envOrDefault("FUZZER_DEVGEN_DIR", "/root/devgen")
 
 	// Discover libp2p peers
 	log.Println("[protocol-fuzzer] discovering libp2p peers...")
@@ -102,6 +110,32 @@ func main() {
 	gossipPub = newGossipPublisher(50)
 	defer gossipPub.close()
 
+	// Open the rolling payload corpus
+	if limit := envInt("FUZZER_CORPUS_MAX", 2000); limit > 0 {
+		c, err := openCorpus(corpusDir(), limit, envInt("FUZZER_CORPUS_MAX_BYTES", 1<<20))
+		if err != nil {
+			log.Printf("[protocol-fuzzer] corpus disabled: %v", err)
+		} else {
+			corpus = c
+		}
+	}
+
+	switch subcommand {
+	case "":
+	case "replay", "minimize":
+		run := runReplayCommand
+		if subcommand == "minimize" {
+			run = runMinimizeCommand
+		}
+		if err := run(os.Args[2:]); err != nil {
+			log.Printf("[protocol-fuzzer] %s: %v", subcommand, err)
+		}
+		return
+	default:
+		log.Printf("[protocol-fuzzer] unknown subcommand %q (want replay or minimize)", subcommand)
+		return
+	}
+
 	// Build weighted attack deck
 	buildDeck()
 
@@ -109,6 +143,8 @@ func main() {
 
 	// Main attack loop
 	interval := time.Duration(envInt("FUZZER_RATE_MS", 500)) * time.Millisecond
+	oracleEnabled := envOrDefault("FUZZER_LIVENESS_ORACLE", "1") == "1"
+	acceptanceEvery := envInt("FUZZER_ACCEPTANCE_CHECK_EVERY", 25)
 	actionCounts := make(map[string]int)
 	iteration := 0
 
@@ -121,16 +157,28 @@ func main() {
 				continue // no suitable target for this attack type
 			}
 			log.Printf("[protocol-fuzzer] starting vector=%s target=%s", attack.name, target.Name)
+			beginAttack(attack.name, target.Name)
 			attack.targetedFn(*target)
+			endAttack()
+			log.Printf("[protocol-fuzzer] completed vector=%s", attack.name)
+			if oracleEnabled {
+				checkTargetLiveness(ctx, *target)
+			}
 		} else {
 			log.Printf("[protocol-fuzzer] starting vector=%s", attack.name)
+			beginAttack(attack.name, "")
 			attack.fn()
+			endAttack()
+			log.Printf("[protocol-fuzzer] completed vector=%s", attack.name)
 		}
-		log.Printf("[protocol-fuzzer] completed vector=%s", attack.name)
 
 		actionCounts[attack.name]++
 		iteration++
 
+		if acceptanceEvery > 0 && iteration%acceptanceEvery == 0 {
+			checkFabricatedAcceptance()
+		}
+
 		// Periodic summary every 100 iterations
 		if iteration%100 == 0 {
 			log.Printf("[protocol-fuzzer] === iteration %d summary ===", iteration)
@@ -183,6 +231,12 @@ func buildDeck() {
 		{"FUZZER_WEIGHT_F3_CERT_EXCHANGE", 3, getAllF3CertExAttacks()},
 		{"FUZZER_WEIGHT_HELLO_PROTOCOL", 3, getAllHelloAttacks()},
 		{"FUZZER_WEIGHT_RUST_SPECIFIC_ATTACKS", 3, getAllForestAttacks()},
+		{"FUZZER_WEIGHT_STRUCTURED_MUTATION", 3, getAllStructuredMutationAttacks()},
+		{"FUZZER_WEIGHT_ECLIPSE", 1, getAllEclipseAttacks()},
+		{"FUZZER_WEIGHT_NEAR_VALID_BLOCKS", 2, getAllNearValidBlockAttacks()},
+		{"FUZZER_WEIGHT_SIGNED_ADVERSARY", 2, getAllSignedAdversaryAttacks()},
+		{"FUZZER_WEIGHT_DHT", 2, getAllDHTAttacks()},
+		{"FUZZER_WEIGHT_GOSSIP_CONTROL", 2, getAllGossipControlAttacks()},
 	}
 
 	deck = nil
@@ -1,11 +1,30 @@
 package main
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/build/buildconstants"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp"
+	"github.com/ipfs/go-cid"
 )
 
 func getAllMpoolAttacks() []namedAttack {
-	return []namedAttack{
+	attacks := []namedAttack{
 		{name: "msgs/lotus-signed-message-with-bad-address-secp256k1-sig", fn: mpoolSigCacheBombAddr},
 		{name: "msgs/lotus-signed-message-with-bad-address-bls-sig", fn: mpoolSigCacheBombBLS},
 		{name: "msgs/lotus-signed-message-with-unserializable-address", fn: mpoolChainLengthBombAddr},
@@ -13,6 +32,13 @@ func getAllMpoolAttacks() []namedAttack {
 		{name: "msgs/lotus-valid-then-bad-signed-message-sequence", fn: mpoolMixedValidThenBomb},
 		{name: "msgs/lotus-signed-message-with-bad-address-delegated-sig", fn: mpoolDelegatedSigBomb},
 	}
+	for _, c := range keystoreMsgCases {
+		attacks = append(attacks, namedAttack{
+			name: fmt.Sprintf("msgs/all-keystore-signed-message-with-%s", c.name),
+			fn:   func() { runKeystoreMessage(c) },
+		})
+	}
+	return attacks
 }
 
 // sigCacheKey with secp256k1 sig type calls m.Cid() at messagepool.go:808
@@ -79,3 +105,229 @@ func mpoolDelegatedSigBomb() {
 	log.Printf("[mpool] delegated-sig-bomb: %d bytes to /fil/msgs/", len(data))
 	publishMsg(data)
 }
+
+// ---------------------------------------------------------------------------
+// Keystore-signed messages
+//
+// The vectors above carry random signatures, so nodes drop them before any
+// mempool rule that needs a real sender runs. These are signed by genesis
+// wallets from stress_keystore.json and each break one rule:
+//   far-future nonce, insufficient balance, value outside [0, total supply],
+//   gas limit above the block limit, self-send from an actor that can't sign
+// An unknown method on an account actor passes every mempool rule, so that
+// one is published as valid and only has to fail if it is executed.
+//
+// The fuzzer signs with the first FUZZER_KEYSTORE_WALLETS entries, which the
+// stress-engine skips, so a message that does get included never breaks
+// the stress-engine's nonce tracking.
+// ---------------------------------------------------------------------------
+
+// keystoreMsgGasLimit covers an account actor invocation.
+const keystoreMsgGasLimit = 10_000_000
+
+type keystoreWallet struct {
+	addr address.Address
+	priv []byte
+}
+
+var (
+	keystoreOnce    sync.Once
+	keystoreWallets []keystoreWallet
+)
+
+// getKeystoreWallets loads the fuzzer's share of the stress keystore. The
+// format is genesis-prep's: [{Address, PrivateKey (hex secp256k1)}].
+func getKeystoreWallets() []keystoreWallet {
+	keystoreOnce.Do(func() {
+		path := envOrDefault("STRESS_KEYSTORE_PATH", "/shared/stress_keystore.json")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[mpool] keystore unavailable, signed message vectors disabled: %v", err)
+			return
+		}
+		var entries []struct {
+			Address    string `json:"Address"`
+			PrivateKey string `json:"PrivateKey"`
+		}
+		if err := json.Unmarshal(data, &entries); err != nil {
+			log.Printf("[mpool] cannot parse keystore %s: %v", path, err)
+			return
+		}
+		n := envInt("FUZZER_KEYSTORE_WALLETS", 2)
+		for _, e := range entries[:min(n, len(entries))] {
+			addr, err := address.NewFromString(e.Address)
+			if err != nil {
+				continue
+			}
+			pk, err := hex.DecodeString(e.PrivateKey)
+			if err != nil {
+				continue
+			}
+			keystoreWallets = append(keystoreWallets, keystoreWallet{addr: addr, priv: pk})
+		}
+		log.Printf("[mpool] loaded %d keystore wallets", len(keystoreWallets))
+	})
+	return keystoreWallets
+}
+
+// keystoreMsgCase breaks one rule of an otherwise valid transfer.
+type keystoreMsgCase struct {
+	name      string
+	mpoolOK   bool // passes mempool validation; must fail on execution
+	breakRule func(m *types.Message, balance types.BigInt)
+}
+
+var keystoreMsgCases = []keystoreMsgCase{
+	{name: "far-future-nonce", breakRule: func(m *types.Message, _ types.BigInt) {
+		if rngIntn(4) == 0 {
+			m.Nonce = math.MaxUint64
+			return
+		}
+		// Lotus accepts gaps up to MaxNonceGap (4) from trusted peers only.
+		m.Nonce += 5 + uint64(rngIntn(1<<20))
+	}},
+	{name: "insufficient-balance", breakRule: func(m *types.Message, balance types.BigInt) {
+		if rngIntn(2) == 0 {
+			m.Value = types.BigAdd(balance, types.NewInt(1))
+			return
+		}
+		// Gas alone exceeds the balance.
+		m.GasFeeCap = types.BigAdd(types.BigDiv(balance, types.NewInt(uint64(m.GasLimit))), types.NewInt(1))
+		m.GasPremium = m.GasFeeCap
+	}},
+	{name: "value-out-of-range", breakRule: func(m *types.Message, _ types.BigInt) {
+		switch rngIntn(3) {
+		case 0:
+			m.Value = types.BigAdd(types.TotalFilecoinInt, types.NewInt(1))
+		case 1:
+			m.Value = types.BigMul(types.TotalFilecoinInt, types.NewInt(math.MaxUint64))
+		default:
+			m.Value = big.NewInt(-1 - int64(rngIntn(1000)))
+		}
+	}},
+	{name: "gas-limit-above-block-limit", breakRule: func(m *types.Message, _ types.BigInt) {
+		m.GasLimit = buildconstants.BlockGasLimit + 1 + int64(rngIntn(1<<20))
+	}},
+	{name: "self-send-from-unsignable-actor", breakRule: func(m *types.Message, _ types.BigInt) {
+		from := rngChoice([]address.Address{
+			builtin.SystemActorAddr,
+			builtin.RewardActorAddr,
+			builtin.CronActorAddr,
+			builtin.StoragePowerActorAddr,
+			builtin.StorageMarketActorAddr,
+			builtin.BurntFundsActorAddr,
+			genesisMinerAddr(),
+		})
+		m.From, m.To, m.Nonce = from, from, 0
+	}},
+	{name: "unknown-method-on-account-actor", mpoolOK: true, breakRule: func(m *types.Message, _ types.BigInt) {
+		// Below the FRC-42 range (1<<24): the account actor's fallback
+		// only accepts FRC-42 methods, so this fails on chain with
+		// USR_UNHANDLED_MESSAGE but is still valid for the mempool.
+		m.Method = abi.MethodNum(3 + rngIntn(1<<20))
+	}},
+}
+
+func genesisMinerAddr() address.Address {
+	a, _ := address.NewIDAddress(uint64(1000 + rngIntn(4)))
+	return a
+}
+
+// runKeystoreMessage signs c's message with a keystore wallet, gossips it,
+// then asks every node whether it was included.
+func runKeystoreMessage(c keystoreMsgCase) {
+	wallets := getKeystoreWallets()
+	if len(wallets) == 0 {
+		return
+	}
+	t := pickTargetForType(nodeLotus)
+	if t == nil {
+		t = pickTargetForType(nodeAny)
+	}
+	if t == nil {
+		return
+	}
+	w := rngChoice(wallets)
+
+	var nonce uint64
+	if err := callRPC(t.Name, "Filecoin.MpoolGetNonce", []any{w.addr}, &nonce); err != nil {
+		debugLog("[mpool] MpoolGetNonce(%s) on %s failed: %v", w.addr, t.Name, err)
+		return
+	}
+	var balance types.BigInt
+	if err := callRPC(t.Name, "Filecoin.WalletBalance", []any{w.addr}, &balance); err != nil {
+		debugLog("[mpool] WalletBalance(%s) on %s failed: %v", w.addr, t.Name, err)
+		return
+	}
+
+	msg := &types.Message{
+		From:       w.addr,
+		To:         rngChoice(wallets).addr,
+		Nonce:      nonce,
+		Value:      types.NewInt(uint64(1 + rngIntn(1000))),
+		GasLimit:   keystoreMsgGasLimit,
+		GasFeeCap:  abi.NewTokenAmount(100_000),
+		GasPremium: abi.NewTokenAmount(1_000),
+	}
+	c.breakRule(msg, balance)
+
+	// Secp messages are signed over the unsigned message CID.
+	sig, err := sigs.Sign(crypto.SigTypeSecp256k1, w.priv, msg.Cid().Bytes())
+	if err != nil {
+		debugLog("[mpool] sign failed: %v", err)
+		return
+	}
+	smsg := &types.SignedMessage{Message: *msg, Signature: *sig}
+	data, err := smsg.Serialize()
+	if err != nil {
+		debugLog("[mpool] serialize %s message failed: %v", c.name, err)
+		return
+	}
+
+	log.Printf("[mpool] keystore-signed %s message %s from %s nonce %d", c.name, smsg.Cid(), msg.From, msg.Nonce)
+	if c.mpoolOK {
+		publishValidGossipPayload(fmt.Sprintf("/fil/msgs/%s", networkName), data)
+	} else {
+		publishMsg(data)
+	}
+
+	time.Sleep(signedSettle())
+	checkKeystoreMessage(c, smsg.Cid())
+}
+
+// checkKeystoreMessage looks the message up on every node. Messages that
+// break a mempool rule must never be included; the unknown-method message
+// may be, but must not succeed.
+func checkKeystoreMessage(c keystoreMsgCase, mc cid.Cid) {
+	included := false
+	for _, t := range targets {
+		var lookup *struct {
+			Receipt struct{ ExitCode int64 }
+			Height  int64
+		}
+		if err := callRPC(t.Name, "Filecoin.StateSearchMsg", []any{nil, mc, -1, true}, &lookup); err != nil {
+			// Nodes that never stored the message can't load it.
+			debugLog("[mpool] StateSearchMsg(%s) on %s: %v", mc, t.Name, err)
+			continue
+		}
+		details := map[string]any{"node": t.Name, "case": c.name, "message": mc.String()}
+		if lookup != nil {
+			details["height"] = lookup.Height
+			details["exit_code"] = lookup.Receipt.ExitCode
+		}
+
+		if c.mpoolOK {
+			if lookup != nil {
+				included = true
+				assert.Always(lookup.Receipt.ExitCode != 0, "Unknown method on an account actor never executes successfully", details)
+			}
+			continue
+		}
+		assert.Always(lookup == nil, "Signed message breaking a mempool rule is never included on chain", details)
+	}
+
+	if c.mpoolOK {
+		assert.Sometimes(included, "Mempool-valid keystore-signed message is included on chain",
+			map[string]any{"case": c.name, "message": mc.String()})
+	}
+}
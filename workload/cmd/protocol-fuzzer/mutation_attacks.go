@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// ---------------------------------------------------------------------------
+// Structured mutation attacks
+//
+// Seeds are real objects captured from the running devnet and cached for
+// FUZZER_SEED_TTL_SEC:
+//   block-header       ChainReadObj of a head block
+//   block-msg          head header + ChainGetBlockMessages CIDs
+//   signed-message     ChainReadObj of a pending secp message
+//   hello-message      the Hello a node sends our host on connect
+//   f3-gmessage        a PartialGMessage sniffed off the F3 topic
+//   exchange-response  the node's answer to a ChainExchange request
+// If capture fails the matching builder output is used instead. Each attack
+// runs the seed through mutateCBOR and sends it on the object's channel.
+// ---------------------------------------------------------------------------
+
+type seedKind string
+
+const (
+	seedBlockHeader      seedKind = "block-header"
+	seedBlockMsg         seedKind = "block-msg"
+	seedSignedMessage    seedKind = "signed-message"
+	seedHello            seedKind = "hello-message"
+	seedGMessage         seedKind = "f3-gmessage"
+	seedExchangeResponse seedKind = "exchange-response"
+)
+
+const seedCaptureTimeout = 10 * time.Second
+
+type capturedSeed struct {
+	data   []byte
+	source string
+	at     time.Time
+}
+
+var (
+	seedMu sync.Mutex
+	seeds  = make(map[seedKind]capturedSeed)
+)
+
+func getAllStructuredMutationAttacks() []namedAttack {
+	return []namedAttack{
+		{
+			name:       "mutate/all-block-header-in-exchange-response",
+			targetedFn: func(t TargetNode) { mutateBlockHeaderExchange(ctx, t) },
+			targetType: nodeAny,
+		},
+		{name: "mutate/all-block-msg-on-gossip", fn: mutateBlockMsgGossip},
+		{name: "mutate/all-signed-message-on-gossip", fn: mutateSignedMessageGossip},
+		{
+			name:       "mutate/all-hello-message",
+			targetedFn: mutateHelloMessage,
+			targetType: nodeAny,
+		},
+		{name: "mutate/all-f3-partial-gmessage-on-gossip", fn: mutateGMessageGossip},
+		{
+			name:       "mutate/all-exchange-response",
+			targetedFn: func(t TargetNode) { mutateExchangeResponse(ctx, t) },
+			targetType: nodeAny,
+		},
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Attack vectors
+// ---------------------------------------------------------------------------
+
+func mutateBlockHeaderExchange(ctx context.Context, target TargetNode) {
+	header := mutatedSeed(seedBlockHeader, target)
+	if header == nil {
+		return
+	}
+	exchangeServerHelper(ctx, target, "mutate-block-header", func(*chainHeadInfo) []byte {
+		return okResponse(buildBSTipSetCBOR([][]byte{header}, buildEmptyCompactedMsgsCBOR()))
+	})
+}
+
+func mutateBlockMsgGossip() {
+	data, seed := mutateSeed(seedBlockMsg, rngChoice(targets))
+	if data == nil {
+		return
+	}
+	topic := fmt.Sprintf("/fil/blocks/%s", networkName)
+	if sameFirstElementCID(data, seed) {
+		// Only the message lists changed; the header is the real one.
+		publishValidGossipPayload(topic, data)
+		return
+	}
+	publishBlock(data)
+}
+
+func mutateSignedMessageGossip() {
+	data, seed := mutateSeed(seedSignedMessage, rngChoice(targets))
+	if data == nil {
+		return
+	}
+	topic := fmt.Sprintf("/fil/msgs/%s", networkName)
+	if sameFirstElementCID(data, seed) {
+		// Only the signature changed; the inner message is the real pending
+		// one, which may rightly land on chain.
+		publishValidGossipPayload(topic, data)
+		return
+	}
+	publishMsg(data)
+}
+
+// sameFirstElementCID reports whether two CBOR arrays start with an element
+// of the same CID, i.e. a mutation left the header or inner message intact.
+func sameFirstElementCID(a, b []byte) bool {
+	fa, fb := firstArrayElement(a), firstArrayElement(b)
+	if fa == nil || fb == nil {
+		return false
+	}
+	return blockCIDFromCBOR(fa).Equals(blockCIDFromCBOR(fb))
+}
+
+func mutateHelloMessage(target TargetNode) {
+	if data := mutatedSeed(seedHello, target); data != nil {
+		sendHelloAttackPayload(target, data)
+	}
+}
+
+func mutateGMessageGossip() {
+	if data := mutatedSeed(seedGMessage, rngChoice(targets)); data != nil {
+		publishF3(data)
+	}
+}
+
+func mutateExchangeResponse(ctx context.Context, target TargetNode) {
+	resp := mutatedSeed(seedExchangeResponse, target)
+	if resp == nil {
+		return
+	}
+	exchangeServerHelper(ctx, target, "mutate-exchange-response", func(*chainHeadInfo) []byte {
+		return resp
+	})
+}
+
+// mutatedSeed returns a freshly mutated copy of the cached seed for kind.
+func mutatedSeed(kind seedKind, from TargetNode) []byte {
+	out, _ := mutateSeed(kind, from)
+	return out
+}
+
+// mutateSeed returns a mutated copy of the cached seed for kind along with
+// the seed itself. Mutations that left the bytes unchanged return nil, so
+// real objects are never sent as fabricated ones.
+func mutateSeed(kind seedKind, from TargetNode) ([]byte, []byte) {
+	seed, source := getSeed(kind, from)
+	rounds := 1 + rngIntn(envInt("FUZZER_MUTATION_MAX_ROUNDS", 3))
+	out, applied, err := mutateCBOR(seed, rounds)
+	if err != nil {
+		debugLog("[mutate] %s seed from %s unusable: %v", kind, source, err)
+		return nil, nil
+	}
+	if len(applied) == 0 || bytes.Equal(out, seed) {
+		debugLog("[mutate] %s from %s: no mutation applied, dropping", kind, source)
+		return nil, nil
+	}
+	log.Printf("[mutate] %s from %s: %d -> %d bytes via %s",
+		kind, source, len(seed), len(out), strings.Join(applied, ","))
+	return out, seed
+}
+
+// ---------------------------------------------------------------------------
+// Seed capture
+// ---------------------------------------------------------------------------
+
+// getSeed returns the cached seed for kind, recapturing it from the given
+// node once it is older than FUZZER_SEED_TTL_SEC.
+func getSeed(kind seedKind, from TargetNode) ([]byte, string) {
+	ttl := time.Duration(envInt("FUZZER_SEED_TTL_SEC", 60)) * time.Second
+
+	seedMu.Lock()
+	cached, ok := seeds[kind]
+	seedMu.Unlock()
+	if ok && time.Since(cached.at) < ttl {
+		return cached.data, cached.source
+	}
+
+	data, err := captureSeed(kind, from)
+	source := from.Name
+	if err != nil {
+		debugLog("[mutate] capture %s from %s failed: %v, using builder", kind, from.Name, err)
+		data, source = fallbackSeed(kind), "builder"
+	}
+
+	seedMu.Lock()
+	seeds[kind] = capturedSeed{data: data, source: source, at: time.Now()}
+	seedMu.Unlock()
+	return data, source
+}
+
+func captureSeed(kind seedKind, from TargetNode) ([]byte, error) {
+	switch kind {
+	case seedBlockHeader:
+		return captureBlockHeader(from)
+	case seedBlockMsg:
+		return captureBlockMsg(from)
+	case seedSignedMessage:
+		return captureSignedMessage(from)
+	case seedHello:
+		return captureHello(ctx, from)
+	case seedGMessage:
+		return captureGMessage(ctx)
+	case seedExchangeResponse:
+		return captureExchangeResponse(ctx, from)
+	}
+	return nil, fmt.Errorf("unknown seed kind %s", kind)
+}
+
+func fallbackSeed(kind seedKind) []byte {
+	switch kind {
+	case seedBlockHeader:
+		return buildBlockHeaderCBOR(blockHeaderOpts{})
+	case seedBlockMsg:
+		return cborArray(buildBlockHeaderCBOR(blockHeaderOpts{}), cborArray(), cborArray())
+	case seedSignedMessage:
+		return buildSignedMessageCBOR(buildMessageCBOR(nil), nil)
+	case seedHello:
+		return buildHelloMessage([]cid.Cid{randomCID()}, 1, 1, parseGenesisCID())
+	case seedGMessage:
+		return buildPartialGMessageCBOR(buildGMessageCBOR(f3MessageOpts{}), nil)
+	default:
+		return okResponse(buildBSTipSetCBOR([][]byte{buildBlockHeaderCBOR(blockHeaderOpts{})}, buildEmptyCompactedMsgsCBOR()))
+	}
+}
+
+// captureBlockHeader reads the raw header of the node's first head block.
+func captureBlockHeader(from TargetNode) ([]byte, error) {
+	head := fetchChainHead(from.Name)
+	if head == nil {
+		return nil, errors.New("no chain head")
+	}
+	var raw []byte
+	if err := callRPC(from.Name, "Filecoin.ChainReadObj", []any{head.CIDs[0]}, &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// captureBlockMsg rebuilds the BlockMsg gossiped for the node's head block.
+func captureBlockMsg(from TargetNode) ([]byte, error) {
+	head := fetchChainHead(from.Name)
+	if head == nil {
+		return nil, errors.New("no chain head")
+	}
+	var header []byte
+	if err := callRPC(from.Name, "Filecoin.ChainReadObj", []any{head.CIDs[0]}, &header); err != nil {
+		return nil, err
+	}
+	var msgs struct {
+		BlsMessages []json.RawMessage
+		Cids        []cid.Cid
+	}
+	if err := callRPC(from.Name, "Filecoin.ChainGetBlockMessages", []any{head.CIDs[0]}, &msgs); err != nil {
+		return nil, err
+	}
+	nBls := len(msgs.BlsMessages)
+	if nBls > len(msgs.Cids) {
+		return nil, errors.New("inconsistent block messages")
+	}
+	return cborArray(header, cborCIDArray(msgs.Cids[:nBls]), cborCIDArray(msgs.Cids[nBls:])), nil
+}
+
+// captureSignedMessage reads a pending secp-signed message from the mempool.
+func captureSignedMessage(from TargetNode) ([]byte, error) {
+	var pending []struct {
+		CID       cid.Cid
+		Signature struct{ Type int }
+	}
+	if err := callRPC(from.Name, "Filecoin.MpoolPending", []any{nil}, &pending); err != nil {
+		return nil, err
+	}
+	for _, m := range pending {
+		if m.Signature.Type != 1 { // secp256k1; BLS messages are stored unsigned
+			continue
+		}
+		var raw []byte
+		if err := callRPC(from.Name, "Filecoin.ChainReadObj", []any{m.CID}, &raw); err == nil {
+			return raw, nil
+		}
+	}
+	return nil, errors.New("no secp message pending")
+}
+
+// captureHello connects a fresh host advertising the Hello protocol and
+// records the Hello the node sends it.
+func captureHello(ctx context.Context, from TargetNode) ([]byte, error) {
+	h, err := pool.GetFresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+
+	got := make(chan []byte, 1)
+	h.SetStreamHandler(helloProtocol, func(s network.Stream) {
+		defer s.Close()
+		s.SetReadDeadline(time.Now().Add(2 * time.Second))
+		data, _ := io.ReadAll(io.LimitReader(s, 64*1024))
+		s.Write(cborArray(cborInt64(0), cborInt64(0)))
+		select {
+		case got <- data:
+		default:
+		}
+	})
+
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := h.Connect(connectCtx, from.AddrInfo); err != nil {
+		return nil, err
+	}
+
+	select {
+	case data := <-got:
+		if _, err := parseCBOR(data); err != nil {
+			return nil, fmt.Errorf("captured hello: %w", err)
+		}
+		return data, nil
+	case <-time.After(seedCaptureTimeout):
+		return nil, errors.New("no hello received")
+	}
+}
+
+// captureGMessage sniffs one PartialGMessage off the F3 Granite topic.
+func captureGMessage(ctx context.Context) ([]byte, error) {
+	topicName := fmt.Sprintf("/f3/granite/0.0.3/%s", networkName)
+	compressed, err := gossipPub.capture(ctx, topicName, seedCaptureTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return zstdDecompress(compressed)
+}
+
+// captureExchangeResponse asks the node for its head tipset (headers and
+// messages) over ChainExchange and keeps the raw response.
+func captureExchangeResponse(ctx context.Context, from TargetNode) ([]byte, error) {
+	head := fetchChainHead(from.Name)
+	if head == nil {
+		return nil, errors.New("no chain head")
+	}
+	h, err := pool.GetFresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+
+	s, err := openExchangeStream(ctx, h, from.AddrInfo)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	s.Write(buildExchangeRequest(head.CIDs, 1, 3)) // Headers | Messages
+	s.CloseWrite()
+	s.SetReadDeadline(time.Now().Add(seedCaptureTimeout))
+	data, err := io.ReadAll(io.LimitReader(s, 4<<20))
+	if err != nil && len(data) == 0 {
+		return nil, err
+	}
+	if _, err := parseCBOR(data); err != nil {
+		return nil, fmt.Errorf("captured response: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/proof"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// ---------------------------------------------------------------------------
+// Near-valid blocks
+//
+// Random headers die at CBOR decode or the syntactic checks. These headers
+// are a copy of a real head block (same parents, height, parent weight,
+// parent state root, receipts, message root, BLS aggregate, timestamp and
+// base fee) with exactly one consensus-checked field broken:
+//   ticket, election-proof, beacon-entries, winning-proof, base-fee,
+//   state-root
+// The block is a sibling of the head, so its ParentWeight ties the victim's
+// best chain and Lotus's InformNewHead does not discard it before
+// ValidateBlock runs.
+//
+// The copied BlockSig no longer matches, so the block is always invalid. On
+// GossipSub that stops it at the signature check; served over ChainExchange
+// the syncer runs every ValidateBlock check on it.
+// ---------------------------------------------------------------------------
+
+const nearValidServeTimeout = 15 * time.Second
+
+// nearValidField breaks one field of a header copied from the chain head.
+type nearValidField struct {
+	name   string
+	mutate func(h *types.BlockHeader)
+}
+
+var nearValidFields = []nearValidField{
+	{"ticket", mutateNearValidTicket},
+	{"election-proof", mutateNearValidElectionProof},
+	{"beacon-entries", mutateNearValidBeaconEntries},
+	{"winning-proof", mutateNearValidWinPoSt},
+	{"base-fee", func(h *types.BlockHeader) {
+		h.ParentBaseFee = types.BigAdd(h.ParentBaseFee, types.NewInt(uint64(1+rngIntn(1000))))
+	}},
+	{"state-root", func(h *types.BlockHeader) {
+		// Receipts and message roots are real objects in the store, just not
+		// state trees.
+		h.ParentStateRoot = rngChoice([]cid.Cid{h.ParentMessageReceipts, h.Messages, randomCID()})
+	}},
+}
+
+func getAllNearValidBlockAttacks() []namedAttack {
+	var attacks []namedAttack
+	for _, f := range nearValidFields {
+		attacks = append(attacks,
+			namedAttack{
+				name:       fmt.Sprintf("nearvalid/all-block-with-bad-%s-via-exchange", f.name),
+				targetedFn: func(t TargetNode) { runNearValidExchange(ctx, t, f) },
+				targetType: nodeAny,
+			},
+			namedAttack{
+				name: fmt.Sprintf("nearvalid/all-block-with-bad-%s-on-gossip", f.name),
+				fn:   func() { runNearValidGossip(f) },
+			},
+		)
+	}
+	return attacks
+}
+
+// ---------------------------------------------------------------------------
+// Field mutations
+// ---------------------------------------------------------------------------
+
+func mutateNearValidTicket(h *types.BlockHeader) {
+	if h.Ticket == nil || rngIntn(2) == 0 {
+		h.Ticket = &types.Ticket{VRFProof: randomBytes(96)}
+		return
+	}
+	vrf := append([]byte(nil), h.Ticket.VRFProof...)
+	flipRandomBit(vrf)
+	h.Ticket = &types.Ticket{VRFProof: vrf}
+}
+
+func mutateNearValidElectionProof(h *types.BlockHeader) {
+	ep := &types.ElectionProof{WinCount: 1, VRFProof: randomBytes(96)}
+	if h.ElectionProof != nil {
+		ep.WinCount = h.ElectionProof.WinCount
+		ep.VRFProof = append([]byte(nil), h.ElectionProof.VRFProof...)
+	}
+	switch rngIntn(3) {
+	case 0: // claim more wins than the VRF output allows
+		ep.WinCount += int64(1 + rngIntn(5))
+	case 1:
+		flipRandomBit(ep.VRFProof)
+	default:
+		ep.VRFProof = randomBytes(96)
+	}
+	h.ElectionProof = ep
+}
+
+func mutateNearValidBeaconEntries(h *types.BlockHeader) {
+	entries := make([]types.BeaconEntry, len(h.BeaconEntries))
+	for i, e := range h.BeaconEntries {
+		entries[i] = types.BeaconEntry{Round: e.Round, Data: append([]byte(nil), e.Data...)}
+	}
+	if len(entries) == 0 {
+		h.BeaconEntries = []types.BeaconEntry{{Round: 1, Data: randomBytes(96)}}
+		return
+	}
+
+	i := rngIntn(len(entries))
+	switch rngIntn(4) {
+	case 0: // skip ahead a round
+		entries[i].Round++
+	case 1:
+		flipRandomBit(entries[i].Data)
+	case 2: // drop the newest entry
+		entries = entries[:len(entries)-1]
+	default: // repeat an entry
+		entries = append(entries, entries[i])
+	}
+	h.BeaconEntries = entries
+}
+
+func mutateNearValidWinPoSt(h *types.BlockHeader) {
+	if len(h.WinPoStProof) == 0 {
+		h.WinPoStProof = []proof.PoStProof{{
+			PoStProof:  abi.RegisteredPoStProof_StackedDrgWinning2KiBV1,
+			ProofBytes: randomBytes(192),
+		}}
+		return
+	}
+	p := h.WinPoStProof[0]
+	p.ProofBytes = append([]byte(nil), p.ProofBytes...)
+	switch rngIntn(3) {
+	case 0:
+		flipRandomBit(p.ProofBytes)
+	case 1:
+		p.ProofBytes = p.ProofBytes[:len(p.ProofBytes)/2]
+	default: // proof for a different sector size
+		p.PoStProof = abi.RegisteredPoStProof_StackedDrgWinning8MiBV1
+	}
+	h.WinPoStProof = []proof.PoStProof{p}
+}
+
+func flipRandomBit(b []byte) {
+	if len(b) > 0 {
+		b[rngIntn(len(b))] ^= byte(1 << uint(rngIntn(8)))
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Base block
+// ---------------------------------------------------------------------------
+
+// nearValidBase is one block of a node's head tipset plus its messages.
+type nearValidBase struct {
+	header    *types.BlockHeader
+	bls       []*types.Message
+	secpk     []*types.SignedMessage
+	blsCids   []cid.Cid
+	secpkCids []cid.Cid
+}
+
+// fetchNearValidBase picks a random block of from's head tipset, limited to
+// blocks want accepts when want is non-nil.
+func fetchNearValidBase(from TargetNode, want func(*types.BlockHeader) bool) (*nearValidBase, error) {
+	var head struct {
+		Cids   []cid.Cid
+		Blocks []*types.BlockHeader
+	}
+	if err := callRPC(from.Name, "Filecoin.ChainHead", nil, &head); err != nil {
+		return nil, err
+	}
+	if len(head.Blocks) == 0 || len(head.Blocks) != len(head.Cids) {
+		return nil, errors.New("empty head tipset")
+	}
+	var eligible []int
+	for i, b := range head.Blocks {
+		if want == nil || want(b) {
+			eligible = append(eligible, i)
+		}
+	}
+	if len(eligible) == 0 {
+		return nil, errors.New("no eligible block in head tipset")
+	}
+	i := rngChoice(eligible)
+
+	var msgs struct {
+		BlsMessages   []*types.Message
+		SecpkMessages []*types.SignedMessage
+		Cids          []cid.Cid
+	}
+	if err := callRPC(from.Name, "Filecoin.ChainGetBlockMessages", []any{head.Cids[i]}, &msgs); err != nil {
+		return nil, err
+	}
+	nBls := len(msgs.BlsMessages)
+	if nBls+len(msgs.SecpkMessages) != len(msgs.Cids) {
+		return nil, errors.New("inconsistent block messages")
+	}
+	return &nearValidBase{
+		header:    head.Blocks[i],
+		bls:       msgs.BlsMessages,
+		secpk:     msgs.SecpkMessages,
+		blsCids:   msgs.Cids[:nBls],
+		secpkCids: msgs.Cids[nBls:],
+	}, nil
+}
+
+// build returns a copy of the base header with field broken, serialized. A
+// mutation that changes nothing would reproduce the real head block, which
+// must never be registered as fabricated, so that is an error.
+func (b *nearValidBase) build(field nearValidField) ([]byte, cid.Cid, error) {
+	h := *b.header
+	field.mutate(&h)
+	data, err := h.Serialize()
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	c := blockCIDFromCBOR(data)
+	if c.Equals(b.header.Cid()) {
+		return nil, cid.Undef, fmt.Errorf("%s mutation left the header unchanged", field.name)
+	}
+	return data, c, nil
+}
+
+// compactedMessages encodes the base block's messages as ChainExchange
+// CompactedMessages for a single-block tipset.
+func (b *nearValidBase) compactedMessages() ([]byte, error) {
+	var bls, blsIdx, secpk, secpkIdx [][]byte
+	for i, m := range b.bls {
+		var buf bytes.Buffer
+		if err := m.MarshalCBOR(&buf); err != nil {
+			return nil, err
+		}
+		bls = append(bls, buf.Bytes())
+		blsIdx = append(blsIdx, cborUint64(uint64(i)))
+	}
+	for i, m := range b.secpk {
+		var buf bytes.Buffer
+		if err := m.MarshalCBOR(&buf); err != nil {
+			return nil, err
+		}
+		secpk = append(secpk, buf.Bytes())
+		secpkIdx = append(secpkIdx, cborUint64(uint64(i)))
+	}
+	return cborArray(
+		cborArray(bls...),
+		cborArray(cborArray(blsIdx...)),
+		cborArray(secpk...),
+		cborArray(cborArray(secpkIdx...)),
+	), nil
+}
+
+// ---------------------------------------------------------------------------
+// Delivery
+// ---------------------------------------------------------------------------
+
+// runNearValidGossip publishes the block as a BlockMsg carrying the head
+// block's message CIDs, so msg-meta validation passes.
+func runNearValidGossip(field nearValidField) {
+	base, err := fetchNearValidBase(rngChoice(targets), nil)
+	if err != nil {
+		debugLog("[nearvalid] fetch base failed: %v", err)
+		return
+	}
+	header, c, err := base.build(field)
+	if err != nil {
+		debugLog("[nearvalid] build %s failed: %v", field.name, err)
+		return
+	}
+	log.Printf("[nearvalid] gossip bad-%s block %s at height %d", field.name, c, base.header.Height)
+	publishBlock(cborArray(header, cborCIDArray(base.blsCids), cborCIDArray(base.secpkCids)))
+}
+
+// runNearValidExchange announces the block to target via Hello and serves it
+// (headers and messages) to every ChainExchange request until the hold
+// expires, so both FetchTipSet and the sync worker get it.
+func runNearValidExchange(ctx context.Context, target TargetNode, field nearValidField) {
+	base, err := fetchNearValidBase(target, nil)
+	if err != nil {
+		debugLog("[nearvalid] fetch base from %s failed: %v", target.Name, err)
+		return
+	}
+	header, c, err := base.build(field)
+	if err != nil {
+		debugLog("[nearvalid] build %s failed: %v", field.name, err)
+		return
+	}
+	msgs, err := base.compactedMessages()
+	if err != nil {
+		debugLog("[nearvalid] encode messages failed: %v", err)
+		return
+	}
+	registerFabricated(c, fabBlock, false)
+	resp := okResponse(buildBSTipSetCBOR([][]byte{header}, msgs))
+
+	h, err := pool.GetFresh(ctx)
+	if err != nil {
+		log.Printf("[nearvalid] create host failed: %v", err)
+		return
+	}
+	defer h.Close()
+
+	var served atomic.Int32
+	h.SetStreamHandler(exchangeProtocol, func(s network.Stream) {
+		defer s.Close()
+		io.Copy(io.Discard, io.LimitReader(s, 64*1024))
+		s.Write(resp)
+		served.Add(1)
+	})
+	h.SetStreamHandler(helloProtocol, func(s network.Stream) {
+		io.Copy(io.Discard, io.LimitReader(s, 64*1024))
+		s.Write(cborArray(cborInt64(0), cborInt64(0)))
+		s.Close()
+	})
+
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := h.Connect(connectCtx, target.AddrInfo); err != nil {
+		debugLog("[nearvalid] connect to %s failed: %v", target.Name, err)
+		return
+	}
+
+	sendHelloPayload(ctx, h, target.AddrInfo.ID, buildHelloMessage(
+		[]cid.Cid{c}, uint64(base.header.Height), base.header.ParentWeight.Uint64(), parseGenesisCID(),
+	))
+
+	hold := time.Duration(envInt("FUZZER_NEAR_VALID_HOLD_SEC", 10)) * time.Second
+	deadline := time.Now().Add(nearValidServeTimeout)
+	for served.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(250 * time.Millisecond)
+	}
+	if served.Load() > 0 {
+		time.Sleep(hold)
+	}
+	log.Printf("[nearvalid] bad-%s block %s served %d times to %s", field.name, c, served.Load(), target.Name)
+
+	if strings.HasPrefix(target.Name, "forest") || served.Load() == 0 {
+		return
+	}
+	// A bad-block reason means the syncer ran ValidateBlock on it.
+	var reason string
+	if err := callRPC(target.Name, "Filecoin.SyncCheckBad", []any{c}, &reason); err != nil {
+		debugLog("[nearvalid] SyncCheckBad on %s failed: %v", target.Name, err)
+		return
+	}
+	if reason != "" {
+		debugLog("[nearvalid] %s marked %s bad: %s", target.Name, c, reason)
+	}
+	assert.Sometimes(reason != "", "Near-valid block reaches Lotus consensus validation", map[string]any{
+		"target": target.Name,
+		"field":  field.name,
+		"block":  c.String(),
+		"height": base.header.Height,
+		"served": served.Load(),
+	})
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Replay and minimisation subcommands
+//
+//   protocol-fuzzer replay   <entry> [target]
+//   protocol-fuzzer minimize <entry> [target]
+//
+// <entry> is a corpus file path, a sequence number or a payload hash prefix
+// (as printed in the liveness oracle's recent_attacks). target defaults to
+// the node the payload was originally sent to; broadcast GossipSub entries
+// need one for minimize so there is a node to probe.
+// ---------------------------------------------------------------------------
+
+// replayPayload re-sends data on the channel it was originally sent on.
+func replayPayload(channel string, data []byte, target *TargetNode) error {
+	if strings.HasPrefix(channel, "/") {
+		publishGossipPayload(channel, data)
+		return nil
+	}
+	if target == nil {
+		return fmt.Errorf("channel %s needs a target", channel)
+	}
+
+	switch channel {
+	case channelHello:
+		sendHelloAttackPayload(*target, data)
+	case channelXchgRequest:
+		h, err := pool.GetFresh(ctx)
+		if err != nil {
+			return err
+		}
+		defer h.Close()
+		s, err := openExchangeStream(ctx, h, target.AddrInfo)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		s.Write(data)
+		s.CloseWrite()
+		readResponse(s)
+	case channelXchgResponse:
+		exchangeServerHelper(ctx, *target, "replay", func(*chainHeadInfo) []byte { return data })
+	case channelCertEx:
+		sendCertExchangeRequest(*target, data)
+	case channelDHT:
+		sendDHTPayload(*target, data, 1)
+	case channelGossipRPC:
+		return sendGossipRPCPayload(*target, data)
+	default:
+		return fmt.Errorf("unknown channel %q", channel)
+	}
+	return nil
+}
+
+// corpusDir is where the corpus lives, shared by the main loop and the
+// subcommands.
+func corpusDir() string {
+	return envOrDefault("FUZZER_CORPUS_DIR", "/root/fuzzer-corpus")
+}
+
+// findTarget returns the discovered target with the given name.
+func findTarget(name string) *TargetNode {
+	for _, t := range targets {
+		if t.Name == name {
+			t := t
+			return &t
+		}
+	}
+	return nil
+}
+
+// resolveReplayArgs loads the corpus entry and picks the target.
+func resolveReplayArgs(args []string) (*corpusEntry, *TargetNode, error) {
+	if len(args) < 1 {
+		return nil, nil, fmt.Errorf("usage: protocol-fuzzer replay|minimize <entry> [target]")
+	}
+	e, err := loadCorpusEntry(corpusDir(), args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := e.Target
+	if len(args) > 1 {
+		name = args[1]
+	}
+	if name == "" {
+		return e, nil, nil
+	}
+	t := findTarget(name)
+	if t == nil {
+		return nil, nil, fmt.Errorf("target %q not discovered", name)
+	}
+	return e, t, nil
+}
+
+func runReplayCommand(args []string) error {
+	e, target, err := resolveReplayArgs(args)
+	if err != nil {
+		return err
+	}
+	log.Printf("[replay] seq=%d attack=%s channel=%s hash=%s", e.Seq, e.Attack, e.Channel, e.Hash)
+	if err := replayPayload(e.Channel, e.Data, target); err != nil {
+		return err
+	}
+	if target == nil {
+		return nil
+	}
+
+	time.Sleep(time.Duration(envInt("FUZZER_REPLAY_SETTLE_SEC", 5)) * time.Second)
+	r := probeTarget(ctx, *target)
+	log.Printf("[replay] %s after replay: rpc_ok=%v p2p=%q", target.Name, r.rpcOK(), r.p2pErr)
+	return nil
+}
+
+func runMinimizeCommand(args []string) error {
+	e, target, err := resolveReplayArgs(args)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return fmt.Errorf("entry %d was broadcast; pass a target to probe", e.Seq)
+	}
+
+	settle := time.Duration(envInt("FUZZER_REPLAY_SETTLE_SEC", 5)) * time.Second
+	restart := time.Duration(envInt("FUZZER_MINIMIZE_RESTART_SEC", 120)) * time.Second
+	budget := envInt("FUZZER_MINIMIZE_MAX_TRIALS", 200)
+
+	// fails sends a candidate and reports whether the target went down. After
+	// a failure it waits for the node to come back so the next trial starts
+	// from a healthy target.
+	fails := func(data []byte) bool {
+		if err := replayPayload(e.Channel, data, target); err != nil {
+			debugLog("[minimize] replay failed: %v", err)
+			return false
+		}
+		time.Sleep(settle)
+		r := probeTarget(ctx, *target)
+		if r.rpcOK() && r.p2pOK() {
+			return false
+		}
+		deadline := time.Now().Add(restart)
+		for time.Now().Before(deadline) {
+			time.Sleep(oracleReprobeEvery)
+			if r := probeTarget(ctx, *target); r.rpcOK() && r.p2pOK() {
+				break
+			}
+		}
+		return true
+	}
+
+	log.Printf("[minimize] seq=%d attack=%s channel=%s size=%d target=%s",
+		e.Seq, e.Attack, e.Channel, len(e.Data), target.Name)
+	if !fails(e.Data) {
+		return fmt.Errorf("original payload does not take %s down; nothing to minimise", target.Name)
+	}
+
+	smallest, trials := minimizeCBOR(e.Data, budget, fails)
+	hash := payloadHash(smallest)
+	log.Printf("[minimize] %d -> %d bytes in %d trials, hash=%s", len(e.Data), len(smallest), trials, hash)
+
+	if corpus != nil {
+		corpus.save(corpusEntry{
+			Attack:  "minimized:" + e.Attack,
+			Target:  target.Name,
+			Channel: e.Channel,
+			Hash:    hash,
+			Data:    smallest,
+		})
+	}
+	return nil
+}
+
+// minimizeCBOR shrinks a CBOR payload while fails keeps returning true. Byte
+// deletions almost always leave invalid CBOR that the node rejects at decode,
+// so it works on the parseCBOR tree instead: drop array items and map pairs,
+// hoist a child into its parent's place, halve strings and zero integers,
+// keeping declared counts and lengths in step unless the original already
+// lied about them. Payloads that aren't a single CBOR item (protobuf, zstd)
+// fall back to ddmin. Returns the smallest failing input plus the number of
+// trials used.
+func minimizeCBOR(data []byte, maxTrials int, fails func([]byte) bool) ([]byte, int) {
+	root, err := parseCBOR(data)
+	if err != nil {
+		return ddmin(data, maxTrials, fails)
+	}
+
+	trials := 0
+	try := func(apply func() func()) bool {
+		if trials >= maxTrials {
+			return false
+		}
+		undo := apply()
+		if undo == nil {
+			return false
+		}
+		trials++
+		if fails(root.encode()) {
+			return true
+		}
+		undo()
+		return false
+	}
+
+	for reduced := true; reduced && trials < maxTrials; {
+		reduced = false
+		for _, s := range collectSites(root) {
+			if reduced = shrinkCBORSite(s, try); reduced {
+				// The tree changed shape; re-collect sites.
+				break
+			}
+		}
+	}
+	return root.encode(), trials
+}
+
+// shrinkCBORSite tries each reduction of one site in turn, largest first, and
+// reports whether one was kept.
+func shrinkCBORSite(s cborSite, try func(func() func()) bool) bool {
+	n := s.node
+
+	// Drop this item (or its key/value pair) from the enclosing array or map.
+	if p := s.parent; p != nil && (p.major == 4 || p.major == 5) {
+		if try(func() func() {
+			start, step := s.idx, 1
+			if p.major == 5 {
+				start, step = s.idx&^1, 2
+			}
+			if start+step > len(p.items) {
+				return nil
+			}
+			items, arg := p.items, p.arg
+			p.items = append(append([]*cborNode{}, items[:start]...), items[start+step:]...)
+			if p.width != cborIndefinite && p.arg == uint64(len(items)/step) {
+				p.arg = uint64(len(p.items) / step)
+			}
+			return func() { p.items, p.arg = items, arg }
+		}) {
+			return true
+		}
+	}
+
+	// Replace this container or tag with one of its children.
+	for _, c := range n.items {
+		c := c
+		if try(func() func() {
+			saved := *n
+			*n = *c
+			return func() { *n = saved }
+		}) {
+			return true
+		}
+	}
+
+	switch n.major {
+	case 2, 3:
+		if len(n.raw) == 0 {
+			return false
+		}
+		return try(func() func() {
+			raw, arg := n.raw, n.arg
+			n.raw = raw[:len(raw)/2]
+			if n.width != cborIndefinite && n.arg == uint64(len(raw)) {
+				n.arg = uint64(len(n.raw))
+			}
+			return func() { n.raw, n.arg = raw, arg }
+		})
+	case 0, 1:
+		if n.arg == 0 && n.width == 0 {
+			return false
+		}
+		return try(func() func() {
+			arg, width := n.arg, n.width
+			n.arg, n.width = 0, 0
+			return func() { n.arg, n.width = arg, width }
+		})
+	}
+	return false
+}
+
+// ddmin shrinks raw bytes while fails keeps returning true, removing ever smaller
+// chunks (Zeller's delta debugging, complement-only variant). It stops after
+// maxTrials calls to fails and returns the smallest failing input plus the
+// number of trials used.
+func ddmin(data []byte, maxTrials int, fails func([]byte) bool) ([]byte, int) {
+	n, trials := 2, 0
+	for len(data) >= 2 && trials < maxTrials {
+		chunk := (len(data) + n - 1) / n
+		reduced := false
+		for start := 0; start < len(data) && trials < maxTrials; start += chunk {
+			end := start + chunk
+			if end > len(data) {
+				end = len(data)
+			}
+			candidate := append(append([]byte{}, data[:start]...), data[end:]...)
+			trials++
+			if fails(candidate) {
+				data = candidate
+				if n > 2 {
+					n--
+				}
+				reduced = true
+				break
+			}
+		}
+		if !reduced {
+			if n >= len(data) {
+				break
+			}
+			n *= 2
+			if n > len(data) {
+				n = len(data)
+			}
+		}
+	}
+	return data, trials
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-f3/blssig"
+	"github.com/filecoin-project/go-f3/certs"
+	"github.com/filecoin-project/go-f3/gpbft"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"go.dedis.ch/kyber/v4"
+	"go.dedis.ch/kyber/v4/pairing/bls12381/kilic"
+	"go.dedis.ch/kyber/v4/sign/bdn"
+)
+
+// ---------------------------------------------------------------------------
+// Signed adversary
+//
+// Every other block and F3 vector signs with randomBytes(96), so nodes drop
+// it at the first signature check. The genesis miners' pre-seal keys sit on
+// the shared volume, and for a genesis miner that key is both owner and
+// worker: it signs blocks and F3 messages. This adversary loads them and
+// sends:
+//   - equivocating blocks: a second correctly signed block from the same
+//     miner on the same parents at the same height
+//   - re-signed near-valid blocks: one consensus field broken but a valid
+//     signature, so validation gets past the signature check
+//   - conflicting GPBFT votes for the same instance, round and phase
+//   - justifications with valid aggregate signatures but the wrong phase,
+//     round, value, supplemental data or power behind them
+//
+// F3 messages only ever come from miners holding under a third of the
+// instance's power, so GPBFT's safety guarantee still holds and nodes
+// finalizing different chains for an instance is a real bug.
+// ---------------------------------------------------------------------------
+
+const minerKeysRetry = 30 * time.Second
+
+var (
+	minerKeySuite  = kilic.NewBLS12381Suite()
+	minerKeyScheme = bdn.NewSchemeOnG2(minerKeySuite)
+	f3Verifier     = blssig.VerifierWithKeyOnG1()
+)
+
+// minerKey is a genesis miner's worker key, checked against the chain.
+type minerKey struct {
+	miner address.Address
+	id    gpbft.ActorID
+	pub   []byte // compressed G1, the worker address payload
+	priv  kyber.Scalar
+}
+
+func (k *minerKey) sign(msg []byte) ([]byte, error) {
+	return minerKeyScheme.Sign(k.priv, msg)
+}
+
+// signBlock replaces h's BlockSig with the worker's signature over it.
+func (k *minerKey) signBlock(h *types.BlockHeader) error {
+	h.BlockSig = nil
+	data, err := h.SigningBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := k.sign(data)
+	if err != nil {
+		return err
+	}
+	h.BlockSig = &crypto.Signature{Type: crypto.SigTypeBLS, Data: sig}
+	return nil
+}
+
+var (
+	minerKeysMu    sync.Mutex
+	minerKeys      []*minerKey
+	minerKeysTried time.Time
+)
+
+// getMinerKeys loads the miner keys on first use. Loading is retried while
+// none verify, since the fuzzer can start before StateMinerInfo answers.
+func getMinerKeys() []*minerKey {
+	minerKeysMu.Lock()
+	defer minerKeysMu.Unlock()
+
+	if len(minerKeys) > 0 || time.Since(minerKeysTried) < minerKeysRetry {
+		return minerKeys
+	}
+	minerKeysTried = time.Now()
+	minerKeys = loadMinerKeys(envOrDefault("FUZZER_MINER_KEYS_DIR", "/shared/configs"))
+	log.Printf("[signed] loaded %d miner keys", len(minerKeys))
+	return minerKeys
+}
+
+func minerKeyFor(miner address.Address) *minerKey {
+	for _, k := range getMinerKeys() {
+		if k.miner == miner {
+			return k
+		}
+	}
+	return nil
+}
+
+func loadMinerKeys(dir string) []*minerKey {
+	files, _ := filepath.Glob(filepath.Join(dir, ".genesis-sector-*", "pre-seal-*.key"))
+	var keys []*minerKey
+	for _, f := range files {
+		k, err := loadMinerKey(f)
+		if err != nil {
+			debugLog("[signed] skipping %s: %v", f, err)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// loadMinerKey reads a lotus-seed key file (hex of a JSON KeyInfo) named
+// after its miner, and keeps it only if it derives the miner's worker key.
+func loadMinerKey(path string) (*minerKey, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "pre-seal-"), ".key")
+	maddr, err := address.NewFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	id, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	kiJSON, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, err
+	}
+	var ki types.KeyInfo
+	if err := json.Unmarshal(kiJSON, &ki); err != nil {
+		return nil, err
+	}
+	if ki.Type != types.KTBLS {
+		return nil, fmt.Errorf("key type %s, want bls", ki.Type)
+	}
+
+	worker, err := fetchWorkerKey(maddr)
+	if err != nil {
+		return nil, err
+	}
+	// Filecoin stores BLS private keys little-endian; kyber reads
+	// big-endian. Try both and keep whichever derives the worker key.
+	le := slices.Clone(ki.PrivateKey)
+	slices.Reverse(le)
+	for _, b := range [][]byte{le, ki.PrivateKey} {
+		priv := minerKeySuite.G1().Scalar().SetBytes(b)
+		pub, err := minerKeySuite.G1().Point().Mul(priv, nil).MarshalBinary()
+		if err == nil && bytes.Equal(pub, worker.Payload()) {
+			return &minerKey{miner: maddr, id: gpbft.ActorID(id), pub: pub, priv: priv}, nil
+		}
+	}
+	return nil, fmt.Errorf("key does not derive worker %s", worker)
+}
+
+// fetchWorkerKey resolves a miner's worker to its BLS key address.
+func fetchWorkerKey(maddr address.Address) (address.Address, error) {
+	t := pickTargetForType(nodeLotus)
+	if t == nil {
+		t = pickTargetForType(nodeAny)
+	}
+	if t == nil {
+		return address.Undef, errors.New("no targets")
+	}
+	var info struct{ Worker address.Address }
+	if err := callRPC(t.Name, "Filecoin.StateMinerInfo", []any{maddr, nil}, &info); err != nil {
+		return address.Undef, err
+	}
+	var key address.Address
+	if err := callRPC(t.Name, "Filecoin.StateAccountKey", []any{info.Worker, nil}, &key); err != nil {
+		return address.Undef, err
+	}
+	if key.Protocol() != address.BLS {
+		return address.Undef, fmt.Errorf("worker %s is not a BLS key", key)
+	}
+	return key, nil
+}
+
+func signedSettle() time.Duration {
+	return time.Duration(envInt("FUZZER_SIGNED_SETTLE_SEC", 10)) * time.Second
+}
+
+func getAllSignedAdversaryAttacks() []namedAttack {
+	attacks := []namedAttack{
+		{name: "signed/all-equivocating-block-from-miner-key", fn: signedEquivocatingBlock},
+		{name: "signed/all-conflicting-gpbft-votes-same-round", fn: signedF3Equivocation},
+	}
+	for _, c := range signedJustCases {
+		attacks = append(attacks, namedAttack{
+			name: fmt.Sprintf("signed/all-%s", c.name),
+			fn:   func() { signedF3BadJustification(c) },
+		})
+	}
+	for _, f := range nearValidFields {
+		attacks = append(attacks, namedAttack{
+			name: fmt.Sprintf("signed/all-resigned-block-with-bad-%s", f.name),
+			fn:   func() { signedNearValidGossip(f) },
+		})
+	}
+	return attacks
+}
+
+// ---------------------------------------------------------------------------
+// Blocks
+// ---------------------------------------------------------------------------
+
+func hasMinerKey(h *types.BlockHeader) bool {
+	return minerKeyFor(h.Miner) != nil
+}
+
+// signedEquivocatingBlock re-publishes a head block we hold the key for
+// with a different ForkSignaling, re-signed. Both blocks are valid, so the
+// new one is published without registering it as fabricated.
+func signedEquivocatingBlock() {
+	base, err := fetchNearValidBase(rngChoice(targets), hasMinerKey)
+	if err != nil {
+		debugLog("[signed] fetch base failed: %v", err)
+		return
+	}
+	k := minerKeyFor(base.header.Miner)
+
+	h := *base.header
+	h.ForkSignaling = base.header.ForkSignaling + 1 + uint64(rngIntn(1<<16))
+	if err := k.signBlock(&h); err != nil {
+		debugLog("[signed] sign block failed: %v", err)
+		return
+	}
+	data, err := h.Serialize()
+	if err != nil {
+		debugLog("[signed] serialize block failed: %v", err)
+		return
+	}
+	log.Printf("[signed] equivocating block %s from %s at height %d (original %s)",
+		h.Cid(), h.Miner, h.Height, base.header.Cid())
+	publishValidGossipPayload(fmt.Sprintf("/fil/blocks/%s", networkName),
+		cborArray(data, cborCIDArray(base.blsCids), cborCIDArray(base.secpkCids)))
+
+	time.Sleep(signedSettle())
+	checkTipSetMiners(h.Height)
+}
+
+// checkTipSetMiners asserts no node's tipset at height holds two blocks from
+// one miner; nodes must pick at most one side of an equivocation.
+func checkTipSetMiners(height abi.ChainEpoch) {
+	for _, t := range targets {
+		var ts struct {
+			Cids   []cid.Cid
+			Blocks []*types.BlockHeader
+			Height abi.ChainEpoch
+		}
+		if err := callRPC(t.Name, "Filecoin.ChainGetTipSetByHeight", []any{height, nil}, &ts); err != nil {
+			debugLog("[signed] ChainGetTipSetByHeight(%d) on %s failed: %v", height, t.Name, err)
+			continue
+		}
+		seen := make(map[address.Address]bool)
+		var dup address.Address
+		for _, b := range ts.Blocks {
+			if seen[b.Miner] {
+				dup = b.Miner
+			}
+			seen[b.Miner] = true
+		}
+		assert.Always(dup == address.Undef, "Tipset never contains two blocks from the same miner", map[string]any{
+			"node":   t.Name,
+			"height": ts.Height,
+			"miner":  dup.String(),
+			"cids":   fmt.Sprint(ts.Cids),
+		})
+	}
+}
+
+// signedNearValidGossip breaks one field of a head block like
+// runNearValidGossip, then re-signs it so the signature check passes.
+func signedNearValidGossip(field nearValidField) {
+	base, err := fetchNearValidBase(rngChoice(targets), hasMinerKey)
+	if err != nil {
+		debugLog("[signed] fetch base failed: %v", err)
+		return
+	}
+	h := *base.header
+	field.mutate(&h)
+	if h.Cid().Equals(base.header.Cid()) {
+		debugLog("[signed] %s mutation left the header unchanged", field.name)
+		return
+	}
+	if err := minerKeyFor(h.Miner).signBlock(&h); err != nil {
+		debugLog("[signed] sign block failed: %v", err)
+		return
+	}
+	data, err := h.Serialize()
+	if err != nil {
+		debugLog("[signed] serialize block failed: %v", err)
+		return
+	}
+	log.Printf("[signed] re-signed bad-%s block %s at height %d", field.name, h.Cid(), h.Height)
+	// The pubsub validator doesn't check the broken field, so the syncer
+	// may store the header before rejecting it. Only the chain check holds.
+	registerFabricated(h.Cid(), fabBlock, false)
+	publishValidGossipPayload(fmt.Sprintf("/fil/blocks/%s", networkName),
+		cborArray(data, cborCIDArray(base.blsCids), cborCIDArray(base.secpkCids)))
+}
+
+// ---------------------------------------------------------------------------
+// GPBFT
+// ---------------------------------------------------------------------------
+
+// f3Signer is one of our keys and its index in the committee power table.
+type f3Signer struct {
+	key   *minerKey
+	index int
+}
+
+// f3Instance is what the signed GPBFT vectors need about the instance in
+// progress.
+type f3Instance struct {
+	nn       gpbft.NetworkName
+	progress gpbft.InstanceProgress
+	table    *gpbft.PowerTable
+	supp     gpbft.SupplementalData
+	byz      []f3Signer // sorted by index, under 1/3 of the power
+}
+
+func fetchF3Instance() (*f3Instance, error) {
+	keys := getMinerKeys()
+	if len(keys) == 0 {
+		return nil, errors.New("no miner keys")
+	}
+	t := pickTargetForType(nodeLotus)
+	if t == nil {
+		return nil, errors.New("no lotus targets")
+	}
+
+	var manifest struct{ NetworkName gpbft.NetworkName }
+	if err := callRPC(t.Name, "Filecoin.F3GetManifest", nil, &manifest); err != nil {
+		return nil, err
+	}
+	var progress gpbft.InstanceProgress
+	if err := callRPC(t.Name, "Filecoin.F3GetProgress", nil, &progress); err != nil {
+		return nil, err
+	}
+	if progress.Input.IsZero() {
+		return nil, fmt.Errorf("instance %d has no input yet", progress.ID)
+	}
+
+	var entries, next gpbft.PowerEntries
+	if err := callRPC(t.Name, "Filecoin.F3GetPowerTableByInstance", []any{progress.ID}, &entries); err != nil {
+		return nil, err
+	}
+	if err := callRPC(t.Name, "Filecoin.F3GetPowerTableByInstance", []any{progress.ID + 1}, &next); err != nil {
+		return nil, err
+	}
+	// Honest participants commit to the next instance's power table.
+	ptCID, err := certs.MakePowerTableCID(next)
+	if err != nil {
+		return nil, err
+	}
+	table := gpbft.NewPowerTable()
+	if err := table.Add(entries...); err != nil {
+		return nil, err
+	}
+
+	inst := &f3Instance{
+		nn:       manifest.NetworkName,
+		progress: progress,
+		table:    table,
+		supp:     gpbft.SupplementalData{PowerTable: ptCID},
+	}
+	// Add our keys in random order while staying under a third of the
+	// scaled power, the bound GPBFT tolerates.
+	order := slices.Clone(keys)
+	for i := len(order) - 1; i > 0; i-- {
+		j := rngIntn(i + 1)
+		order[i], order[j] = order[j], order[i]
+	}
+	var power int64
+	for _, k := range order {
+		idx, ok := table.Lookup[k.id]
+		if !ok || !bytes.Equal(table.Entries[idx].PubKey, k.pub) {
+			continue
+		}
+		if 3*(power+table.ScaledPower[idx]) >= table.ScaledTotal {
+			continue
+		}
+		power += table.ScaledPower[idx]
+		inst.byz = append(inst.byz, f3Signer{key: k, index: idx})
+	}
+	if len(inst.byz) == 0 {
+		return nil, fmt.Errorf("no miner key under 1/3 of instance %d power", progress.ID)
+	}
+	slices.SortFunc(inst.byz, func(a, b f3Signer) int { return a.index - b.index })
+	return inst, nil
+}
+
+func (f *f3Instance) payload(phase gpbft.Phase, round uint64, value *gpbft.ECChain) gpbft.Payload {
+	return gpbft.Payload{
+		Instance:         f.progress.ID,
+		Round:            round,
+		Phase:            phase,
+		SupplementalData: f.supp,
+		Value:            value,
+	}
+}
+
+// message signs vote as s and encodes it as a complete PartialGMessage
+// (zero VoteValueKey).
+func (f *f3Instance) message(s f3Signer, vote gpbft.Payload, just *gpbft.Justification) ([]byte, error) {
+	sig, err := s.key.sign(vote.MarshalForSigning(f.nn))
+	if err != nil {
+		return nil, err
+	}
+	pgmsg := gpbft.PartialGMessage{GMessage: &gpbft.GMessage{
+		Sender:        s.key.id,
+		Vote:          vote,
+		Signature:     sig,
+		Justification: just,
+	}}
+	var buf bytes.Buffer
+	if err := pgmsg.MarshalCBOR(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// justify has every byzantine signer sign vote and aggregates the
+// signatures over the committee's keys, as a real justification would.
+func (f *f3Instance) justify(vote gpbft.Payload) (*gpbft.Justification, error) {
+	pubs := make([]gpbft.PubKey, len(f.table.Entries))
+	for i, e := range f.table.Entries {
+		pubs[i] = e.PubKey
+	}
+	agg, err := f3Verifier.Aggregate(pubs)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := vote.MarshalForSigning(f.nn)
+	var mask []int
+	var signers []uint64
+	var sigs [][]byte
+	for _, s := range f.byz {
+		sig, err := s.key.sign(msg)
+		if err != nil {
+			return nil, err
+		}
+		mask = append(mask, s.index)
+		signers = append(signers, uint64(s.index))
+		sigs = append(sigs, sig)
+	}
+	aggSig, err := agg.Aggregate(mask, sigs)
+	if err != nil {
+		return nil, err
+	}
+	return &gpbft.Justification{
+		Vote:      vote,
+		Signers:   bitfield.NewFromSet(signers),
+		Signature: aggSig,
+	}, nil
+}
+
+// conflictingValue returns a chain other than input that still passes
+// ECChain.Validate: a shorter prefix, or input plus a made-up tipset.
+func conflictingValue(input *gpbft.ECChain) *gpbft.ECChain {
+	if input.HasSuffix() && rngIntn(2) == 0 {
+		return input.Prefix(rngIntn(input.Len() - 1))
+	}
+	return input.Extend(randomCID().Bytes())
+}
+
+// signedF3Equivocation sends two validly signed votes from one sender for
+// different values at the same instance, round and phase. QUALITY and
+// PREPARE at round 0 need no justification, so both reach equivocation
+// handling.
+func signedF3Equivocation() {
+	f, err := fetchF3Instance()
+	if err != nil {
+		debugLog("[signed] F3 instance unavailable: %v", err)
+		return
+	}
+	s := rngChoice(f.byz)
+	phase := rngChoice([]gpbft.Phase{gpbft.QUALITY_PHASE, gpbft.PREPARE_PHASE})
+	values := []*gpbft.ECChain{f.progress.Input, conflictingValue(f.progress.Input)}
+	for _, v := range values {
+		data, err := f.message(s, f.payload(phase, 0, v), nil)
+		if err != nil {
+			debugLog("[signed] build %s vote failed: %v", phase, err)
+			return
+		}
+		publishF3(data)
+	}
+	log.Printf("[signed] %d conflicting %s votes from %d for instance %d", len(values), phase, s.key.id, f.progress.ID)
+
+	time.Sleep(signedSettle())
+	f.checkCertificates()
+}
+
+// signedJustCase pairs a vote with the vote its justification claims a
+// quorum for. value is the honest input and alt a conflicting chain.
+type signedJustCase struct {
+	name  string
+	votes func(f *f3Instance, value, alt *gpbft.ECChain) (vote, justified gpbft.Payload)
+}
+
+var signedJustCases = []signedJustCase{
+	{"commit-justified-by-quality", func(f *f3Instance, v, _ *gpbft.ECChain) (gpbft.Payload, gpbft.Payload) {
+		return f.payload(gpbft.COMMIT_PHASE, 0, v), f.payload(gpbft.QUALITY_PHASE, 0, v)
+	}},
+	{"commit-justified-by-next-round-prepare", func(f *f3Instance, v, _ *gpbft.ECChain) (gpbft.Payload, gpbft.Payload) {
+		return f.payload(gpbft.COMMIT_PHASE, 0, v), f.payload(gpbft.PREPARE_PHASE, 1, v)
+	}},
+	{"commit-justified-by-conflicting-prepare", func(f *f3Instance, v, alt *gpbft.ECChain) (gpbft.Payload, gpbft.Payload) {
+		return f.payload(gpbft.COMMIT_PHASE, 0, v), f.payload(gpbft.PREPARE_PHASE, 0, alt)
+	}},
+	{"commit-justified-by-foreign-supplemental-data", func(f *f3Instance, v, _ *gpbft.ECChain) (gpbft.Payload, gpbft.Payload) {
+		just := f.payload(gpbft.PREPARE_PHASE, 0, v)
+		just.SupplementalData.PowerTable = randomCID()
+		return f.payload(gpbft.COMMIT_PHASE, 0, v), just
+	}},
+	{"commit-justified-by-previous-instance", func(f *f3Instance, v, _ *gpbft.ECChain) (gpbft.Payload, gpbft.Payload) {
+		just := f.payload(gpbft.PREPARE_PHASE, 0, v)
+		just.Instance--
+		return f.payload(gpbft.COMMIT_PHASE, 0, v), just
+	}},
+	// Right shape, but signed by under a third of the power.
+	{"commit-justified-by-minority-prepare", func(f *f3Instance, v, _ *gpbft.ECChain) (gpbft.Payload, gpbft.Payload) {
+		return f.payload(gpbft.COMMIT_PHASE, 0, v), f.payload(gpbft.PREPARE_PHASE, 0, v)
+	}},
+	{"decide-justified-by-minority-commit", func(f *f3Instance, v, _ *gpbft.ECChain) (gpbft.Payload, gpbft.Payload) {
+		return f.payload(gpbft.DECIDE_PHASE, 0, v), f.payload(gpbft.COMMIT_PHASE, 0, v)
+	}},
+}
+
+// signedF3BadJustification sends a validly signed message whose
+// justification carries a valid aggregate signature from the byzantine set
+// but doesn't justify the message.
+func signedF3BadJustification(c signedJustCase) {
+	f, err := fetchF3Instance()
+	if err != nil {
+		debugLog("[signed] F3 instance unavailable: %v", err)
+		return
+	}
+	value := f.progress.Input
+	vote, justified := c.votes(f, value, conflictingValue(value))
+	just, err := f.justify(justified)
+	if err != nil {
+		debugLog("[signed] %s: justify failed: %v", c.name, err)
+		return
+	}
+	data, err := f.message(rngChoice(f.byz), vote, just)
+	if err != nil {
+		debugLog("[signed] %s: build message failed: %v", c.name, err)
+		return
+	}
+	log.Printf("[signed] %s for instance %d (%d signers)", c.name, f.progress.ID, len(f.byz))
+	publishF3(data)
+
+	time.Sleep(signedSettle())
+	f.checkCertificates()
+}
+
+// checkCertificates asserts every node with a finality certificate for the
+// attacked instance finalized the same chain.
+func (f *f3Instance) checkCertificates() {
+	var first *gpbft.ECChain
+	var firstNode string
+	for _, t := range targets {
+		var cert struct{ ECChain *gpbft.ECChain }
+		if err := callRPC(t.Name, "Filecoin.F3GetCertificate", []any{f.progress.ID}, &cert); err != nil {
+			debugLog("[signed] F3GetCertificate(%d) on %s failed: %v", f.progress.ID, t.Name, err)
+			continue
+		}
+		if cert.ECChain.IsZero() {
+			continue
+		}
+		if first == nil {
+			first, firstNode = cert.ECChain, t.Name
+			continue
+		}
+		assert.Always(first.Eq(cert.ECChain), "F3 finality certificates agree after signed adversary votes", map[string]any{
+			"instance": f.progress.ID,
+			"node_a":   firstNode,
+			"chain_a":  first.String(),
+			"node_b":   t.Name,
+			"chain_b":  cert.ECChain.String(),
+		})
+	}
+}
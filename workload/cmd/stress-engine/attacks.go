@@ -0,0 +1,761 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtintypes "github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v15/eam"
+	init15 "github.com/filecoin-project/go-state-types/builtin/v15/init"
+	miner15 "github.com/filecoin-project/go-state-types/builtin/v15/miner"
+	"github.com/filecoin-project/go-state-types/builtin/v15/multisig"
+	"github.com/filecoin-project/go-state-types/manifest"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ===========================================================================
+// Attack registry for the consensus test lifecycle
+//
+// Every attack pushes two conflicting messages, A to the honest side and B
+// to the adversary, while the network is partitioned. runConsensusCycle
+// only sees the Attack interface: it prepares the attack before
+// partitioning, injects it, and after the heal asks which of A and B took
+// effect on the honest node's chain. The count feeds the same
+// classifyExpected/verdict logic for every attack; economicImpact then
+// checks the resulting state for effects of more than one side.
+//
+// The three attackType transfers are registered alongside:
+//   - create2-conflict: same sender, nonce, salt and initcode, different
+//     value, so both deploy to the same EAM CREATE2 address
+//   - worker-change-conflict: an attack wallet that prepare made owner of a
+//     genesis miner sets a different worker and control addresses on each
+//     side
+//   - msig-approval-conflict: a 2-of-3 multisig with two pending proposals
+//     that together exceed half its balance; one signer approves a
+//     different proposal on each side with the same nonce
+//
+// STRESS_CONSENSUS_ATTACKS restricts the rotation to a comma-separated list
+// of attack names.
+// ===========================================================================
+
+// Attack is one adversarial strategy in the consensus test rotation.
+type Attack interface {
+	String() string
+	// prepare sets up on-chain state before the partition is created.
+	prepare(node api.FullNode) bool
+	// inject pushes the conflicting messages. nil means nothing to verify.
+	inject(honestName, advName string, advNode api.FullNode) *attackResult
+	// landed reports whether tx A and tx B took effect on refNode's chain.
+	landed(refNode api.FullNode, ar *attackResult) (bool, bool)
+	// economicImpact checks post-heal state against the landed outcome.
+	economicImpact(refNode api.FullNode, ar *attackResult, sr *splitResult, landed int)
+}
+
+var attackRegistry = []Attack{
+	attackDoubleSpend,
+	attackGasPremiumFrontrun,
+	attackBalanceExhaustion,
+	&create2Attack{},
+	&workerChangeAttack{},
+	&msigApprovalAttack{},
+}
+
+// activeAttacks returns the registry, filtered by STRESS_CONSENSUS_ATTACKS.
+func activeAttacks() []Attack {
+	filter := envOrDefault("STRESS_CONSENSUS_ATTACKS", "")
+	if filter == "" {
+		return attackRegistry
+	}
+	want := make(map[string]bool)
+	for _, n := range strings.Split(filter, ",") {
+		want[strings.TrimSpace(n)] = true
+	}
+	var out []Attack
+	for _, a := range attackRegistry {
+		if want[a.String()] {
+			out = append(out, a)
+		}
+	}
+	if len(out) == 0 {
+		log.Printf("[consensus-test] STRESS_CONSENSUS_ATTACKS=%q matches nothing, using all attacks", filter)
+		return attackRegistry
+	}
+	return out
+}
+
+// receiptsLanded reports whether tx A and tx B executed successfully on
+// refNode's chain.
+func receiptsLanded(refNode api.FullNode, ar *attackResult) (bool, bool) {
+	finalA, _ := refNode.StateSearchMsg(ctx, types.EmptyTSK, ar.cidA, 200, false)
+	finalB, _ := refNode.StateSearchMsg(ctx, types.EmptyTSK, ar.cidB, 200, false)
+
+	if finalA != nil && !finalA.Receipt.ExitCode.IsSuccess() {
+		log.Printf("[consensus-test]   tx A included but reverted (exit=%d)", finalA.Receipt.ExitCode)
+	}
+	if finalB != nil && !finalB.Receipt.ExitCode.IsSuccess() {
+		log.Printf("[consensus-test]   tx B included but reverted (exit=%d)", finalB.Receipt.ExitCode)
+	}
+	log.Printf("[consensus-test]   tx A (honest):    %s", fmtHeight(finalA))
+	log.Printf("[consensus-test]   tx B (adversary): %s", fmtHeight(finalB))
+
+	return finalA != nil && finalA.Receipt.ExitCode.IsSuccess(),
+		finalB != nil && finalB.Receipt.ExitCode.IsSuccess()
+}
+
+// ---------------------------------------------------------------------------
+// Transfers (attackType)
+// ---------------------------------------------------------------------------
+
+func (a attackType) prepare(api.FullNode) bool { return true }
+
+func (a attackType) inject(honestName, advName string, advNode api.FullNode) *attackResult {
+	return injectAttack(a, honestName, advName, advNode)
+}
+
+func (a attackType) landed(refNode api.FullNode, ar *attackResult) (bool, bool) {
+	return receiptsLanded(refNode, ar)
+}
+
+func (a attackType) economicImpact(refNode api.FullNode, ar *attackResult, sr *splitResult, landed int) {
+	verifyEconomicImpact(refNode, ar, sr, landed)
+}
+
+// ---------------------------------------------------------------------------
+// Conflicting CREATE2 deployments
+// ---------------------------------------------------------------------------
+
+// create2Initcode deploys a one-byte STOP contract. It has no constructor
+// value check, so deployments carrying value succeed.
+var create2Initcode = []byte{
+	0x60, 0x00, // PUSH1 0
+	0x60, 0x00, // PUSH1 0
+	0x53,       // MSTORE8 (mem[0] = 0x00, STOP)
+	0x60, 0x01, // PUSH1 1
+	0x60, 0x00, // PUSH1 0
+	0xf3, // RETURN mem[0:1]
+}
+
+type create2Attack struct {
+	target [20]byte           // predicted CREATE2 address
+	values [2]abi.TokenAmount // value carried by tx A and tx B
+}
+
+func (*create2Attack) String() string { return "create2-conflict" }
+
+func (*create2Attack) prepare(api.FullNode) bool { return true }
+
+func (a *create2Attack) inject(honestName, advName string, advNode api.FullNode) *attackResult {
+	fromAddr, fromKI := pickAttackWallet()
+	nonce := nonces[fromAddr]
+
+	id, err := nodes[honestName].StateLookupID(ctx, fromAddr, types.EmptyTSK)
+	if err != nil {
+		log.Printf("[consensus-test] StateLookupID(%s) failed: %v", fromAddr, err)
+		return nil
+	}
+	actorID, _ := address.IDFromAddress(id)
+
+	var salt [32]byte
+	for i := range salt {
+		salt[i] = byte(rngIntn(256))
+	}
+	a.target = create2Address(idMaskedEthAddr(actorID), salt, create2Initcode)
+	a.values = [2]abi.TokenAmount{abi.NewTokenAmount(1_000), abi.NewTokenAmount(2_000)}
+
+	params, err := actors.SerializeParams(&eam.Create2Params{Initcode: create2Initcode, Salt: salt})
+	if err != nil {
+		log.Printf("[consensus-test] SerializeParams failed: %v", err)
+		return nil
+	}
+	deploy := func(value abi.TokenAmount) *types.Message {
+		return &types.Message{
+			From:   fromAddr,
+			To:     builtintypes.EthereumAddressManagerActorAddr,
+			Value:  value,
+			Method: builtintypes.MethodsEAM.Create2,
+			Params: params,
+		}
+	}
+
+	msgA := deploy(a.values[0])
+	estimateGas(nodes[honestName], msgA, "test-create2-honest")
+	cidA, okA := pushMsgManualNonce(nodes[honestName], msgA, fromKI, nonce, "test-create2-honest")
+
+	msgB := deploy(a.values[1])
+	estimateGas(advNode, msgB, "test-create2-adv")
+	cidB, okB := pushMsgManualNonce(advNode, msgB, fromKI, nonce, "test-create2-adv")
+
+	if !okA || !okB {
+		log.Printf("[consensus-test] push failed (okA=%v okB=%v)", okA, okB)
+		return nil
+	}
+	nonces[fromAddr]++
+
+	log.Printf("[consensus-test] ATTACK: create2-conflict → 0x%x (salt=%x…)", a.target, salt[:4])
+	log.Printf("[consensus-test]   tx A (honest):    %s value=%s via %s", cidStr(cidA), a.values[0], honestName)
+	log.Printf("[consensus-test]   tx B (adversary): %s value=%s via %s", cidStr(cidB), a.values[1], advName)
+
+	return &attackResult{
+		attack:     a,
+		fromAddr:   fromAddr,
+		nonce:      nonce,
+		cidA:       cidA,
+		cidB:       cidB,
+		honestNode: honestName,
+		advNode:    advName,
+		amount:     a.values[1],
+	}
+}
+
+func (a *create2Attack) landed(refNode api.FullNode, ar *attackResult) (bool, bool) {
+	return receiptsLanded(refNode, ar)
+}
+
+// economicImpact checks the CREATE2 address holds exactly the deployment
+// that landed: its balance identifies which of the two it was.
+func (a *create2Attack) economicImpact(refNode api.FullNode, ar *attackResult, sr *splitResult, landed int) {
+	if landed > 1 {
+		return // already flagged by verifyOutcome
+	}
+	f410, err := address.NewDelegatedAddress(builtintypes.EthereumAddressManagerActorID, a.target[:])
+	if err != nil {
+		return
+	}
+	actor, err := refNode.StateGetActor(ctx, f410, types.EmptyTSK)
+	exists := err == nil && actor != nil
+
+	var want *abi.TokenAmount
+	switch {
+	case ar.aLanded:
+		want = &a.values[0]
+	case ar.bLanded:
+		want = &a.values[1]
+	}
+
+	ok := exists == (want != nil)
+	if ok && exists {
+		ok = actor.Balance.Equals(*want)
+	}
+	details := map[string]any{
+		"target":        fmt.Sprintf("0x%x", a.target),
+		"exists":        exists,
+		"a_landed":      ar.aLanded,
+		"b_landed":      ar.bLanded,
+		"f3_has_quorum": sr.f3HasQuorum,
+		"ec_vulnerable": sr.ecVulnerable,
+	}
+	if exists {
+		details["balance"] = actor.Balance.String()
+	}
+	assert.Always(ok, "CREATE2 address holds exactly the landed deployment", details)
+	if !ok {
+		log.Printf("[consensus-test] CREATE2 state mismatch at 0x%x: %v", a.target, details)
+	}
+}
+
+// idMaskedEthAddr is the EVM address EAM uses for a non-EVM caller.
+func idMaskedEthAddr(id uint64) [20]byte {
+	var out [20]byte
+	out[0] = 0xff
+	binary.BigEndian.PutUint64(out[12:], id)
+	return out
+}
+
+// create2Address computes keccak256(0xff ++ deployer ++ salt ++ keccak256(initcode))[12:].
+func create2Address(deployer [20]byte, salt [32]byte, initcode []byte) [20]byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(initcode)
+	codeHash := h.Sum(nil)
+
+	h = sha3.NewLegacyKeccak256()
+	h.Write([]byte{0xff})
+	h.Write(deployer[:])
+	h.Write(salt[:])
+	h.Write(codeHash)
+	var out [20]byte
+	copy(out[:], h.Sum(nil)[12:])
+	return out
+}
+
+// ---------------------------------------------------------------------------
+// Conflicting miner worker changes
+// ---------------------------------------------------------------------------
+
+// workerChangeAttack conflicts on a miner's owner. The genesis pre-seal key
+// is both owner and worker and signs the miner's WindowPoSt and sector
+// messages, so prepare first hands ownership of one miner to an attack
+// wallet and the conflicting pushes use that wallet's nonce instead.
+//
+// Each side sets different control addresses and a different NewWorker.
+// Control addresses take effect immediately; the worker change only becomes
+// pending and is never confirmed, so the miner keeps mining with its key.
+// The actor records a pending worker only when none is pending, so the
+// worker half is checked only on the first cycle against a miner.
+type workerChangeAttack struct {
+	miner       address.Address
+	owner       address.Address      // attack wallet owning miner after prepare
+	prev        []address.Address    // control addresses before the attack
+	prevWorker  address.Address      // pending worker before the attack (Undef if none)
+	ctl         [2][]address.Address // set by tx A and tx B (ID addresses)
+	worker      [2]address.Address   // NewWorker of tx A and tx B (ID addresses)
+	checkWorker bool                 // no worker change was pending before inject
+}
+
+func (*workerChangeAttack) String() string { return "worker-change-conflict" }
+
+// prepare makes an attack wallet the owner of a lotus-backed genesis miner,
+// once; later cycles reuse it. The nomination is pushed through the miner's
+// own node so the pre-seal key's nonce stays sequenced with the miner's
+// messages; the wallet then confirms from the keystore.
+func (a *workerChangeAttack) prepare(node api.FullNode) bool {
+	if a.owner != address.Undef {
+		if mi, err := node.StateMinerInfo(ctx, a.miner, types.EmptyTSK); err == nil {
+			if key, err := node.StateAccountKey(ctx, mi.Owner, types.EmptyTSK); err == nil && key == a.owner {
+				return true
+			}
+		}
+		log.Printf("[consensus-test] %s no longer owned by %s, handing over again", a.miner, a.owner)
+		a.miner, a.owner = address.Undef, address.Undef
+	}
+
+	var candidates []address.Address
+	for _, m := range getEligibleMiners(node) {
+		if minerToNodeName(m) != "" {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		log.Printf("[consensus-test] no lotus-owned miner for worker change")
+		return false
+	}
+	miner := rngChoice(candidates)
+	keyNode := nodes[minerToNodeName(miner)]
+
+	mi, err := node.StateMinerInfo(ctx, miner, types.EmptyTSK)
+	if err != nil {
+		log.Printf("[consensus-test] StateMinerInfo(%s) failed: %v", miner, err)
+		return false
+	}
+	preseal, err := node.StateAccountKey(ctx, mi.Owner, types.EmptyTSK)
+	if err != nil {
+		log.Printf("[consensus-test] StateAccountKey(%s) failed: %v", mi.Owner, err)
+		return false
+	}
+
+	owner, ownerKI := pickAttackWallet()
+	ownerID, err := node.StateLookupID(ctx, owner, types.EmptyTSK)
+	if err != nil {
+		log.Printf("[consensus-test] StateLookupID(%s) failed: %v", owner, err)
+		return false
+	}
+	params, err := actors.SerializeParams(&ownerID)
+	if err != nil {
+		return false
+	}
+
+	nominate, err := keyNode.MpoolPushMessage(ctx, &types.Message{
+		From:   preseal,
+		To:     miner,
+		Value:  abi.NewTokenAmount(0),
+		Method: builtintypes.MethodsMiner.ChangeOwnerAddress,
+		Params: params,
+	}, nil)
+	if err != nil {
+		log.Printf("[consensus-test] owner nomination on %s failed: %v", miner, err)
+		return false
+	}
+	if res := waitForMsg(node, nominate.Cid(), "test-worker-nominate"); res == nil || !res.Receipt.ExitCode.IsSuccess() {
+		log.Printf("[consensus-test] owner nomination on %s did not land", miner)
+		return false
+	}
+
+	confirm, ok := pushContractMsg(node, &types.Message{
+		From:   owner,
+		To:     miner,
+		Value:  abi.NewTokenAmount(0),
+		Method: builtintypes.MethodsMiner.ChangeOwnerAddress,
+		Params: params,
+	}, ownerKI, "test-worker-confirm")
+	if !ok {
+		return false
+	}
+	if res := waitForMsg(node, confirm, "test-worker-confirm"); res == nil || !res.Receipt.ExitCode.IsSuccess() {
+		log.Printf("[consensus-test] owner confirmation on %s did not land", miner)
+		return false
+	}
+
+	a.miner, a.owner = miner, owner
+	log.Printf("[consensus-test] %s now owned by %s (was %s)", miner, owner, preseal)
+	return true
+}
+
+func (a *workerChangeAttack) inject(honestName, advName string, advNode api.FullNode) *attackResult {
+	honest := nodes[honestName]
+
+	mi, err := honest.StateMinerInfo(ctx, a.miner, types.EmptyTSK)
+	if err != nil {
+		log.Printf("[consensus-test] StateMinerInfo(%s) failed: %v", a.miner, err)
+		return nil
+	}
+	a.prev = mi.ControlAddresses
+	a.prevWorker = mi.NewWorker
+	a.checkWorker = mi.NewWorker == address.Undef
+
+	// A worker must be a BLS account. The other genesis miners' workers are,
+	// and this miner's node holds neither key.
+	miners, err := honest.StateListMiners(ctx, types.EmptyTSK)
+	if err != nil {
+		log.Printf("[consensus-test] StateListMiners failed: %v", err)
+		return nil
+	}
+	var workers []address.Address
+	for _, m := range miners {
+		if m == a.miner {
+			continue
+		}
+		if other, err := honest.StateMinerInfo(ctx, m, types.EmptyTSK); err == nil && other.Worker != mi.Worker {
+			workers = append(workers, other.Worker)
+		}
+	}
+	if len(workers) < 2 {
+		log.Printf("[consensus-test] need 2 foreign workers for worker change, have %d", len(workers))
+		return nil
+	}
+	i := rngIntn(len(workers))
+	j := (i + 1 + rngIntn(len(workers)-1)) % len(workers)
+	a.worker = [2]address.Address{workers[i], workers[j]}
+
+	for i := range a.ctl {
+		w, _ := pickWallet()
+		id, err := honest.StateLookupID(ctx, w, types.EmptyTSK)
+		if err != nil {
+			log.Printf("[consensus-test] StateLookupID(%s) failed: %v", w, err)
+			return nil
+		}
+		a.ctl[i] = []address.Address{id}
+	}
+	if (!a.checkWorker || a.worker[0] == a.worker[1]) && a.ctl[0][0] == a.ctl[1][0] {
+		return nil // both sides would set the same state
+	}
+
+	ownerKI := keystore[a.owner]
+	nonce := nonces[a.owner]
+
+	push := func(node api.FullNode, side int, tag string) (*types.Message, bool) {
+		params, err := actors.SerializeParams(&miner15.ChangeWorkerAddressParams{
+			NewWorker:       a.worker[side],
+			NewControlAddrs: a.ctl[side],
+		})
+		if err != nil {
+			return nil, false
+		}
+		msg := &types.Message{
+			From:   a.owner,
+			To:     a.miner,
+			Value:  abi.NewTokenAmount(0),
+			Method: builtintypes.MethodsMiner.ChangeWorkerAddress,
+			Params: params,
+		}
+		estimateGas(node, msg, tag)
+		_, ok := pushMsgManualNonce(node, msg, ownerKI, nonce, tag)
+		return msg, ok
+	}
+
+	msgA, okA := push(honest, 0, "test-worker-honest")
+	msgB, okB := push(advNode, 1, "test-worker-adv")
+	assert.Sometimes(okA && okB, "Worker-change conflict pushes are accepted by both nodes", map[string]any{
+		"miner":   a.miner.String(),
+		"owner":   a.owner.String(),
+		"honest":  okA,
+		"adverse": okB,
+	})
+	if !okA || !okB {
+		log.Printf("[consensus-test] push failed (okA=%v okB=%v)", okA, okB)
+		return nil
+	}
+	nonces[a.owner]++
+
+	log.Printf("[consensus-test] ATTACK: worker-change-conflict on %s (owner=%s nonce=%d)", a.miner, a.owner, nonce)
+	log.Printf("[consensus-test]   tx A (honest):    %s worker=%s control=%v via %s", cidStr(msgA.Cid()), a.worker[0], a.ctl[0], honestName)
+	log.Printf("[consensus-test]   tx B (adversary): %s worker=%s control=%v via %s", cidStr(msgB.Cid()), a.worker[1], a.ctl[1], advName)
+
+	return &attackResult{
+		attack:     a,
+		fromAddr:   a.owner,
+		nonce:      nonce,
+		cidA:       msgA.Cid(),
+		cidB:       msgB.Cid(),
+		honestNode: honestName,
+		advNode:    advName,
+	}
+}
+
+func (a *workerChangeAttack) landed(refNode api.FullNode, ar *attackResult) (bool, bool) {
+	return receiptsLanded(refNode, ar)
+}
+
+// economicImpact checks the miner's control addresses and pending worker
+// are those of the tx that landed, or unchanged if neither did.
+func (a *workerChangeAttack) economicImpact(refNode api.FullNode, ar *attackResult, sr *splitResult, landed int) {
+	if landed > 1 {
+		return
+	}
+	mi, err := refNode.StateMinerInfo(ctx, a.miner, types.EmptyTSK)
+	if err != nil {
+		log.Printf("[consensus-test] StateMinerInfo(%s) failed: %v", a.miner, err)
+		return
+	}
+	want, wantWorker := a.prev, a.prevWorker
+	switch {
+	case ar.aLanded:
+		want = a.ctl[0]
+		if a.checkWorker {
+			wantWorker = a.worker[0]
+		}
+	case ar.bLanded:
+		want = a.ctl[1]
+		if a.checkWorker {
+			wantWorker = a.worker[1]
+		}
+	}
+	ok := fmt.Sprint(mi.ControlAddresses) == fmt.Sprint(want) && mi.NewWorker == wantWorker
+	details := map[string]any{
+		"miner":           a.miner.String(),
+		"control":         fmt.Sprint(mi.ControlAddresses),
+		"expected":        fmt.Sprint(want),
+		"pending_worker":  mi.NewWorker.String(),
+		"expected_worker": wantWorker.String(),
+		"a_landed":        ar.aLanded,
+		"b_landed":        ar.bLanded,
+		"f3_has_quorum":   sr.f3HasQuorum,
+		"ec_vulnerable":   sr.ecVulnerable,
+	}
+	assert.Always(ok, "Miner control addresses and pending worker match the landed worker change", details)
+	if !ok {
+		log.Printf("[consensus-test] worker change mismatch on %s: %v", a.miner, details)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Conflicting multisig approvals
+// ---------------------------------------------------------------------------
+
+const msigProposalAmount = 1_000_000_000_000 // attoFIL per proposal
+
+type msigApprovalAttack struct {
+	msig     address.Address
+	approver address.Address
+	txnIDs   [2]multisig.TxnID
+}
+
+func (*msigApprovalAttack) String() string { return "msig-approval-conflict" }
+
+// prepare creates a 2-of-3 multisig funded for both proposals and has the
+// first signer propose two payouts to different recipients.
+func (a *msigApprovalAttack) prepare(node api.FullNode) bool {
+	proposer, proposerKI := pickAttackWallet()
+	approver, _ := pickAttackWallet()
+	third, _ := pickWallet()
+	if proposer == approver || third == proposer || third == approver {
+		return false
+	}
+	a.approver = approver
+
+	nv, err := node.StateNetworkVersion(ctx, types.EmptyTSK)
+	if err != nil {
+		return false
+	}
+	codes, err := node.StateActorCodeCIDs(ctx, nv)
+	if err != nil {
+		log.Printf("[consensus-test] StateActorCodeCIDs(nv%d) failed: %v", nv, err)
+		return false
+	}
+	ctorParams, err := actors.SerializeParams(&multisig.ConstructorParams{
+		Signers:               []address.Address{proposer, approver, third},
+		NumApprovalsThreshold: 2,
+	})
+	if err != nil {
+		return false
+	}
+	execParams, err := actors.SerializeParams(&init15.ExecParams{
+		CodeCID:           codes[manifest.MultisigKey],
+		ConstructorParams: ctorParams,
+	})
+	if err != nil {
+		return false
+	}
+
+	createCid, ok := pushContractMsg(node, &types.Message{
+		From:   proposer,
+		To:     builtintypes.InitActorAddr,
+		Value:  abi.NewTokenAmount(2 * msigProposalAmount),
+		Method: builtintypes.MethodsInit.Exec,
+		Params: execParams,
+	}, proposerKI, "test-msig-create")
+	if !ok {
+		return false
+	}
+	res := waitForMsg(node, createCid, "test-msig-create")
+	if res == nil || !res.Receipt.ExitCode.IsSuccess() {
+		log.Printf("[consensus-test] multisig create did not land")
+		return false
+	}
+	var ret init15.ExecReturn
+	if err := ret.UnmarshalCBOR(bytes.NewReader(res.Receipt.Return)); err != nil {
+		log.Printf("[consensus-test] decode ExecReturn failed: %v", err)
+		return false
+	}
+	a.msig = ret.IDAddress
+
+	for i := range a.txnIDs {
+		to, _ := pickWallet()
+		params, err := actors.SerializeParams(&multisig.ProposeParams{
+			To:    to,
+			Value: abi.NewTokenAmount(msigProposalAmount),
+		})
+		if err != nil {
+			return false
+		}
+		c, ok := pushContractMsg(node, &types.Message{
+			From:   proposer,
+			To:     a.msig,
+			Value:  abi.NewTokenAmount(0),
+			Method: builtintypes.MethodsMultisig.Propose,
+			Params: params,
+		}, proposerKI, "test-msig-propose")
+		if !ok {
+			return false
+		}
+		res := waitForMsg(node, c, "test-msig-propose")
+		if res == nil || !res.Receipt.ExitCode.IsSuccess() {
+			log.Printf("[consensus-test] multisig propose %d did not land", i)
+			return false
+		}
+		var pr multisig.ProposeReturn
+		if err := pr.UnmarshalCBOR(bytes.NewReader(res.Receipt.Return)); err != nil {
+			log.Printf("[consensus-test] decode ProposeReturn failed: %v", err)
+			return false
+		}
+		a.txnIDs[i] = pr.TxnID
+	}
+
+	log.Printf("[consensus-test] multisig %s ready: proposals %v, approver %s", a.msig, a.txnIDs, approver)
+	return true
+}
+
+func (a *msigApprovalAttack) inject(honestName, advName string, advNode api.FullNode) *attackResult {
+	approverKI := keystore[a.approver]
+	nonce := nonces[a.approver]
+
+	var preBalance abi.TokenAmount
+	if actor, err := nodes[honestName].StateGetActor(ctx, a.msig, types.EmptyTSK); err == nil && actor != nil {
+		preBalance = actor.Balance
+	}
+
+	approve := func(node api.FullNode, id multisig.TxnID, tag string) (*types.Message, bool) {
+		params, err := actors.SerializeParams(&multisig.TxnIDParams{ID: id})
+		if err != nil {
+			return nil, false
+		}
+		msg := &types.Message{
+			From:   a.approver,
+			To:     a.msig,
+			Value:  abi.NewTokenAmount(0),
+			Method: builtintypes.MethodsMultisig.Approve,
+			Params: params,
+		}
+		estimateGas(node, msg, tag)
+		_, ok := pushMsgManualNonce(node, msg, approverKI, nonce, tag)
+		return msg, ok
+	}
+
+	msgA, okA := approve(nodes[honestName], a.txnIDs[0], "test-msig-honest")
+	msgB, okB := approve(advNode, a.txnIDs[1], "test-msig-adv")
+	if !okA || !okB {
+		log.Printf("[consensus-test] push failed (okA=%v okB=%v)", okA, okB)
+		return nil
+	}
+	nonces[a.approver]++
+
+	log.Printf("[consensus-test] ATTACK: msig-approval-conflict on %s (approver=%s nonce=%d)", a.msig, a.approver, nonce)
+	log.Printf("[consensus-test]   tx A (honest):    %s approves #%d via %s", cidStr(msgA.Cid()), a.txnIDs[0], honestName)
+	log.Printf("[consensus-test]   tx B (adversary): %s approves #%d via %s", cidStr(msgB.Cid()), a.txnIDs[1], advName)
+
+	return &attackResult{
+		attack:     a,
+		fromAddr:   a.approver,
+		nonce:      nonce,
+		cidA:       msgA.Cid(),
+		cidB:       msgB.Cid(),
+		honestNode: honestName,
+		advNode:    advName,
+		amount:     abi.NewTokenAmount(msigProposalAmount),
+		preBalance: preBalance,
+	}
+}
+
+// landed counts an approval only if it executed its proposal: an approve
+// message can succeed while the proposal's send fails.
+func (a *msigApprovalAttack) landed(refNode api.FullNode, ar *attackResult) (bool, bool) {
+	executed := func(tag string, lookup *api.MsgLookup) bool {
+		if lookup == nil || !lookup.Receipt.ExitCode.IsSuccess() {
+			return false
+		}
+		var ret multisig.ApproveReturn
+		if err := ret.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+			log.Printf("[consensus-test]   %s: decode ApproveReturn failed: %v", tag, err)
+			return false
+		}
+		log.Printf("[consensus-test]   %s: applied=%v code=%d %s", tag, ret.Applied, ret.Code, fmtHeight(lookup))
+		return ret.Applied && ret.Code.IsSuccess()
+	}
+	finalA, _ := refNode.StateSearchMsg(ctx, types.EmptyTSK, ar.cidA, 200, false)
+	finalB, _ := refNode.StateSearchMsg(ctx, types.EmptyTSK, ar.cidB, 200, false)
+	return executed("tx A (honest)", finalA), executed("tx B (adversary)", finalB)
+}
+
+// economicImpact bounds the multisig's payout by one proposal when the
+// partition should have been safe.
+func (a *msigApprovalAttack) economicImpact(refNode api.FullNode, ar *attackResult, sr *splitResult, landed int) {
+	if ar.preBalance.IsZero() {
+		return
+	}
+	actor, err := refNode.StateGetActor(ctx, a.msig, types.EmptyTSK)
+	if err != nil || actor == nil {
+		log.Printf("[consensus-test] cannot query multisig balance: %v", err)
+		return
+	}
+	drop := new(big.Int).Sub(ar.preBalance.Int, actor.Balance.Int)
+	details := map[string]any{
+		"msig":          a.msig.String(),
+		"pre_balance":   ar.preBalance.String(),
+		"post_balance":  actor.Balance.String(),
+		"balance_drop":  drop.String(),
+		"proposal":      ar.amount.String(),
+		"landed":        landed,
+		"f3_has_quorum": sr.f3HasQuorum,
+		"ec_vulnerable": sr.ecVulnerable,
+	}
+	if sr.f3HasQuorum || !sr.ecVulnerable {
+		safe := drop.Cmp(ar.amount.Int) <= 0
+		assert.Always(safe, "Multisig pays out at most one conflicting proposal", details)
+		if !safe {
+			log.Printf("[consensus-test] ECONOMIC VIOLATION: multisig %s paid %s, max %s", a.msig, drop, ar.amount)
+		}
+	} else if drop.Cmp(ar.amount.Int) > 0 {
+		log.Printf("[consensus-test] ECONOMIC CONFIRMATION: multisig %s paid both proposals", a.msig)
+		assert.Sometimes(true, "EC vulnerability: conflicting multisig approvals both paid out", details)
+	}
+	log.Printf("[consensus-test] multisig balance: pre=%s post=%s drop=%s", ar.preBalance, actor.Balance, drop)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/antithesishq/antithesis-sdk-go/lifecycle"
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ===========================================================================
+// Block propagation monitor — ChainNotify per node
+//
+// Everything else polls ChainHead, which says nothing about how quickly a
+// block reaches each node. This monitor holds one ChainNotify subscription
+// per node (re-subscribing when the websocket drops) and timestamps each
+// block CID the first time that node applies it. Delay is measured against
+// the block's own header timestamp, so it covers mining, gossip and
+// validation together.
+//
+// A checker goroutine settles each block once it is below the finalized
+// height and blockPropSettle has passed:
+//
+//   - delays feed per-node histograms, emitted via lifecycle.SendEvent
+//   - a node whose subscription was up the whole time but never applied the
+//     block is asked ChainHasObj (it may hold the block off its head chain)
+//   - a block on the canonical chain that some node does not hold at all
+//     raises an Always assertion
+//
+// Blocks first seen while a partition was active are dropped unjudged.
+// ===========================================================================
+
+const (
+	blockPropCheckInterval = 30 * time.Second
+	blockPropSettle        = 60 * time.Second
+	blockPropRetention     = 15 * time.Minute
+	blockPropResubscribe   = 5 * time.Second
+	blockPropEmitN         = 10 // emit histograms every N checks
+)
+
+type blockSighting struct {
+	height      abi.ChainEpoch
+	miner       address.Address
+	timestamp   time.Time
+	firstSeen   time.Time
+	seen        map[string]time.Duration // node -> delay after block timestamp
+	partitioned bool
+}
+
+var (
+	blockSightings   = make(map[cid.Cid]*blockSighting)
+	blockSightingsMu sync.Mutex
+
+	// blockSubsUp is when each node's current subscription was established;
+	// zero while it is down.
+	blockSubsUp = make(map[string]time.Time)
+
+	blockPropHists  = make(map[string]*latencyHist) // node -> delay histogram
+	blockPropChecks int
+)
+
+func startBlockPropMonitor() {
+	for _, name := range nodeKeys {
+		go blockPropSubscribe(name)
+	}
+	go func() {
+		log.Println("[block-prop] background goroutine started")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(blockPropCheckInterval):
+				blockPropCheck()
+			}
+		}
+	}()
+}
+
+// blockPropSubscribe keeps a ChainNotify subscription open on one node for
+// the lifetime of the engine.
+func blockPropSubscribe(name string) {
+	for ctx.Err() == nil {
+		ch, err := nodes[name].ChainNotify(ctx)
+		if err != nil {
+			debugLog("[block-prop] ChainNotify on %s failed: %v", name, err)
+			time.Sleep(blockPropResubscribe)
+			continue
+		}
+		blockSightingsMu.Lock()
+		blockSubsUp[name] = time.Now()
+		blockSightingsMu.Unlock()
+
+		for changes := range ch {
+			recordHeadChanges(name, changes)
+		}
+
+		blockSightingsMu.Lock()
+		blockSubsUp[name] = time.Time{}
+		blockSightingsMu.Unlock()
+		log.Printf("[block-prop] ChainNotify on %s closed, resubscribing", name)
+		time.Sleep(blockPropResubscribe)
+	}
+}
+
+// recordHeadChanges timestamps every block in applied tipsets. The initial
+// "current" notification is the head at subscribe time, not a fresh
+// arrival: it marks blocks seen without a delay sample.
+func recordHeadChanges(name string, changes []*api.HeadChange) {
+	now := time.Now()
+	partitioned := partitionActive.Load()
+	slack := blockPropFutureSlack()
+
+	blockSightingsMu.Lock()
+	defer blockSightingsMu.Unlock()
+	for _, hc := range changes {
+		if hc.Type != "apply" && hc.Type != "current" {
+			continue
+		}
+		for _, b := range hc.Val.Blocks() {
+			c := b.Cid()
+			s := blockSightings[c]
+			if s == nil {
+				s = &blockSighting{
+					height:    b.Height,
+					miner:     b.Miner,
+					timestamp: time.Unix(int64(b.Timestamp), 0),
+					firstSeen: now,
+					seen:      make(map[string]time.Duration),
+				}
+				blockSightings[c] = s
+			}
+			s.partitioned = s.partitioned || partitioned
+			if _, ok := s.seen[name]; ok {
+				continue
+			}
+			if hc.Type == "current" {
+				s.seen[name] = -1 // seen, no sample
+				continue
+			}
+			delay := now.Sub(s.timestamp)
+			s.seen[name] = delay
+
+			if delay < -slack {
+				assert.Always(false, "Nodes never apply blocks timestamped in the future", map[string]any{
+					"node":      name,
+					"block":     c.String(),
+					"height":    b.Height,
+					"miner":     b.Miner.String(),
+					"ahead_sec": -delay.Seconds(),
+					"slack_sec": slack.Seconds(),
+				})
+			}
+			h := blockPropHists[name]
+			if h == nil {
+				h = &latencyHist{}
+				blockPropHists[name] = h
+			}
+			h.add(delay, false)
+		}
+	}
+}
+
+// blockPropCheck settles sightings old enough to judge.
+func blockPropCheck() {
+	if partitionActive.Load() {
+		blockSightingsMu.Lock()
+		for _, s := range blockSightings {
+			s.partitioned = true
+		}
+		blockSightingsMu.Unlock()
+		return
+	}
+
+	finalizedHeight, _ := getFinalizedHeight()
+	if finalizedHeight < finalizedMinHeight {
+		return
+	}
+
+	// Settled sightings are removed from the map under the lock, so no
+	// other goroutine touches them afterwards.
+	type pending struct {
+		c    cid.Cid
+		s    *blockSighting
+		seen map[string]time.Duration
+	}
+	var due []pending
+	subsUp := make(map[string]time.Time)
+
+	blockSightingsMu.Lock()
+	for name, t := range blockSubsUp {
+		subsUp[name] = t
+	}
+	for c, s := range blockSightings {
+		age := time.Since(s.firstSeen)
+		switch {
+		case age > blockPropRetention || s.partitioned:
+			delete(blockSightings, c)
+		case s.height <= finalizedHeight && age > blockPropSettle:
+			due = append(due, pending{c: c, s: s, seen: s.seen})
+			delete(blockSightings, c)
+		}
+	}
+	blockPropChecks++
+	emit := blockPropChecks%blockPropEmitN == 0
+	hists := make(map[string]any, len(blockPropHists))
+	for k, h := range blockPropHists {
+		hists[k] = h.details()
+	}
+	blockSightingsMu.Unlock()
+
+	if len(due) == 0 {
+		return
+	}
+
+	target := blockPropTarget()
+	var slow, offHead, orphanMissing int
+	for _, p := range due {
+		for name, d := range p.seen {
+			if d > target {
+				slow++
+				debugLog("[block-prop] %s applied %s (h=%d) after %s", name, cidStr(p.c), p.s.height, d.Round(time.Millisecond))
+			}
+		}
+
+		// Only nodes whose subscription covered the whole window can be
+		// said to have missed the block.
+		var missing []string
+		for _, name := range nodeKeys {
+			if _, ok := p.seen[name]; ok {
+				continue
+			}
+			up := subsUp[name]
+			if up.IsZero() || up.After(p.s.firstSeen) {
+				continue
+			}
+			has, err := nodes[name].ChainHasObj(ctx, p.c)
+			if err != nil {
+				continue
+			}
+			if has {
+				offHead++
+				continue
+			}
+			missing = append(missing, name)
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		if !blockIsCanonical(p.c, p.s.height, p.seen) {
+			orphanMissing++
+			debugLog("[block-prop] orphan %s (h=%d) never reached %v", cidStr(p.c), p.s.height, missing)
+			continue
+		}
+
+		seenBy := make([]string, 0, len(p.seen))
+		for name := range p.seen {
+			seenBy = append(seenBy, name)
+		}
+		assert.Always(false, "Canonical blocks reach every node", map[string]any{
+			"block":      p.c.String(),
+			"height":     p.s.height,
+			"miner":      p.s.miner.String(),
+			"missing":    missing,
+			"seen_by":    seenBy,
+			"first_seen": p.s.firstSeen.Format(time.RFC3339),
+			"finalized":  finalizedHeight,
+		})
+		log.Printf("[block-prop] canonical block %s (h=%d, miner=%s) never reached %v",
+			cidStr(p.c), p.s.height, p.s.miner, missing)
+	}
+
+	assert.Sometimes(slow == 0, "Blocks reach every node within one block time", map[string]any{
+		"blocks":     len(due),
+		"slow":       slow,
+		"target_sec": target.Seconds(),
+	})
+	debugLog("[block-prop] settled %d blocks: slow=%d off_head=%d orphan_missing=%d",
+		len(due), slow, offHead, orphanMissing)
+
+	if emit {
+		lifecycle.SendEvent("block_propagation_delay", hists)
+		log.Printf("[block-prop] delay histograms after %d checks: %v", blockPropChecks, hists)
+	}
+}
+
+// blockIsCanonical reports whether some node that applied the block still
+// has it in its chain at that height.
+func blockIsCanonical(c cid.Cid, height abi.ChainEpoch, seen map[string]time.Duration) bool {
+	for name := range seen {
+		ts, err := nodes[name].ChainGetTipSetByHeight(ctx, height, types.EmptyTSK)
+		if err != nil || ts.Height() != height {
+			continue
+		}
+		for _, bc := range ts.Cids() {
+			if bc == c {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// blockPropFutureSlack is how far ahead of our clock a block may be before it
+// counts as "from the future": one block delay, which the miner and this
+// container's clocks may legitimately straddle, plus
+// STRESS_BLOCKPROP_CLOCK_DRIFT_SEC of tolerated skew between them.
+func blockPropFutureSlack() time.Duration {
+	drift := time.Duration(envInt("STRESS_BLOCKPROP_CLOCK_DRIFT_SEC", 5)) * time.Second
+	return blockPropTarget() + drift
+}
+
+var (
+	blockPropDelay     time.Duration
+	blockPropDelayOnce sync.Once
+)
+
+// blockPropTarget is the network block time, read once from the first node
+// that answers (6s fallback).
+func blockPropTarget() time.Duration {
+	blockPropDelayOnce.Do(func() {
+		blockPropDelay = 6 * time.Second
+		for _, name := range nodeKeys {
+			if params, err := nodes[name].StateGetNetworkParams(ctx); err == nil && params.BlockDelaySecs > 0 {
+				blockPropDelay = time.Duration(params.BlockDelaySecs) * time.Second
+				return
+			}
+		}
+	})
+	return blockPropDelay
+}
@@ -797,6 +797,10 @@ func forkMonitorTick() {
 		return
 	}
 
+	// Record heads into the fork graph first: partitions are exactly when
+	// the DAG is interesting.
+	recordForkGraph()
+
 	// Skip while a partition is intentionally active — forks are expected
 	// during n-split cycles and DoReorgChaos. We'll re-check once healed.
 	if partitionActive.Load() {
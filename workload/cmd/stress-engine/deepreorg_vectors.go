@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/antithesishq/antithesis-sdk-go/lifecycle"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+)
+
+// ===========================================================================
+// DoDeepReorg (Consensus Integrity — EC Finality Depth)
+//
+// DoReorgChaos forks a node for 1-3 epochs. This vector isolates the
+// smallest miner's node for long enough that healing forces a reorg at or
+// beyond ecFinalityDepth, the depth the engine otherwise treats as final.
+// It targets runs with LOTUS_F3_ENABLED=false, where EC finality is the
+// only protection; with F3 on it additionally checks that F3 holds.
+//
+// A monitor polls every node from before the split until convergence:
+//   - ChainGetFinalizedTipSet never goes backwards (lower height, or a
+//     different tipset at the same height)
+//   - eth_getBlockByNumber("finalized") never goes backwards, and its hash
+//     is the node's own tipset at that height
+//
+// After convergence each node's reorg depth is measured (last head before
+// the heal vs the common ancestor with its new chain) and reported via
+// lifecycle.SendEvent, and every node must still hold the last finalized
+// tipset it reported and the F3-finalized tipset from before the heal.
+//
+// STRESS_DEEP_REORG_MAX_EPOCHS bounds the isolation (default twice
+// ecFinalityDepth); each run picks a depth from half ecFinalityDepth up.
+// ===========================================================================
+
+const deepReorgPollInterval = 3 * time.Second
+
+var deepReorgMaxEpochs = envInt("STRESS_DEEP_REORG_MAX_EPOCHS", 2*int(ecFinalityDepth))
+
+// finalityMonitor tracks the finality each node has reported during one run.
+type finalityMonitor struct {
+	victim    string
+	finalized map[string]*types.TipSet // highest finalized tipset per node
+	ethFinal  map[string]uint64        // highest eth "finalized" block number per node
+}
+
+func DoDeepReorg() {
+	if partitionActive.Load() || len(nodeKeys) < 3 {
+		return
+	}
+	if focCfg != nil {
+		return // Curio cannot ride out a reorg this deep
+	}
+
+	victimName, victimPct := pickDeepReorgVictim()
+	if victimName == "" {
+		debugLog("[deep-reorg] no minority lotus miner to isolate")
+		return
+	}
+	victim := nodes[victimName]
+
+	minDepth := int(ecFinalityDepth) / 2
+	maxDepth := deepReorgMaxEpochs
+	if maxDepth <= minDepth {
+		maxDepth = minDepth + 1
+	}
+	depth := minDepth + rngIntn(maxDepth-minDepth+1)
+	f3Active := isF3Active()
+
+	startHead, err := victim.ChainHead(ctx)
+	if err != nil {
+		return
+	}
+
+	mon := &finalityMonitor{
+		victim:    victimName,
+		finalized: make(map[string]*types.TipSet),
+		ethFinal:  make(map[string]uint64),
+	}
+	mon.sample("baseline")
+
+	var others []string
+	for _, name := range nodeKeys {
+		if name != victimName {
+			others = append(others, name)
+		}
+	}
+	topo, err := parseTopology(victimName + " | " + strings.Join(others, ","))
+	if err != nil {
+		log.Printf("[deep-reorg] topology: %v", err)
+		return
+	}
+
+	log.Printf("[deep-reorg] isolating %s (%.1f%% power) for %d epochs (ecFinalityDepth=%d, f3=%v)",
+		victimName, victimPct, depth, ecFinalityDepth, f3Active)
+
+	partitionActive.Store(true)
+	at := applyTopology(topo)
+	defer exportForkGraph(fmt.Sprintf("deep-reorg-%s-%d", victimName, time.Now().Unix()), startHead.Height()-forkGraphExportMargin)
+	if !at.verified {
+		log.Printf("[deep-reorg] isolation of %s not enforced, healing", victimName)
+		healTopology(at)
+		partitionActive.Store(false)
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-time.After(deepReorgPollInterval):
+				mon.sample("partitioned")
+			}
+		}
+	}()
+
+	majority := nodes[others[0]]
+	target := startHead.Height() + abi.ChainEpoch(depth)
+	timeout := time.Duration(depth)*blockPropTarget()*3 + time.Minute
+	reached := waitForHeight(majority, target, timeout)
+
+	// F3-finalized tipset each node holds going into the heal.
+	f3Final := make(map[string]types.TipSetKey)
+	f3Epoch := make(map[string]abi.ChainEpoch)
+	if f3Active {
+		for _, name := range nodeKeys {
+			cert, err := nodes[name].F3GetLatestCertificate(ctx)
+			if err != nil || cert == nil || cert.ECChain.IsZero() {
+				continue
+			}
+			head := cert.ECChain.Head()
+			key, err := types.TipSetKeyFromBytes(head.Key)
+			if err != nil {
+				continue
+			}
+			f3Final[name] = key
+			f3Epoch[name] = abi.ChainEpoch(head.Epoch)
+		}
+	}
+
+	preHeal := make(map[string]*types.TipSet)
+	for _, name := range nodeKeys {
+		if ts, err := nodes[name].ChainHead(ctx); err == nil {
+			preHeal[name] = ts
+		}
+	}
+
+	log.Printf("[deep-reorg] healing %s after %d epochs (target reached=%v)", victimName, depth, reached)
+	healTopology(at)
+	converged := waitForConvergence(victimName)
+	close(stop)
+	<-done
+	mon.sample("healed")
+	partitionActive.Store(false)
+
+	if !converged {
+		log.Printf("[deep-reorg] nodes did not converge after healing %s", victimName)
+		return
+	}
+
+	// --- Reorg depth per node ---
+	depths := make(map[string]int64)
+	var victimDepth abi.ChainEpoch
+	for name, pre := range preHeal {
+		d, ok := reorgDepth(name, pre, abi.ChainEpoch(maxDepth)*2+ecFinalityDepth)
+		if !ok {
+			continue
+		}
+		depths[name] = int64(d)
+		if name == victimName {
+			victimDepth = d
+		}
+	}
+
+	// --- Finality held across the heal ---
+	for name, ts := range mon.finalized {
+		held, actual := onChainAt(name, ts.Height(), ts.Key())
+		details := map[string]any{
+			"node":      name,
+			"victim":    victimName,
+			"height":    ts.Height(),
+			"finalized": ts.Key().String(),
+			"actual":    actual,
+			"depth":     depths[name],
+			"f3_active": f3Active,
+		}
+		assert.Always(held, "No node reorgs below a tipset it reported as finalized", details)
+		if !held {
+			log.Printf("[deep-reorg] %s reorged below its finalized tipset at %d", name, ts.Height())
+		}
+	}
+	for name, key := range f3Final {
+		held, actual := onChainAt(name, f3Epoch[name], key)
+		details := map[string]any{
+			"node":         name,
+			"victim":       victimName,
+			"f3_epoch":     f3Epoch[name],
+			"f3_finalized": key.String(),
+			"actual":       actual,
+			"depth":        depths[name],
+		}
+		assert.Always(held, "No node reorgs below its F3-finalized tipset", details)
+		if !held {
+			log.Printf("[deep-reorg] %s reorged below F3-finalized epoch %d", name, f3Epoch[name])
+		}
+	}
+
+	details := map[string]any{
+		"victim":            victimName,
+		"victim_power_pct":  victimPct,
+		"isolation_epochs":  depth,
+		"target_reached":    reached,
+		"ec_finality_depth": ecFinalityDepth,
+		"victim_depth":      victimDepth,
+		"depths":            depths,
+		"f3_active":         f3Active,
+	}
+	assert.Sometimes(victimDepth >= ecFinalityDepth, "Deep reorg reached EC finality depth", details)
+	lifecycle.SendEvent("deep_reorg", details)
+	log.Printf("[deep-reorg] %s reorged %d epochs after %d isolated (depths=%v)", victimName, victimDepth, depth, depths)
+}
+
+// pickDeepReorgVictim returns the node of the smallest-power lotus miner,
+// provided it holds less than half the power.
+func pickDeepReorgVictim() (string, float64) {
+	lotusNode, _ := pickLotusNode()
+	if lotusNode == nil {
+		return "", 0
+	}
+	table := getF3PowerTable(lotusNode)
+	for i := len(table) - 1; i >= 0; i-- {
+		if name := minerToNodeName(table[i].addr); name != "" && table[i].pct < 50 {
+			return name, table[i].pct
+		}
+	}
+	return "", 0
+}
+
+// sample records each node's finalized tipset and eth "finalized" block,
+// asserting neither has gone backwards since the previous sample.
+func (m *finalityMonitor) sample(phase string) {
+	for _, name := range nodeKeys {
+		node := nodes[name]
+
+		if ts, err := node.ChainGetFinalizedTipSet(ctx); err == nil {
+			prev := m.finalized[name]
+			if prev != nil {
+				ok := ts.Height() > prev.Height() || (ts.Height() == prev.Height() && ts.Key() == prev.Key())
+				assert.Always(ok, "Finalized tipset never goes backwards", map[string]any{
+					"node":        name,
+					"victim":      m.victim,
+					"phase":       phase,
+					"prev_height": prev.Height(),
+					"prev_key":    prev.Key().String(),
+					"height":      ts.Height(),
+					"key":         ts.Key().String(),
+				})
+				if !ok {
+					log.Printf("[deep-reorg] %s finalized tipset went back: %d → %d (%s)", name, prev.Height(), ts.Height(), phase)
+				}
+			}
+			if prev == nil || ts.Height() > prev.Height() {
+				m.finalized[name] = ts
+			}
+		}
+
+		blk, err := node.EthGetBlockByNumber(ctx, "finalized", false)
+		if err != nil {
+			debugLog("[deep-reorg] eth_getBlockByNumber(finalized) on %s: %v", name, err)
+			continue
+		}
+		num := uint64(blk.Number)
+		if prev, ok := m.ethFinal[name]; ok {
+			assert.Always(num >= prev, "Eth finalized block never goes backwards", map[string]any{
+				"node":   name,
+				"victim": m.victim,
+				"phase":  phase,
+				"prev":   prev,
+				"number": num,
+			})
+		}
+		if num > m.ethFinal[name] {
+			m.ethFinal[name] = num
+		}
+
+		ts, err := node.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(num), types.EmptyTSK)
+		if err != nil || ts.Height() != abi.ChainEpoch(num) {
+			continue
+		}
+		kc, err := ts.Key().Cid()
+		if err != nil {
+			continue
+		}
+		want, err := ethtypes.EthHashFromCid(kc)
+		if err != nil {
+			continue
+		}
+		assert.Always(want == blk.Hash, "Eth finalized block is on the node's canonical chain", map[string]any{
+			"node":     name,
+			"victim":   m.victim,
+			"phase":    phase,
+			"number":   num,
+			"eth_hash": blk.Hash.String(),
+			"tipset":   want.String(),
+		})
+	}
+}
+
+// reorgDepth is how far below pre (a node's head before the heal) its
+// current chain diverges. Gives up after maxWalk epochs.
+func reorgDepth(name string, pre *types.TipSet, maxWalk abi.ChainEpoch) (abi.ChainEpoch, bool) {
+	node := nodes[name]
+	head, err := node.ChainHead(ctx)
+	if err != nil {
+		return 0, false
+	}
+	ts := pre
+	for ts.Height() > 0 && pre.Height()-ts.Height() <= maxWalk {
+		at, err := node.ChainGetTipSetByHeight(ctx, ts.Height(), head.Key())
+		if err != nil {
+			return 0, false
+		}
+		if at.Key() == ts.Key() {
+			return pre.Height() - ts.Height(), true
+		}
+		ts, err = node.ChainGetTipSet(ctx, ts.Parents())
+		if err != nil {
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// onChainAt reports whether the node's current chain has key at height,
+// and what it has there instead.
+func onChainAt(name string, height abi.ChainEpoch, key types.TipSetKey) (bool, string) {
+	ts, err := nodes[name].ChainGetTipSetByHeight(ctx, height, types.EmptyTSK)
+	if err != nil {
+		return true, "" // unknown, not a violation
+	}
+	return ts.Height() == height && ts.Key() == key, ts.Key().String()
+}
+
+// waitForHeight polls node until its head reaches target or timeout.
+func waitForHeight(node api.FullNode, target abi.ChainEpoch, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if head, err := node.ChainHead(ctx); err == nil && head.Height() >= target {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(deepReorgPollInterval):
+		}
+	}
+	return false
+}
@@ -1,13 +1,28 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"go.dedis.ch/kyber/v4"
+	"go.dedis.ch/kyber/v4/sign"
+	"go.dedis.ch/kyber/v4/sign/bdn"
 
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
 )
 
 // ===========================================================================
@@ -18,6 +33,11 @@ import (
 // entries are deterministic (drand round → BLS signature) so any mismatch
 // in finalized blocks indicates a consensus or drand integration bug.
 //
+// When the drand chain info is available, each node's entries are also
+// BLS-verified against the drand public key and checked against the round
+// schedule implied by the Filecoin and drand genesis times, so a forged or
+// mis-rounded entry that every node accepted still fails the audit.
+//
 // Covers issue #229 scenario 7 (beacon entry audit) and validates
 // lotus#11500 concern 1 (correctness of drand usage across implementations).
 // ===========================================================================
@@ -53,6 +73,8 @@ func DoDrandBeaconAudit() {
 	results := make(map[string]beaconResult) // nodeName -> result
 	var errs int
 
+	info := getDrandChainInfo()
+
 	for name, s := range snap {
 		if s.err != nil {
 			errs++
@@ -103,6 +125,10 @@ func DoDrandBeaconAudit() {
 			}
 		}
 
+		if info != nil {
+			auditBeaconSchedule(name, nodes[name], ts, info)
+		}
+
 		// All blocks in a tipset share the same beacon entries; use the first block.
 		be := blks[0].BeaconEntries
 		if len(be) == 0 {
@@ -171,3 +197,224 @@ func DoDrandBeaconAudit() {
 			checkHeight, sampleRound, sampleSig, responded)
 	}
 }
+
+// ===========================================================================
+// Drand chain info + independent beacon verification
+//
+// The chain info is the same JSON document the node start scripts fetch from
+// drand0/info and hand to Lotus (DRAND_CHAIN_INFO) and Forest. Loading it
+// here lets vectors BLS-verify beacon entries without trusting either node.
+// ===========================================================================
+
+const (
+	drandSchemeUnchainedG1 = "bls-unchained-g1-rfc9380" // devnet default (sig on G1, key on G2)
+	drandSchemeUnchainedG2 = "pedersen-bls-unchained"   // sig on G2, key on G1
+)
+
+// drandChainInfo is the subset of the drand /info response needed to verify
+// beacon entries.
+type drandChainInfo struct {
+	PublicKey   string `json:"public_key"`
+	Period      int64  `json:"period"`
+	GenesisTime int64  `json:"genesis_time"`
+	Hash        string `json:"hash"`
+	SchemeID    string `json:"schemeID"`
+
+	pubKey kyber.Point
+	scheme sign.Scheme
+}
+
+var (
+	drandInfo   *drandChainInfo
+	drandInfoMu sync.Mutex
+)
+
+// getDrandChainInfo returns the cached drand chain info, loading it on first
+// use. STRESS_DRAND_CHAIN_INFO may point at a chain_info file; otherwise it
+// is fetched from STRESS_DRAND_URL (default http://drand0). Returns nil if
+// the info is unavailable or uses an unsupported scheme; a later call retries.
+func getDrandChainInfo() *drandChainInfo {
+	drandInfoMu.Lock()
+	defer drandInfoMu.Unlock()
+
+	if drandInfo != nil {
+		return drandInfo
+	}
+
+	raw, err := readDrandChainInfo()
+	if err != nil {
+		debugLog("[drand-info] load failed: %v", err)
+		return nil
+	}
+
+	var info drandChainInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		log.Printf("[drand-info] bad chain info JSON: %v", err)
+		return nil
+	}
+
+	pub, err := hex.DecodeString(info.PublicKey)
+	if err != nil {
+		log.Printf("[drand-info] bad public key hex: %v", err)
+		return nil
+	}
+
+	switch info.SchemeID {
+	case drandSchemeUnchainedG1:
+		info.pubKey = blsSuite.G2().Point()
+		info.scheme = bdn.NewSchemeOnG1(blsSuite)
+	case drandSchemeUnchainedG2:
+		info.pubKey = blsSuite.G1().Point()
+		info.scheme = bdn.NewSchemeOnG2(blsSuite)
+	default:
+		log.Printf("[drand-info] unsupported drand scheme %q — beacon verification disabled", info.SchemeID)
+		return nil
+	}
+	if err := info.pubKey.UnmarshalBinary(pub); err != nil {
+		log.Printf("[drand-info] bad public key point: %v", err)
+		return nil
+	}
+	if info.Period <= 0 {
+		log.Printf("[drand-info] invalid period %d", info.Period)
+		return nil
+	}
+
+	log.Printf("[drand-info] loaded chain info: scheme=%s period=%ds genesis=%d hash=%s",
+		info.SchemeID, info.Period, info.GenesisTime, info.Hash[:min(16, len(info.Hash))])
+	drandInfo = &info
+	return drandInfo
+}
+
+func readDrandChainInfo() ([]byte, error) {
+	if path := os.Getenv("STRESS_DRAND_CHAIN_INFO"); path != "" {
+		return os.ReadFile(path)
+	}
+
+	url := strings.TrimSuffix(envOrDefault("STRESS_DRAND_URL", "http://drand0"), "/") + "/info"
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
+// verifyBeaconEntry BLS-verifies an unchained drand beacon entry: the
+// signature must be over sha256(big-endian round).
+func verifyBeaconEntry(info *drandChainInfo, e types.BeaconEntry) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], e.Round)
+	msg := sha256.Sum256(buf[:])
+	return info.scheme.Verify(info.pubKey, msg[:], e.Data)
+}
+
+// maxBeaconRoundForEpoch is the latest drand round a block at epoch e may
+// reference: the round published by the start of the previous epoch. Mirrors
+// Lotus DrandBeacon.MaxBeaconRoundForEpoch, computed independently from the
+// network params and drand chain info.
+func maxBeaconRoundForEpoch(info *drandChainInfo, nv network.Version, genesis, blockDelay uint64, e abi.ChainEpoch) uint64 {
+	latestTs := uint64(e)*blockDelay + genesis - blockDelay
+	drandGen := uint64(info.GenesisTime)
+	period := uint64(info.Period)
+
+	if nv <= network.Version15 {
+		return (latestTs - drandGen) / period
+	}
+	if latestTs < drandGen {
+		return 1
+	}
+	return (latestTs-drandGen)/period + 1
+}
+
+// auditBeaconSchedule checks ts's beacon entries against the drand schedule.
+// A block carries one entry per epoch since its parent, including catch-up
+// entries for null rounds, each at maxBeaconRoundForEpoch of that epoch — or
+// no entries if the latest round was already included by an ancestor. Every
+// entry must also BLS-verify for its round.
+func auditBeaconSchedule(name string, node api.FullNode, ts *types.TipSet, info *drandChainInfo) {
+	h := ts.Height()
+	if h == 0 {
+		return
+	}
+
+	params, err := node.StateGetNetworkParams(ctx)
+	if err != nil {
+		log.Printf("[drand-audit] StateGetNetworkParams failed on %s: %v", name, err)
+		return
+	}
+	nv, err := node.StateNetworkVersion(ctx, ts.Key())
+	if err != nil {
+		log.Printf("[drand-audit] StateNetworkVersion(%d) failed on %s: %v", h, name, err)
+		return
+	}
+	parent, err := node.ChainGetTipSet(ctx, ts.Parents())
+	if err != nil {
+		log.Printf("[drand-audit] ChainGetTipSet(parent of %d) failed on %s: %v", h, name, err)
+		return
+	}
+
+	genesis := params.GenesisTimestamp
+	delay := params.BlockDelaySecs
+	maxRound := maxBeaconRoundForEpoch(info, nv, genesis, delay, h)
+
+	var expected []uint64
+	prev := latestBeaconEntry(node, parent)
+	if prev == nil || prev.Round != maxRound {
+		for e := parent.Height() + 1; e <= h; e++ {
+			expected = append(expected, maxBeaconRoundForEpoch(info, nv, genesis, delay, e))
+		}
+	}
+
+	be := ts.Blocks()[0].BeaconEntries
+	got := make([]uint64, len(be))
+	for i, e := range be {
+		got[i] = e.Round
+	}
+
+	scheduleOK := len(got) == len(expected)
+	for i := 0; scheduleOK && i < len(got); i++ {
+		scheduleOK = got[i] == expected[i]
+	}
+
+	prevRound := uint64(0)
+	if prev != nil {
+		prevRound = prev.Round
+	}
+	assert.Always(scheduleOK, "Drand beacon rounds match the epoch schedule", map[string]any{
+		"node":            name,
+		"height":          h,
+		"parent_height":   parent.Height(),
+		"null_rounds":     int64(h - parent.Height() - 1),
+		"network_version": int(nv),
+		"prev_round":      prevRound,
+		"expected_rounds": expected,
+		"actual_rounds":   got,
+	})
+	if !scheduleOK {
+		log.Printf("[drand-audit] ROUND SCHEDULE MISMATCH on %s at height %d (parent %d): expected=%v got=%v",
+			name, h, parent.Height(), expected, got)
+	}
+
+	for _, e := range be {
+		err := verifyBeaconEntry(info, e)
+		assert.Always(err == nil, "Drand beacon entry signature verifies for its round", map[string]any{
+			"node":   name,
+			"height": h,
+			"round":  e.Round,
+			"scheme": info.SchemeID,
+			"error":  errStr(err),
+		})
+		if err != nil {
+			log.Printf("[drand-audit] BAD BEACON SIGNATURE on %s at height %d round=%d: %v", name, h, e.Round, err)
+		}
+	}
+
+	if scheduleOK && len(got) > 1 {
+		debugLog("[drand-audit] %s height=%d catch-up entries=%v after %d null rounds OK",
+			name, h, got, h-parent.Height()-1)
+	}
+}
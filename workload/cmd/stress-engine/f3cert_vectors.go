@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/filecoin-project/go-f3/certs"
+	"github.com/filecoin-project/go-f3/gpbft"
+	"go.dedis.ch/kyber/v4"
+	"go.dedis.ch/kyber/v4/sign/bdn"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ===========================================================================
+// DoF3CertChainAudit — Independent F3 finality certificate chain validation
+//
+// DoF3FinalityAgreement only checks that nodes return the same certificate
+// for an instance. This vector re-validates each node's certificate chain
+// from the manifest's initial instance onwards without trusting the node's
+// own F3 implementation:
+//
+//   - the aggregate BLS signature verifies against the power table the
+//     certificate was issued under, with a strong quorum of scaled power
+//   - applying the certificate's power-table delta yields the power table
+//     CID committed in its supplemental data
+//   - each finalized EC chain starts at the previous certificate's head
+//   - every certified head tipset is on every node's canonical chain
+//
+// Validation is incremental: each node's validated prefix (next instance,
+// power table, last head) is cached, and at most f3CertBatch certificates
+// are checked per node per run.
+// ===========================================================================
+
+const f3CertBatch = 25
+
+// f3CertChainState is the validated prefix of one node's certificate chain.
+type f3CertChainState struct {
+	network   gpbft.NetworkName
+	initialPT string
+	next      uint64
+	power     gpbft.PowerEntries
+	head      *gpbft.TipSet
+}
+
+var (
+	f3CertChains   = make(map[string]*f3CertChainState)
+	f3CertChainsMu sync.Mutex
+)
+
+func DoF3CertChainAudit() {
+	if len(nodeKeys) < 2 {
+		return
+	}
+	if !allNodesPastEpoch(f3MinEpoch) {
+		return
+	}
+
+	certified := make(map[uint64]*gpbft.TipSet) // instance -> certified head
+	initialPTs := make(map[string][]string)     // initial power table CID -> nodes
+
+	for _, name := range nodeKeys {
+		st := f3CertChainFor(name)
+		if st == nil {
+			continue
+		}
+		initialPTs[st.initialPT] = append(initialPTs[st.initialPT], name)
+
+		for inst, head := range validateCertBatch(name, nodes[name], st) {
+			certified[inst] = head
+		}
+	}
+
+	if len(initialPTs) > 0 {
+		assert.Always(len(initialPTs) == 1, "F3 initial power table identical across nodes", map[string]any{
+			"power_tables": initialPTs,
+		})
+		if len(initialPTs) > 1 {
+			log.Printf("[f3-certchain] INITIAL POWER TABLE MISMATCH: %v", initialPTs)
+		}
+	}
+
+	// Partitioned nodes legitimately lag behind certified heads; only check
+	// presence when the network is whole.
+	if partitionActive.Load() || len(certified) == 0 {
+		return
+	}
+
+	insts := make([]uint64, 0, len(certified))
+	for inst := range certified {
+		insts = append(insts, inst)
+	}
+	sort.Slice(insts, func(i, j int) bool { return insts[i] < insts[j] })
+	for _, inst := range insts {
+		checkCertifiedTipSetPresent(inst, certified[inst])
+	}
+}
+
+// f3CertChainFor returns name's cached chain state, bootstrapping it from the
+// manifest and initial power table on first use.
+func f3CertChainFor(name string) *f3CertChainState {
+	f3CertChainsMu.Lock()
+	st := f3CertChains[name]
+	f3CertChainsMu.Unlock()
+	if st != nil {
+		return st
+	}
+
+	node := nodes[name]
+	m, err := node.F3GetManifest(ctx)
+	if err != nil || m == nil {
+		debugLog("[f3-certchain] F3GetManifest failed on %s: %v", name, err)
+		return nil
+	}
+	pt, err := node.F3GetPowerTableByInstance(ctx, m.InitialInstance)
+	if err != nil || len(pt) == 0 {
+		debugLog("[f3-certchain] F3GetPowerTableByInstance(%d) failed on %s: %v", m.InitialInstance, name, err)
+		return nil
+	}
+	ptCID, err := certs.MakePowerTableCID(pt)
+	if err != nil {
+		log.Printf("[f3-certchain] MakePowerTableCID failed for %s: %v", name, err)
+		return nil
+	}
+
+	if m.InitialPowerTable.Defined() {
+		assert.Always(ptCID == m.InitialPowerTable, "F3 initial power table matches manifest CID", map[string]any{
+			"node":     name,
+			"instance": m.InitialInstance,
+			"manifest": m.InitialPowerTable.String(),
+			"actual":   ptCID.String(),
+		})
+	}
+
+	st = &f3CertChainState{
+		network:   m.NetworkName,
+		initialPT: ptCID.String(),
+		next:      m.InitialInstance,
+		power:     pt,
+	}
+	log.Printf("[f3-certchain] %s: network=%s initial_instance=%d initial_pt=%s entries=%d",
+		name, m.NetworkName, m.InitialInstance, ptCID, len(pt))
+
+	f3CertChainsMu.Lock()
+	f3CertChains[name] = st
+	f3CertChainsMu.Unlock()
+	return st
+}
+
+// validateCertBatch validates up to f3CertBatch certificates past st.next,
+// advancing st on success. Returns the head tipset of each newly validated
+// certificate keyed by instance. Stops at the first invalid certificate so
+// the failure is re-checked on the next run.
+func validateCertBatch(name string, node api.FullNode, st *f3CertChainState) map[uint64]*gpbft.TipSet {
+	latest, err := node.F3GetLatestCertificate(ctx)
+	if err != nil || latest == nil {
+		return nil
+	}
+
+	heads := make(map[uint64]*gpbft.TipSet)
+	for n := 0; n < f3CertBatch && st.next <= latest.GPBFTInstance; n++ {
+		inst := st.next
+		cert, err := node.F3GetCertificate(ctx, inst)
+		if err != nil || cert == nil {
+			debugLog("[f3-certchain] F3GetCertificate(%d) failed on %s: %v", inst, name, err)
+			return heads
+		}
+
+		details := map[string]any{
+			"node":      name,
+			"node_type": nodeType(name),
+			"instance":  inst,
+		}
+
+		wellFormed := cert.GPBFTInstance == inst && !cert.ECChain.IsZero() && cert.ECChain.Validate() == nil
+		assert.Always(wellFormed, "F3 certificate is well-formed for its instance", mergeDetails(details, map[string]any{
+			"cert_instance": cert.GPBFTInstance,
+		}))
+		if !wellFormed {
+			log.Printf("[f3-certchain] MALFORMED CERT on %s at instance %d (cert_instance=%d)", name, inst, cert.GPBFTInstance)
+			return heads
+		}
+
+		// --- EC chain extension ---
+		if st.head != nil {
+			extends := st.head.Equal(cert.ECChain.Base())
+			assert.Always(extends, "F3 certificate chain extends previous finalized head", mergeDetails(details, map[string]any{
+				"prev_head_epoch": st.head.Epoch,
+				"base_epoch":      cert.ECChain.Base().Epoch,
+			}))
+			if !extends {
+				log.Printf("[f3-certchain] CHAIN BREAK on %s at instance %d: prev head epoch %d, base epoch %d",
+					name, inst, st.head.Epoch, cert.ECChain.Base().Epoch)
+				return heads
+			}
+		}
+
+		// --- Aggregate signature against the current power table ---
+		err = verifyF3CertSignature(st.network, st.power, cert)
+		assert.Always(err == nil, "F3 certificate aggregate signature verifies against power table", mergeDetails(details, map[string]any{
+			"power_entries": len(st.power),
+			"error":         errStr(err),
+		}))
+		if err != nil {
+			log.Printf("[f3-certchain] BAD SIGNATURE on %s at instance %d: %v", name, inst, err)
+			return heads
+		}
+
+		// --- Power table delta ---
+		nextPT, err := certs.ApplyPowerTableDiffs(st.power, cert.PowerTableDelta)
+		var nextCID string
+		if err == nil {
+			c, cerr := certs.MakePowerTableCID(nextPT)
+			if cerr != nil {
+				err = cerr
+			} else if c != cert.SupplementalData.PowerTable {
+				err = fmt.Errorf("delta yields %s, certificate commits %s", c, cert.SupplementalData.PowerTable)
+			}
+			nextCID = c.String()
+		}
+		assert.Always(err == nil, "F3 power table delta yields committed power table", mergeDetails(details, map[string]any{
+			"delta_entries": len(cert.PowerTableDelta),
+			"committed":     cert.SupplementalData.PowerTable.String(),
+			"derived":       nextCID,
+			"error":         errStr(err),
+		}))
+		if err != nil {
+			log.Printf("[f3-certchain] BAD POWER DELTA on %s at instance %d: %v", name, inst, err)
+			return heads
+		}
+
+		st.next = inst + 1
+		st.power = nextPT
+		st.head = cert.ECChain.Head()
+		heads[inst] = st.head
+	}
+
+	if len(heads) > 0 {
+		debugLog("[f3-certchain] %s: validated %d certs, next=%d latest=%d",
+			name, len(heads), st.next, latest.GPBFTInstance)
+		assert.Sometimes(true, "F3 certificate chain validated independently", map[string]any{
+			"node":      name,
+			"validated": len(heads),
+			"next":      st.next,
+		})
+	}
+	return heads
+}
+
+// verifyF3CertSignature checks that cert's signers hold a strong quorum of
+// scaled power in pt and that the aggregate BDN signature verifies over the
+// DECIDE payload. Mirrors go-f3's certificate check using the workload's own
+// BLS suite.
+func verifyF3CertSignature(nn gpbft.NetworkName, pt gpbft.PowerEntries, cert *certs.FinalityCertificate) error {
+	scaled, total, err := pt.Scaled()
+	if err != nil {
+		return fmt.Errorf("scaling power table: %w", err)
+	}
+
+	pubs := make([]kyber.Point, len(pt))
+	for i, e := range pt {
+		p := blsSuite.G1().Point()
+		if err := p.UnmarshalBinary(e.PubKey); err != nil {
+			return fmt.Errorf("power entry %d (actor %d) pubkey: %w", i, e.ID, err)
+		}
+		pubs[i] = p
+	}
+	mask, err := bdn.NewMask(blsSuite.G1(), pubs, nil)
+	if err != nil {
+		return fmt.Errorf("building signer mask: %w", err)
+	}
+
+	var signed int64
+	if err := cert.Signers.ForEach(func(i uint64) error {
+		if i >= uint64(len(pt)) {
+			return fmt.Errorf("signer index %d out of range (%d entries)", i, len(pt))
+		}
+		if scaled[i] == 0 {
+			return fmt.Errorf("signer %d (actor %d) has no scaled power", i, pt[i].ID)
+		}
+		signed += scaled[i]
+		return mask.SetBit(int(i), true)
+	}); err != nil {
+		return err
+	}
+	if !gpbft.IsStrongQuorum(signed, total) {
+		return fmt.Errorf("insufficient signing power: %d of %d", signed, total)
+	}
+
+	payload := &gpbft.Payload{
+		Instance:         cert.GPBFTInstance,
+		Round:            0,
+		Phase:            gpbft.DECIDE_PHASE,
+		SupplementalData: cert.SupplementalData,
+		Value:            cert.ECChain,
+	}
+	scheme := bdn.NewSchemeOnG2(blsSuite)
+	agg, err := scheme.AggregatePublicKeys(mask)
+	if err != nil {
+		return fmt.Errorf("aggregating public keys: %w", err)
+	}
+	return scheme.Verify(agg, payload.MarshalForSigning(nn), cert.Signature)
+}
+
+// checkCertifiedTipSetPresent asserts that the tipset certified by instance
+// inst is on every node's canonical chain. Nodes whose head has not reached
+// the tipset's epoch yet are skipped.
+func checkCertifiedTipSetPresent(inst uint64, head *gpbft.TipSet) {
+	want, err := types.TipSetKeyFromBytes(head.Key)
+	if err != nil {
+		log.Printf("[f3-certchain] bad tipset key in cert %d: %v", inst, err)
+		return
+	}
+	epoch := abi.ChainEpoch(head.Epoch)
+
+	for _, name := range nodeKeys {
+		chainHead, err := nodes[name].ChainHead(ctx)
+		if err != nil || chainHead.Height() < epoch {
+			continue
+		}
+		ts, err := nodes[name].ChainGetTipSetByHeight(ctx, epoch, chainHead.Key())
+		if err != nil {
+			debugLog("[f3-certchain] ChainGetTipSetByHeight(%d) failed on %s: %v", epoch, name, err)
+			continue
+		}
+
+		present := ts.Height() == epoch && ts.Key() == want
+		assert.Always(present, "F3 certified tipset present on every node's chain", map[string]any{
+			"node":      name,
+			"node_type": nodeType(name),
+			"instance":  inst,
+			"epoch":     epoch,
+			"certified": want.String(),
+			"actual":    ts.Key().String(),
+		})
+		if !present {
+			log.Printf("[f3-certchain] CERTIFIED TIPSET MISSING on %s: instance %d epoch %d want %s got %s",
+				name, inst, epoch, want, ts.Key())
+		}
+	}
+}
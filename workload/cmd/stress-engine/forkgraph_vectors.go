@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/lifecycle"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ===========================================================================
+// Fork graph recorder
+//
+// trackedFork only remembers that nodes disagreed at one height. For reorg
+// and n-split outcomes we want the whole picture, so every fork monitor
+// tick (including during partitions) records each node's head into a
+// tipset DAG: key, parents, parent weight, blocks, which nodes have it on their
+// chain, and when each node held it as head. Unknown parents are fetched
+// from the node that reported the child, so polling gaps still connect.
+//
+// exportForkGraph writes the DAG above a given height to Graphviz DOT and
+// JSON in STRESS_FORK_GRAPH_DIR (docker-compose points it at the ./shared
+// mount); it runs at the end of every consensus cycle and reorg chaos run. Tipsets more than forkGraphKeepEpochs below
+// the highest one seen are pruned.
+// ===========================================================================
+
+const (
+	forkGraphWalkback     = 50  // max parents fetched per head per tick
+	forkGraphKeepEpochs   = 900 // retention behind the highest recorded tipset
+	forkGraphExportMargin = 5   // epochs of shared history included before a cycle
+)
+
+var forkGraphDir = envOrDefault("STRESS_FORK_GRAPH_DIR", "/tmp/fork-graphs")
+
+type forkGraphHeadSpan struct {
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+type forkGraphTipset struct {
+	Key     string                        `json:"key"`
+	Height  abi.ChainEpoch                `json:"height"`
+	Parents string                        `json:"parents"`
+	Weight  string                        `json:"parent_weight"`
+	Blocks  []string                      `json:"blocks"`
+	Miners  []string                      `json:"miners"`
+	SeenBy  map[string]bool               `json:"seen_by"`
+	Heads   map[string]*forkGraphHeadSpan `json:"heads,omitempty"`
+}
+
+var (
+	forkGraph      = make(map[string]*forkGraphTipset) // tipset key -> node
+	forkGraphMu    sync.Mutex
+	forkGraphMaxH  abi.ChainEpoch
+	forkGraphHeads = make(map[string]string) // node -> last recorded head key
+)
+
+// recordForkGraph adds every node's current head (and any unrecorded
+// ancestors) to the DAG.
+func recordForkGraph() {
+	now := time.Now()
+	for _, name := range nodeKeys {
+		head, err := nodes[name].ChainHead(ctx)
+		if err != nil {
+			continue
+		}
+
+		forkGraphMu.Lock()
+		t := forkGraphAdd(head, name)
+		span := t.Heads[name]
+		if span == nil {
+			span = &forkGraphHeadSpan{First: now}
+			t.Heads[name] = span
+		}
+		span.Last = now
+		forkGraphHeads[name] = t.Key
+		forkGraphMu.Unlock()
+
+		// Fill in ancestors this node has not yet been seen holding.
+		ts := head
+		for i := 0; i < forkGraphWalkback && ts.Height() > 0; i++ {
+			forkGraphMu.Lock()
+			p, known := forkGraph[ts.Parents().String()]
+			done := known && p.SeenBy[name]
+			forkGraphMu.Unlock()
+			if done {
+				break
+			}
+			parent, err := nodes[name].ChainGetTipSet(ctx, ts.Parents())
+			if err != nil {
+				break
+			}
+			forkGraphMu.Lock()
+			forkGraphAdd(parent, name)
+			forkGraphMu.Unlock()
+			ts = parent
+		}
+	}
+
+	forkGraphMu.Lock()
+	for k, t := range forkGraph {
+		if t.Height < forkGraphMaxH-forkGraphKeepEpochs {
+			delete(forkGraph, k)
+		}
+	}
+	forkGraphMu.Unlock()
+}
+
+// forkGraphAdd records ts as seen by node. Caller holds forkGraphMu.
+func forkGraphAdd(ts *types.TipSet, node string) *forkGraphTipset {
+	key := ts.Key().String()
+	t := forkGraph[key]
+	if t == nil {
+		t = &forkGraphTipset{
+			Key:     key,
+			Height:  ts.Height(),
+			Parents: ts.Parents().String(),
+			Weight:  ts.ParentWeight().String(),
+			SeenBy:  make(map[string]bool),
+			Heads:   make(map[string]*forkGraphHeadSpan),
+		}
+		for _, b := range ts.Blocks() {
+			t.Blocks = append(t.Blocks, b.Cid().String())
+			t.Miners = append(t.Miners, b.Miner.String())
+		}
+		forkGraph[key] = t
+		if ts.Height() > forkGraphMaxH {
+			forkGraphMaxH = ts.Height()
+		}
+	}
+	t.SeenBy[node] = true
+	return t
+}
+
+// exportForkGraph writes the recorded DAG from fromHeight upwards as
+// <label>.dot and <label>.json, and reports the paths via SendEvent.
+func exportForkGraph(label string, fromHeight abi.ChainEpoch) {
+	forkGraphMu.Lock()
+	var tipsets []forkGraphTipset
+	for _, t := range forkGraph {
+		if t.Height >= fromHeight {
+			c := *t
+			c.SeenBy = make(map[string]bool, len(t.SeenBy))
+			for k, v := range t.SeenBy {
+				c.SeenBy[k] = v
+			}
+			c.Heads = make(map[string]*forkGraphHeadSpan, len(t.Heads))
+			for k, v := range t.Heads {
+				span := *v
+				c.Heads[k] = &span
+			}
+			tipsets = append(tipsets, c)
+		}
+	}
+	heads := make(map[string]string, len(forkGraphHeads))
+	for k, v := range forkGraphHeads {
+		heads[k] = v
+	}
+	forkGraphMu.Unlock()
+
+	if len(tipsets) == 0 {
+		return
+	}
+	sort.Slice(tipsets, func(i, j int) bool {
+		if tipsets[i].Height != tipsets[j].Height {
+			return tipsets[i].Height < tipsets[j].Height
+		}
+		return tipsets[i].Key < tipsets[j].Key
+	})
+
+	forked := 0
+	for _, t := range tipsets {
+		if len(t.SeenBy) < len(nodeKeys) {
+			forked++
+		}
+	}
+
+	jsonBytes, err := json.MarshalIndent(map[string]any{
+		"label":       label,
+		"exported_at": time.Now().UTC(),
+		"from_height": fromHeight,
+		"nodes":       nodeKeys,
+		"heads":       heads,
+		"tipsets":     tipsets,
+	}, "", "  ")
+	if err != nil {
+		log.Printf("[fork-graph] marshal failed: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(forkGraphDir, 0o755); err != nil {
+		log.Printf("[fork-graph] cannot create %s: %v", forkGraphDir, err)
+		return
+	}
+	jsonPath := filepath.Join(forkGraphDir, label+".json")
+	dotPath := filepath.Join(forkGraphDir, label+".dot")
+	if err := os.WriteFile(jsonPath, jsonBytes, 0o644); err != nil {
+		log.Printf("[fork-graph] write %s failed: %v", jsonPath, err)
+		return
+	}
+	if err := os.WriteFile(dotPath, []byte(forkGraphDOT(label, tipsets, heads)), 0o644); err != nil {
+		log.Printf("[fork-graph] write %s failed: %v", dotPath, err)
+		return
+	}
+
+	lifecycle.SendEvent("fork_graph_export", map[string]any{
+		"label":       label,
+		"from_height": fromHeight,
+		"tipsets":     len(tipsets),
+		"forked":      forked,
+		"dot":         dotPath,
+		"json":        jsonPath,
+	})
+	log.Printf("[fork-graph] %s: %d tipsets (%d not on every node) from height %d → %s, %s",
+		label, len(tipsets), forked, fromHeight, dotPath, jsonPath)
+}
+
+// forkGraphDOT renders tipsets as a parent→child digraph. Tipsets held by
+// every node are white, fork branches are red, and each node's last
+// recorded head is listed on its tipset.
+func forkGraphDOT(label string, tipsets []forkGraphTipset, heads map[string]string) string {
+	headsAt := make(map[string][]string)
+	for node, key := range heads {
+		headsAt[key] = append(headsAt[key], node)
+	}
+
+	ids := make(map[string]string, len(tipsets))
+	for i, t := range tipsets {
+		ids[t.Key] = fmt.Sprintf("t%d", i)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "digraph %q {\n", label)
+	sb.WriteString("  rankdir=BT;\n  node [shape=box, style=filled, fontname=monospace, fontsize=10];\n")
+	for _, t := range tipsets {
+		seen := make([]string, 0, len(t.SeenBy))
+		for n := range t.SeenBy {
+			seen = append(seen, n)
+		}
+		sort.Strings(seen)
+		lbl := fmt.Sprintf("h=%d  pw=%s\\n%s\\nminers: %s\\nseen: %s",
+			t.Height, t.Weight, cidSuffixes(t.Blocks), strings.Join(t.Miners, ","), strings.Join(seen, ","))
+		if hs := headsAt[t.Key]; len(hs) > 0 {
+			sort.Strings(hs)
+			lbl += "\\nHEAD: " + strings.Join(hs, ",")
+		}
+		color := "white"
+		if len(t.SeenBy) < len(nodeKeys) {
+			color = "lightcoral"
+		}
+		fmt.Fprintf(&sb, "  %s [label=\"%s\", fillcolor=%s];\n", ids[t.Key], lbl, color)
+	}
+	for _, t := range tipsets {
+		if pid, ok := ids[t.Parents]; ok {
+			fmt.Fprintf(&sb, "  %s -> %s;\n", pid, ids[t.Key])
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// cidSuffixes shortens a tipset's block CIDs for graph labels.
+func cidSuffixes(blocks []string) string {
+	out := make([]string, len(blocks))
+	for i, b := range blocks {
+		if len(b) > 16 {
+			b = b[len(b)-8:]
+		}
+		out[i] = b
+	}
+	return strings.Join(out, ",")
+}
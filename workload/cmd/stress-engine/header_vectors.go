@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"math/big"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/ipfs/go-cid"
+	"go.dedis.ch/kyber/v4/pairing/bls12381/kilic"
+	"go.dedis.ch/kyber/v4/sign/bdn"
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build/buildconstants"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ===========================================================================
+// DoBlockHeaderAudit — Independent block header invariant checks
+//
+// Cross-node comparison only catches divergence: if Lotus and Forest both
+// accept the same bad block, every agreement vector still passes. This
+// vector re-derives header invariants from first principles for a short
+// walk of finalized tipsets on every node:
+//
+//   - Timestamp == genesis timestamp + height × block delay
+//   - ParentWeight == EC weight function applied to the parent tipset
+//   - Parents == key of the prior non-null tipset
+//   - ParentStateRoot, ParentMessageReceipts and Messages resolve locally
+//   - ElectionProof.WinCount matches the miner's lookback power
+//   - Ticket and ElectionProof VRFs verify against the miner worker BLS key
+//   - Beacon entries verify against the drand chain public key
+//
+// The weight, win count and VRF derivations mirror filcns.ValidateBlock but
+// use only RPC-visible inputs and a separate BLS implementation (kyber).
+// ===========================================================================
+
+const (
+	headerAuditWalk     = 3                  // consecutive tipsets audited per node per invocation
+	headerAuditMinEpoch = abi.ChainEpoch(10) // skip genesis-adjacent epochs (no beacon history yet)
+	beaconSearchDepth   = 20                 // same bound as ChainStore.GetLatestBeaconEntry
+)
+
+// blsSuite is the BLS12-381 suite used for independent signature checks.
+// Its default hash-to-curve DSTs match both Filecoin BLS keys (signatures on
+// G2) and drand's RFC 9380 G1 scheme.
+var blsSuite = kilic.NewBLS12381Suite()
+
+func DoBlockHeaderAudit() {
+	if partitionActive.Load() {
+		return
+	}
+	if !allNodesPastEpoch(f3MinEpoch) {
+		return
+	}
+
+	snap := getFinalizedSnapshots()
+	finalizedHeight, anchorKey := snapshotMinHeight(snap)
+	if finalizedHeight < headerAuditMinEpoch+headerAuditWalk {
+		return
+	}
+
+	// Pick a random finalized start height; the walk goes backwards from it.
+	lo := headerAuditMinEpoch + headerAuditWalk
+	start := lo + abi.ChainEpoch(rngIntn(int(finalizedHeight-lo)+1))
+	drand := getDrandChainInfo()
+
+	for _, name := range nodeKeys {
+		if s, ok := snap[name]; !ok || s.err != nil {
+			continue
+		}
+		auditNodeHeaders(name, start, anchorKey, drand)
+	}
+}
+
+// auditNodeHeaders walks headerAuditWalk tipsets back from start on one node.
+func auditNodeHeaders(name string, start abi.ChainEpoch, anchor types.TipSetKey, drand *drandChainInfo) {
+	node := nodes[name]
+
+	params, err := node.StateGetNetworkParams(ctx)
+	if err != nil {
+		log.Printf("[header-audit] StateGetNetworkParams failed on %s: %v", name, err)
+		return
+	}
+
+	ts, err := node.ChainGetTipSetByHeight(ctx, start, anchor)
+	if err != nil {
+		log.Printf("[header-audit] ChainGetTipSetByHeight(%d) failed on %s: %v", start, name, err)
+		return
+	}
+
+	audited := 0
+	for i := 0; i < headerAuditWalk && ts.Height() > headerAuditMinEpoch; i++ {
+		parent, err := node.ChainGetTipSet(ctx, ts.Parents())
+		if err != nil {
+			log.Printf("[header-audit] parent of %d not loadable on %s: %v", ts.Height(), name, err)
+			return
+		}
+		auditTipSetHeaders(name, node, params, ts, parent, drand)
+		audited += len(ts.Blocks())
+		ts = parent
+	}
+
+	debugLog("[header-audit] %s: audited %d blocks from height %d", name, audited, start)
+}
+
+func auditTipSetHeaders(name string, node api.FullNode, params *api.NetworkParams, ts, parent *types.TipSet, drand *drandChainInfo) {
+	h := ts.Height()
+	base := map[string]any{
+		"node":   name,
+		"height": h,
+		"tipset": ts.Key().String(),
+	}
+
+	// --- Parents == prior non-null tipset on this node's chain ---
+	if prior, err := node.ChainGetTipSetByHeight(ctx, h-1, ts.Key()); err == nil {
+		assert.Always(prior.Key() == ts.Parents() && parent.Height() < h,
+			"Block Parents match the prior tipset key",
+			mergeDetails(base, map[string]any{
+				"parents":       ts.Parents().String(),
+				"prior":         prior.Key().String(),
+				"parent_height": parent.Height(),
+			}))
+	}
+
+	// --- ParentWeight == weight(parent) ---
+	if want, ok := expectedParentWeight(node, parent); ok {
+		got := ts.ParentWeight()
+		match := got.Equals(want)
+		assert.Always(match, "Block parent weight matches EC weight of parent tipset",
+			mergeDetails(base, map[string]any{
+				"header_weight":   got.String(),
+				"computed_weight": want.String(),
+			}))
+		if !match {
+			log.Printf("[header-audit] WEIGHT MISMATCH on %s at %d: header=%s computed=%s",
+				name, h, got, want)
+		}
+	}
+
+	lbKey, lbOK := lookbackKey(node, ts, parent)
+	prevBeacon := latestBeaconEntry(node, parent)
+
+	for _, b := range ts.Blocks() {
+		bd := mergeDetails(base, map[string]any{"miner": b.Miner.String()})
+
+		// --- Timestamp ---
+		wantTs := params.GenesisTimestamp + uint64(b.Height)*params.BlockDelaySecs
+		assert.Always(b.Timestamp == wantTs, "Block timestamp equals genesis + height × block delay",
+			mergeDetails(bd, map[string]any{
+				"timestamp": b.Timestamp,
+				"expected":  wantTs,
+				"genesis":   params.GenesisTimestamp,
+				"delay":     params.BlockDelaySecs,
+			}))
+
+		// --- Roots resolvable ---
+		roots := []struct {
+			field string
+			c     cid.Cid
+		}{
+			{"ParentStateRoot", b.ParentStateRoot},
+			{"ParentMessageReceipts", b.ParentMessageReceipts},
+			{"Messages", b.Messages},
+		}
+		for _, r := range roots {
+			has, err := node.ChainHasObj(ctx, r.c)
+			if err != nil {
+				continue
+			}
+			assert.Always(has, "Block header roots resolvable in node blockstore",
+				mergeDetails(bd, map[string]any{"field": r.field, "cid": r.c.String()}))
+		}
+
+		// Everything below needs the lookback state and worker key.
+		if !lbOK || b.ElectionProof == nil || b.Ticket == nil {
+			continue
+		}
+
+		// --- Win count vs lookback power ---
+		pow, err := node.StateMinerPower(ctx, b.Miner, lbKey)
+		if err == nil {
+			j := b.ElectionProof.ComputeWinCount(pow.MinerPower.QualityAdjPower, pow.TotalPower.QualityAdjPower)
+			ok := b.ElectionProof.WinCount >= 1 && b.ElectionProof.WinCount == j
+			assert.Always(ok, "ElectionProof win count consistent with miner power",
+				mergeDetails(bd, map[string]any{
+					"win_count":   b.ElectionProof.WinCount,
+					"computed":    j,
+					"miner_power": pow.MinerPower.QualityAdjPower.String(),
+					"total_power": pow.TotalPower.QualityAdjPower.String(),
+				}))
+			if !ok {
+				log.Printf("[header-audit] WIN COUNT MISMATCH on %s at %d miner=%s: header=%d computed=%d",
+					name, h, b.Miner, b.ElectionProof.WinCount, j)
+			}
+		}
+
+		// --- VRFs against worker key ---
+		rBeacon := prevBeacon
+		if len(b.BeaconEntries) > 0 {
+			rBeacon = &b.BeaconEntries[len(b.BeaconEntries)-1]
+		}
+		worker, ok := workerBLSKey(node, b.Miner, lbKey)
+		if ok && rBeacon != nil {
+			minerBuf := new(bytes.Buffer)
+			if err := b.Miner.MarshalCBOR(minerBuf); err == nil {
+				eBase := drawRandomness(rBeacon.Data, crypto.DomainSeparationTag_ElectionProofProduction, b.Height, minerBuf.Bytes())
+				err := verifyFilecoinBLS(worker, eBase, b.ElectionProof.VRFProof)
+				assert.Always(err == nil, "ElectionProof VRF verifies against miner worker key",
+					mergeDetails(bd, map[string]any{"error": errStr(err), "beacon_round": rBeacon.Round}))
+
+				tEntropy := append([]byte{}, minerBuf.Bytes()...)
+				if mt := parent.MinTicket(); mt != nil && b.Height > params.ForkUpgradeParams.UpgradeSmokeHeight {
+					tEntropy = append(tEntropy, mt.VRFProof...)
+				}
+				tBase := drawRandomness(rBeacon.Data, crypto.DomainSeparationTag_TicketProduction,
+					b.Height-buildconstants.TicketRandomnessLookback, tEntropy)
+				err = verifyFilecoinBLS(worker, tBase, b.Ticket.VRFProof)
+				assert.Always(err == nil, "Ticket VRF verifies against miner worker key",
+					mergeDetails(bd, map[string]any{"error": errStr(err), "beacon_round": rBeacon.Round}))
+			}
+		}
+
+		// --- Beacon entries against drand public key ---
+		if drand != nil {
+			for _, e := range b.BeaconEntries {
+				err := verifyBeaconEntry(drand, e)
+				assert.Always(err == nil, "Block beacon entries verify against drand public key",
+					mergeDetails(bd, map[string]any{"round": e.Round, "error": errStr(err)}))
+				if err != nil {
+					log.Printf("[header-audit] BAD BEACON on %s at %d round=%d: %v", name, h, e.Round, err)
+				}
+			}
+		}
+	}
+}
+
+// expectedParentWeight recomputes the EC weight of parent, which is what a
+// child's ParentWeight must equal (filcns.Weight). StateMinerPower at the
+// parent key reads parent.ParentState(), the same state Weight uses.
+func expectedParentWeight(node api.FullNode, parent *types.TipSet) (types.BigInt, bool) {
+	if parent.Height() == 0 {
+		return types.EmptyInt, false
+	}
+
+	var wins int64
+	for _, b := range parent.Blocks() {
+		if b.ElectionProof == nil {
+			return types.EmptyInt, false
+		}
+		wins += b.ElectionProof.WinCount
+	}
+
+	pow, err := node.StateMinerPower(ctx, parent.Blocks()[0].Miner, parent.Key())
+	if err != nil || pow.TotalPower.QualityAdjPower.Sign() <= 0 {
+		return types.EmptyInt, false
+	}
+	log2P := int64(pow.TotalPower.QualityAdjPower.Int.BitLen() - 1)
+
+	out := new(big.Int).Set(parent.ParentWeight().Int)
+	out.Add(out, big.NewInt(log2P<<8))
+
+	eWeight := big.NewInt(log2P * buildconstants.WRatioNum)
+	eWeight.Lsh(eWeight, 8)
+	eWeight.Mul(eWeight, big.NewInt(wins))
+	eWeight.Div(eWeight, big.NewInt(int64(buildconstants.BlocksPerEpoch*buildconstants.WRatioDen)))
+	out.Add(out, eWeight)
+
+	return types.BigInt{Int: out}, true
+}
+
+// lookbackKey returns the key of the tipset whose parent state is the
+// winning-PoSt lookback state for ts (stmgr.GetLookbackTipSetForRound).
+func lookbackKey(node api.FullNode, ts, parent *types.TipSet) (types.TipSetKey, bool) {
+	nv, err := node.StateNetworkVersion(ctx, parent.Key())
+	if err != nil {
+		return types.EmptyTSK, false
+	}
+	lb := policy.GetWinningPoStSectorSetLookback(nv)
+
+	var lbr abi.ChainEpoch
+	if ts.Height() > lb {
+		lbr = ts.Height() - lb
+	}
+	if lbr >= parent.Height() {
+		return types.EmptyTSK, false
+	}
+
+	next, err := node.ChainGetTipSetAfterHeight(ctx, lbr+1, parent.Key())
+	if err != nil {
+		return types.EmptyTSK, false
+	}
+	return next.Key(), true
+}
+
+// latestBeaconEntry mirrors ChainStore.GetLatestBeaconEntry: the last beacon
+// entry found walking back from ts.
+func latestBeaconEntry(node api.FullNode, ts *types.TipSet) *types.BeaconEntry {
+	cur := ts
+	for i := 0; i < beaconSearchDepth; i++ {
+		if be := cur.Blocks()[0].BeaconEntries; len(be) > 0 {
+			return &be[len(be)-1]
+		}
+		if cur.Height() == 0 {
+			return nil
+		}
+		next, err := node.ChainGetTipSet(ctx, cur.Parents())
+		if err != nil {
+			return nil
+		}
+		cur = next
+	}
+	return nil
+}
+
+// workerBLSKey resolves the miner's worker to its BLS public key at lbKey.
+func workerBLSKey(node api.FullNode, miner address.Address, lbKey types.TipSetKey) ([]byte, bool) {
+	info, err := node.StateMinerInfo(ctx, miner, lbKey)
+	if err != nil {
+		return nil, false
+	}
+	key, err := node.StateAccountKey(ctx, info.Worker, lbKey)
+	if err != nil || key.Protocol() != address.BLS {
+		return nil, false
+	}
+	return key.Payload(), true
+}
+
+// drawRandomness is rand.DrawRandomnessFromBase, reimplemented so the audit
+// does not share code with the node under test.
+func drawRandomness(rbase []byte, pers crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) []byte {
+	digest := blake2b.Sum256(rbase)
+	h, _ := blake2b.New256(nil)
+	binary.Write(h, binary.BigEndian, int64(pers))
+	h.Write(digest[:])
+	binary.Write(h, binary.BigEndian, int64(round))
+	h.Write(entropy)
+	return h.Sum(nil)
+}
+
+// verifyFilecoinBLS checks a Filecoin BLS signature (G1 public key, G2
+// signature) over msg.
+func verifyFilecoinBLS(pub, msg, sig []byte) error {
+	key := blsSuite.G1().Point()
+	if err := key.UnmarshalBinary(pub); err != nil {
+		return err
+	}
+	return bdn.NewSchemeOnG2(blsSuite).Verify(key, msg, sig)
+}
+
+// mergeDetails returns a copy of base with extra merged in.
+func mergeDetails(base, extra map[string]any) map[string]any {
+	out := make(map[string]any, len(base)+len(extra))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range extra {
+		out[k] = v
+	}
+	return out
+}
@@ -72,13 +72,14 @@ func pushMsg(node api.FullNode, msg *types.Message, ki *types.KeyInfo, tag strin
 		return false
 	}
 
-	_, err := node.MpoolPush(ctx, smsg)
+	msgCid, err := node.MpoolPush(ctx, smsg)
 	if err != nil {
 		log.Printf("[%s] MpoolPush failed: %v", tag, err)
 		return false
 	}
 
 	nonces[msg.From]++
+	trackPushedMsg(node, msgCid, msg, tag)
 	return true
 }
 
@@ -157,6 +158,7 @@ func pushMsgWithCid(node api.FullNode, msg *types.Message, ki *types.KeyInfo, ta
 	}
 
 	nonces[msg.From]++
+	trackPushedMsg(node, msgCid, msg, tag)
 	return msgCid, true
 }
 
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/builtin"
+	v16 "github.com/filecoin-project/go-state-types/builtin/v16"
+	v17 "github.com/filecoin-project/go-state-types/builtin/v17"
+	v18 "github.com/filecoin-project/go-state-types/builtin/v18"
+	"github.com/filecoin-project/go-state-types/builtin/v8/util/adt"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ===========================================================================
+// Post-migration builtin actor invariants
+//
+// doMigrationStateRootAgreement only proves the implementations migrated
+// identically. This check loads the first post-migration state over RPC and
+// runs the builtin-actors invariant suite for the new actors version:
+//
+//   - power totals vs the sum of miner claims
+//   - market escrow/locked balances vs deal states
+//   - verifreg allocations/claims vs datacap balances
+//   - per-miner sector, deadline and partition consistency
+//
+// Runs once per boundary per distinct post-migration state root (nodes that
+// agree share one check), in a background goroutine so a slow walk doesn't
+// hold the deck; one run at a time. Boundaries whose actors version has no
+// invariant suite here are logged once and marked checked. Violations are
+// grouped by actor in the assertion details so the report shows which actor
+// broke at which boundary.
+// ===========================================================================
+
+const (
+	invariantMaxActors    = 50 // actors listed in assertion details
+	invariantMaxPerActor  = 5  // violation messages listed per actor
+	invariantCheckTimeout = 10 * time.Minute
+)
+
+var (
+	invariantsChecked   = make(map[string]bool) // boundary/stateRoot -> done or skipped
+	invariantsCheckedMu sync.Mutex
+	invariantsRunning   atomic.Bool
+)
+
+// errInvariantsUnsupported means no invariant suite is wired up for the
+// actors version; retrying won't help.
+var errInvariantsUnsupported = errors.New("no invariant suite for actors version")
+
+// doMigrationInvariants runs the builtin actor invariant checks on the state
+// produced by b's migration, once it is finalized on every node.
+func doMigrationInvariants(b upgradeBoundary) {
+	snap := getFinalizedSnapshots()
+	finalizedHeight, anchorKey := snapshotMinHeight(snap)
+	if finalizedHeight < b.Epoch+2 {
+		return
+	}
+
+	// The migration runs while computing the state at b.Epoch, so the first
+	// post-migration root is the parent state of the tipset after it. That
+	// state has cron run through ts.Height()-1 even across null rounds, so
+	// that is its prior epoch (as lotus-shed check-invariants uses), not the
+	// parent tipset's height.
+	roots := make(map[cid.Cid][]string)
+	priors := make(map[cid.Cid]abi.ChainEpoch)
+	for _, name := range nodeKeys {
+		if s, ok := snap[name]; !ok || s.err != nil {
+			continue
+		}
+		ts, err := nodes[name].ChainGetTipSetByHeight(ctx, b.Epoch+1, anchorKey)
+		if err != nil || ts.Height() <= b.Epoch {
+			continue
+		}
+		roots[ts.ParentState()] = append(roots[ts.ParentState()], name)
+		priors[ts.ParentState()] = ts.Height() - 1
+	}
+
+	invariantsCheckedMu.Lock()
+	for root := range roots {
+		if invariantsChecked[b.Name+"/"+root.String()] {
+			delete(roots, root)
+		}
+	}
+	invariantsCheckedMu.Unlock()
+	if len(roots) == 0 || !invariantsRunning.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer invariantsRunning.Store(false)
+		for root, names := range roots {
+			if checkStateInvariants(b, names, nodes[names[0]], root, priors[root]) {
+				invariantsCheckedMu.Lock()
+				invariantsChecked[b.Name+"/"+root.String()] = true
+				invariantsCheckedMu.Unlock()
+			}
+		}
+	}()
+}
+
+// checkStateInvariants runs the suite against root via node and asserts on the
+// result. Returns false if the check could not complete (RPC failure), so it
+// is retried on a later pass; true once it ran or was skipped for good.
+func checkStateInvariants(b upgradeBoundary, names []string, node api.FullNode, root cid.Cid, priorEpoch abi.ChainEpoch) bool {
+	// The post-migration state belongs to the boundary's network version,
+	// not whatever version the head is at now.
+	nv := b.Network
+	av, err := actorstypes.VersionForNetwork(nv)
+	if err != nil {
+		log.Printf("[upgrade/%s] invariants: no actors version for nv%d, skipping: %v", b.Name, nv, err)
+		return true
+	}
+	codes, err := node.StateActorCodeCIDs(ctx, nv)
+	if err != nil {
+		log.Printf("[upgrade/%s] invariants: StateActorCodeCIDs(nv%d) failed: %v", b.Name, nv, err)
+		return false
+	}
+
+	start := time.Now()
+	msgs, err := runStateInvariants(node, av, root, priorEpoch, codes)
+	if errors.Is(err, errInvariantsUnsupported) {
+		log.Printf("[upgrade/%s] invariants: actors v%d has no invariant suite, skipping", b.Name, av)
+		return true
+	}
+	if err != nil {
+		log.Printf("[upgrade/%s] invariants: check on %s failed: %v", b.Name, root, err)
+		return false
+	}
+
+	byActor := groupInvariantViolations(msgs)
+	assert.Always(len(msgs) == 0, "Builtin actor invariants hold after upgrade migration", map[string]any{
+		"boundary":        b.Name,
+		"upgrade_epoch":   b.Epoch,
+		"network_version": int(nv),
+		"actors_version":  int(av),
+		"state_root":      root.String(),
+		"nodes":           names,
+		"violation_count": len(msgs),
+		"actors":          len(byActor),
+		"violations":      byActor,
+	})
+
+	if len(msgs) > 0 {
+		log.Printf("[upgrade/%s] INVARIANT VIOLATIONS at %s (nodes=%v): %d messages across %d actors",
+			b.Name, root, names, len(msgs), len(byActor))
+		for actor, vs := range byActor {
+			log.Printf("[upgrade/%s]   %s: %v", b.Name, actor, vs)
+		}
+	} else {
+		log.Printf("[upgrade/%s] invariants OK at %s (actors v%d, nodes=%v, took %s)",
+			b.Name, root, av, names, time.Since(start).Round(time.Second))
+	}
+	return true
+}
+
+// runStateInvariants loads the actor tree at root through the node's
+// ChainReadObj and runs the invariant suite for actors version av.
+func runStateInvariants(node api.FullNode, av actorstypes.Version, root cid.Cid, priorEpoch abi.ChainEpoch, codes map[string]cid.Cid) ([]string, error) {
+	cctx, cancel := context.WithTimeout(ctx, invariantCheckTimeout)
+	defer cancel()
+
+	store := adt.WrapStore(cctx, cbor.NewCborStore(blockstore.NewAPIBlockstore(node)))
+
+	var sr types.StateRoot
+	if err := store.Get(cctx, root, &sr); err != nil {
+		return nil, fmt.Errorf("loading state root: %w", err)
+	}
+	tree, err := builtin.LoadTree(store, sr.Actors)
+	if err != nil {
+		return nil, fmt.Errorf("loading actor tree: %w", err)
+	}
+
+	var acc *builtin.MessageAccumulator
+	switch av {
+	case actorstypes.Version16:
+		acc, err = v16.CheckStateInvariants(tree, priorEpoch, codes)
+	case actorstypes.Version17:
+		acc, err = v17.CheckStateInvariants(tree, priorEpoch, codes)
+	case actorstypes.Version18:
+		acc, err = v18.CheckStateInvariants(tree, priorEpoch, codes)
+	default:
+		return nil, fmt.Errorf("%w v%d", errInvariantsUnsupported, av)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return acc.Messages(), nil
+}
+
+// groupInvariantViolations buckets accumulator messages by their leading
+// token — the actor address for per-actor checks, or the check name (e.g.
+// "power", "market") for cross-actor ones — capped for assertion payloads.
+func groupInvariantViolations(msgs []string) map[string][]string {
+	out := make(map[string][]string)
+	for _, m := range msgs {
+		actor, rest, ok := strings.Cut(m, " ")
+		if !ok {
+			actor, rest = "state", m
+		}
+		if _, seen := out[actor]; !seen && len(out) >= invariantMaxActors {
+			continue
+		}
+		if len(out[actor]) < invariantMaxPerActor {
+			out[actor] = append(out[actor], rest)
+		}
+	}
+	for _, vs := range out {
+		sort.Strings(vs)
+	}
+	return out
+}
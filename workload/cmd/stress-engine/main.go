@@ -35,6 +35,7 @@ var (
 	// Node connections: key = node hostname (e.g. "lotus0")
 	nodes    map[string]api.FullNode
 	nodeKeys []string
+	nodeCfg  chain.NodeConfig // for raw calls (chain.CallRaw)
 
 	// Wallet state loaded from stress_keystore.json
 	keystore map[address.Address]*types.KeyInfo
@@ -146,14 +147,14 @@ func pickAttackWallet() (address.Address, *types.KeyInfo) {
 // ---------------------------------------------------------------------------
 
 func connectNodes() {
-	cfg := chain.NodeConfig{
+	nodeCfg = chain.NodeConfig{
 		Names:      strings.Split(envOrDefault("STRESS_NODES", "lotus0"), ","),
 		Port:       envOrDefault("STRESS_RPC_PORT", "1234"),
 		ForestPort: envOrDefault("STRESS_FOREST_RPC_PORT", "3456"),
 	}
 
 	var err error
-	nodes, nodeKeys, err = chain.ConnectNodes(ctx, cfg)
+	nodes, nodeKeys, err = chain.ConnectNodes(ctx, nodeCfg)
 	if err != nil {
 		log.Fatalf("[init] FATAL: %v", err)
 	}
@@ -177,6 +178,15 @@ func loadKeystore() {
 		log.Fatalf("[init] FATAL: cannot parse keystore: %v", err)
 	}
 
+	// When enabled, the protocol fuzzer signs messages with the first
+	// FUZZER_KEYSTORE_WALLETS entries; skip them so its nonces never race
+	// ours. Same enable check and default as the fuzzer's own.
+	if envOrDefault("FUZZER_ENABLED", "1") == "1" {
+		if n := envInt("FUZZER_KEYSTORE_WALLETS", 2); n > 0 && n < len(entries) {
+			entries = entries[n:]
+		}
+	}
+
 	keystore = make(map[address.Address]*types.KeyInfo, len(entries))
 	nonces = make(map[address.Address]uint64, len(entries))
 	addrs = make([]address.Address, 0, len(entries))
@@ -220,6 +230,8 @@ func loadKeystore() {
 	atkAddrs = addrs[splitIdx:]
 	addrs = addrs[:splitIdx]
 	log.Printf("[init] loaded %d deck keys + %d attack-reserved keys from keystore", len(addrs), len(atkAddrs))
+
+	reserveMpoolPolicyWallets()
 }
 
 func waitForChain() {
@@ -284,7 +296,9 @@ func buildDeck() {
 		{"DoStateAudit", "STRESS_WEIGHT_STATE_AUDIT", DoStateAudit, 5},
 		{"DoF3FinalityMonitor", "STRESS_WEIGHT_F3_MONITOR", DoF3FinalityMonitor, 2},
 		{"DoF3FinalityAgreement", "STRESS_WEIGHT_F3_AGREEMENT", DoF3FinalityAgreement, 3},
+		{"DoF3CertChainAudit", "STRESS_WEIGHT_F3_CERTCHAIN", DoF3CertChainAudit, 2},
 		{"DoDrandBeaconAudit", "STRESS_WEIGHT_DRAND_BEACON_AUDIT", DoDrandBeaconAudit, 3},
+		{"DoBlockHeaderAudit", "STRESS_WEIGHT_HEADER_AUDIT", DoBlockHeaderAudit, 2},
 	}
 
 	// Network upgrade suite — single entry, runs all sub-vectors per invocation.
@@ -316,6 +330,8 @@ func buildDeck() {
 		// Mempool safety
 		{"DoDoubleSpend", "STRESS_WEIGHT_DOUBLE_SPEND", doDoubleSpend, 1},
 		{"DoInvalidSignature", "STRESS_WEIGHT_INVALID_SIG", doInvalidSignature, 1},
+		{"DoMempoolConsistency", "STRESS_WEIGHT_MEMPOOL_CONSISTENCY", DoMempoolConsistency, 1},
+		{"DoMempoolPolicyMatrix", "STRESS_WEIGHT_MEMPOOL_POLICY", DoMempoolPolicyMatrix, 1},
 		// Cross-node divergence
 		{"DoMessageOrderingAttack", "STRESS_WEIGHT_MSG_ORDERING", DoMessageOrderingAttack, 1},
 		{"DoNonceBombard", "STRESS_WEIGHT_NONCE_BOMBARD", DoNonceBombard, 0},
@@ -331,6 +347,7 @@ func buildDeck() {
 		{"DoFIP0115BaseFeeResponse", "STRESS_WEIGHT_FIP0115", DoFIP0115BaseFeeResponse, 0},
 		// Reorg chaos (guarded by partitionActive to avoid stomping n-split)
 		{"DoReorgChaos", "STRESS_WEIGHT_REORG", DoReorgChaos, 0},
+		{"DoDeepReorg", "STRESS_WEIGHT_DEEP_REORG", DoDeepReorg, 0},
 	}
 
 	// Build actions list: consensus always, upgrade always, stress only when FOC is not active
@@ -400,7 +417,9 @@ func main() {
 	buildDeck()
 
 	// Background goroutines — run independently of the deck
-	startForkMonitor() // observes forks during partitions
+	startForkMonitor()         // observes forks during partitions
+	startMsgLifecycleTracker() // follows every pushed message to a terminal state
+	startBlockPropMonitor()    // ChainNotify per node: block arrival timing
 	if focCfg == nil {
 		startConsensusTestLifecycle() // structured EC/F3 integration test cycles (skip in FOC — disrupts Curio)
 	} else {
@@ -429,6 +448,7 @@ func main() {
 			for name, count := range actionCounts {
 				log.Printf("[engine]   %s: %d", name, count)
 			}
+			logMsgLifecycleSummary()
 			if focCfg != nil {
 				logFOCProgress()
 			}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ===========================================================================
+// DoMempoolPolicyMatrix — Lotus vs Forest mempool acceptance differential
+//
+// Runs one identical scripted sequence against a Lotus node and a Forest
+// node, each with its own clean wallet from policyAddrs (no pending messages),
+// and compares the per-step accept/reject outcome and error class:
+//
+//   baseline        nonce n, reference premium
+//   duplicate_cid   the exact same signed message again
+//   rbf_below       nonce n, premium one below the RBF minimum
+//   rbf_above       nonce n, premium exactly at the RBF minimum
+//   feecap_low      nonce n+1, fee cap at half the current base fee
+//   feecap_fix      nonce n+1, sane fee cap, premium above RBF minimum
+//   gap_small       nonce n+3 (gap of one)
+//   gap_fill        nonce n+2
+//   gap_large       next+mpoolMaxNonceGap+1 (beyond the remote gap limit)
+//   gap_fill_wide   every nonce below gap_large
+//   pending_limit   burst until rejected (every mpoolPolicyBurstEvery runs)
+//   oversize        params pushing the message past 64KiB
+//
+// Nonces are relative to each wallet's own base, so the two runs see the
+// same script. A step one implementation accepts and the other rejects is a
+// policy divergence; for steps marked gossip, the accepting side will
+// publish a message its peers reject at validation, which costs it peer
+// score and can split the gossip mesh along implementation lines. Those are
+// flagged with assert.Always; the rest are reported in the details only.
+// ===========================================================================
+
+const (
+	mpoolRBFPercent        = 110      // Lotus ReplaceByFeePercentageMinimum
+	mpoolMaxNonceGap       = 4        // Lotus MaxNonceGap; only enforced on messages from peers
+	mpoolMaxMsgSize        = 64 << 10 // Lotus MaxMessageSize
+	mpoolPolicyPremium     = 10_000
+	mpoolPolicyBurstMax    = 1200 // just above Lotus MaxActorPendingMessages
+	mpoolPolicyBurstEvery  = 10
+	mpoolPolicyWalletTries = 5
+)
+
+type mpoolPolicyResult struct {
+	Step     string `json:"step"`
+	Gossip   bool   `json:"gossip"`
+	Accepted bool   `json:"accepted"`
+	Class    string `json:"class,omitempty"`
+	Count    int    `json:"count,omitempty"` // pending_limit: messages accepted
+	Err      string `json:"err,omitempty"`
+}
+
+var mpoolPolicyRuns int
+
+func DoMempoolPolicyMatrix() {
+	if partitionActive.Load() || len(policyAddrs) < 2 {
+		return
+	}
+
+	var lotusNames, forestNames []string
+	for _, name := range nodeKeys {
+		if nodeType(name) == "forest" {
+			forestNames = append(forestNames, name)
+		} else {
+			lotusNames = append(lotusNames, name)
+		}
+	}
+	if len(lotusNames) == 0 || len(forestNames) == 0 {
+		return
+	}
+	lotusName, forestName := rngChoice(lotusNames), rngChoice(forestNames)
+
+	bf := sampleBaseFee()
+	if bf == nil {
+		return
+	}
+
+	lotusFrom, lotusKI, lotusBase, ok := pickCleanPolicyWallet(nodes[lotusName], address.Undef)
+	if !ok {
+		debugLog("[mpool-policy] no clean policy wallet for %s", lotusName)
+		return
+	}
+	forestFrom, forestKI, forestBase, ok := pickCleanPolicyWallet(nodes[forestName], lotusFrom)
+	if !ok {
+		debugLog("[mpool-policy] no clean policy wallet for %s", forestName)
+		return
+	}
+	to, _ := pickWallet()
+
+	mpoolPolicyRuns++
+	burst := mpoolPolicyRuns%mpoolPolicyBurstEvery == 0
+
+	lotusRes := runMpoolPolicyScript(lotusName, lotusFrom, lotusKI, to, lotusBase, bf, burst)
+	if lotusRes == nil {
+		return
+	}
+	forestRes := runMpoolPolicyScript(forestName, forestFrom, forestKI, to, forestBase, bf, burst)
+	if forestRes == nil {
+		return
+	}
+
+	var gossipDiv, otherDiv, classDiv []string
+	matrix := make(map[string]string, len(lotusRes))
+	for i := range lotusRes {
+		l, f := lotusRes[i], forestRes[i]
+		matrix[l.Step] = fmt.Sprintf("lotus=%s forest=%s", outcomeStr(l), outcomeStr(f))
+		switch {
+		case l.Accepted != f.Accepted && l.Gossip:
+			gossipDiv = append(gossipDiv, l.Step)
+		case l.Accepted != f.Accepted:
+			otherDiv = append(otherDiv, l.Step)
+		case !l.Accepted && l.Class != f.Class:
+			classDiv = append(classDiv, l.Step)
+		}
+	}
+
+	details := map[string]any{
+		"lotus_node":           lotusName,
+		"forest_node":          forestName,
+		"base_fee":             bf.String(),
+		"pending_limit_run":    burst,
+		"matrix":               matrix,
+		"lotus":                lotusRes,
+		"forest":               forestRes,
+		"gossip_divergences":   gossipDiv,
+		"other_divergences":    otherDiv,
+		"error_class_mismatch": classDiv,
+	}
+	assert.Always(len(gossipDiv) == 0, "Lotus and Forest agree on mempool acceptance of gossip-relevant messages", details)
+	assert.Sometimes(true, "Mempool policy matrix completed on both implementations", map[string]any{
+		"pending_limit_run": burst,
+	})
+
+	if len(gossipDiv)+len(otherDiv)+len(classDiv) > 0 {
+		log.Printf("[mpool-policy] divergence %s vs %s: gossip=%v other=%v class=%v",
+			lotusName, forestName, gossipDiv, otherDiv, classDiv)
+		steps := make([]string, 0, len(matrix))
+		for s := range matrix {
+			steps = append(steps, s)
+		}
+		sort.Strings(steps)
+		for _, s := range steps {
+			log.Printf("[mpool-policy]   %-14s %s", s, matrix[s])
+		}
+	} else {
+		debugLog("[mpool-policy] %s and %s agree on %d steps", lotusName, forestName, len(lotusRes))
+	}
+}
+
+// policyAddrs are wallets only DoMempoolPolicyMatrix uses. Its bursts and
+// stuck low-fee or gapped messages would otherwise push the nonces of deck or
+// nsplit wallets far ahead of the chain.
+var policyAddrs []address.Address
+
+// reserveMpoolPolicyWallets moves the last STRESS_MEMPOOL_POLICY_WALLETS deck
+// wallets into policyAddrs, keeping at least half the deck.
+func reserveMpoolPolicyWallets() {
+	n := envInt("STRESS_MEMPOOL_POLICY_WALLETS", 4)
+	if n > len(addrs)/2 {
+		n = len(addrs) / 2
+	}
+	if n < 2 {
+		return
+	}
+	splitIdx := len(addrs) - n
+	policyAddrs = addrs[splitIdx:]
+	addrs = addrs[:splitIdx]
+	log.Printf("[init] reserved %d wallets for the mempool policy matrix", len(policyAddrs))
+}
+
+// pickCleanPolicyWallet returns a policy wallet, other than exclude, with
+// nothing pending on node (MpoolGetNonce equals the on-chain nonce), plus
+// that nonce. Leftovers from an earlier run would turn the script's fresh
+// nonces into replacements.
+func pickCleanPolicyWallet(node api.FullNode, exclude address.Address) (address.Address, *types.KeyInfo, uint64, bool) {
+	for i := 0; i < mpoolPolicyWalletTries; i++ {
+		addr := rngChoice(policyAddrs)
+		ki := keystore[addr]
+		if addr == exclude {
+			continue
+		}
+		act, err := node.StateGetActor(ctx, addr, types.EmptyTSK)
+		if err != nil || act == nil {
+			continue
+		}
+		next, err := node.MpoolGetNonce(ctx, addr)
+		if err != nil || next != act.Nonce {
+			continue
+		}
+		return addr, ki, act.Nonce, true
+	}
+	return address.Undef, nil, 0, false
+}
+
+// runMpoolPolicyScript pushes the scripted sequence to one node. Returns nil
+// if a partition started mid-run, since results would no longer compare.
+func runMpoolPolicyScript(name string, from address.Address, ki *types.KeyInfo, to address.Address, base uint64, bf *big.Int, burst bool) []mpoolPolicyResult {
+	node := nodes[name]
+	var out []mpoolPolicyResult
+
+	feeCap := new(big.Int).Add(new(big.Int).Mul(bf, big.NewInt(3)), big.NewInt(200_000))
+	mk := func(nonce uint64, value int64, premium int64) *types.Message {
+		m := baseMsg(from, to, abi.NewTokenAmount(value))
+		m.Nonce = nonce
+		m.GasFeeCap = abi.TokenAmount{Int: new(big.Int).Set(feeCap)}
+		m.GasPremium = abi.NewTokenAmount(premium)
+		return m
+	}
+	push := func(smsg *types.SignedMessage) error {
+		if smsg == nil {
+			return fmt.Errorf("signing failed")
+		}
+		_, err := node.MpoolPush(ctx, smsg)
+		return err
+	}
+	record := func(step string, gossip bool, err error) bool {
+		r := mpoolPolicyResult{Step: step, Gossip: gossip, Accepted: err == nil}
+		if err != nil {
+			r.Class = classifyMpoolErr(err)
+			r.Err = errStr(err)
+			if len(r.Err) > 200 {
+				r.Err = r.Err[:200]
+			}
+		}
+		out = append(out, r)
+		return err == nil
+	}
+	rbfMin := int64(mpoolPolicyPremium)*mpoolRBFPercent/100 + 1
+
+	// n: baseline, duplicate, then replace-by-fee either side of the minimum.
+	baseline := signMsg(mk(base, 1, mpoolPolicyPremium), ki)
+	record("baseline", true, push(baseline))
+	record("duplicate_cid", false, push(baseline))
+	record("rbf_below", true, push(signMsg(mk(base, 2, rbfMin-1), ki)))
+	record("rbf_above", true, push(signMsg(mk(base, 3, rbfMin), ki)))
+
+	// n+1: fee cap below base fee, then a replacement that can actually land.
+	low := mk(base+1, 4, 1)
+	low.GasFeeCap = abi.TokenAmount{Int: new(big.Int).Div(bf, big.NewInt(2))}
+	record("feecap_low", true, push(signMsg(low, ki)))
+	record("feecap_fix", true, push(signMsg(mk(base+1, 5, mpoolPolicyPremium), ki)))
+
+	// n+2, n+3: a small gap, then fill it.
+	record("gap_small", true, push(signMsg(mk(base+3, 6, mpoolPolicyPremium), ki)))
+	record("gap_fill", true, push(signMsg(mk(base+2, 7, mpoolPolicyPremium), ki)))
+
+	if partitionActive.Load() {
+		return nil
+	}
+
+	// Past the gap limit, then fill everything below it so an accepted
+	// gapped message becomes executable instead of stranding the wallet.
+	next := base + 4
+	gapNonce := next + mpoolMaxNonceGap + 1
+	gapAccepted := record("gap_large", true, push(signMsg(mk(gapNonce, 8, mpoolPolicyPremium), ki)))
+	var fillErr error
+	for n := next; n < gapNonce && fillErr == nil; n++ {
+		fillErr = push(signMsg(mk(n, 9, mpoolPolicyPremium), ki))
+	}
+	record("gap_fill_wide", true, fillErr)
+	next = gapNonce
+	if gapAccepted {
+		next++
+	}
+
+	if burst {
+		r := mpoolPolicyResult{Step: "pending_limit", Gossip: true, Accepted: true}
+		for i := 0; i < mpoolPolicyBurstMax; i++ {
+			if partitionActive.Load() {
+				return nil
+			}
+			if err := push(signMsg(mk(next, 10, mpoolPolicyPremium), ki)); err != nil {
+				r.Accepted = false
+				r.Class = classifyMpoolErr(err)
+				r.Err = errStr(err)
+				break
+			}
+			r.Count++
+			next++
+		}
+		out = append(out, r)
+	}
+
+	over := mk(next, 11, mpoolPolicyPremium)
+	over.Params = make([]byte, mpoolMaxMsgSize+1)
+	for i := range over.Params {
+		over.Params[i] = byte(i)
+	}
+	record("oversize", true, push(signMsg(over, ki)))
+
+	if partitionActive.Load() {
+		return nil
+	}
+	return out
+}
+
+// classifyMpoolErr maps a push error from either implementation onto a
+// coarse class, so differently worded errors for the same rule compare equal.
+func classifyMpoolErr(err error) string {
+	s := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(s, "too many pending"):
+		return "too_many_pending"
+	case strings.Contains(s, "nonce too low") || strings.Contains(s, "nonce is too low"):
+		return "nonce_too_low"
+	case strings.Contains(s, "gap"): // before too_big: Lotus says "too big a gap"
+		return "nonce_gap"
+	case strings.Contains(s, "too big") || strings.Contains(s, "too large") || strings.Contains(s, "message size"):
+		return "too_big"
+	case strings.Contains(s, "replace by fee") || strings.Contains(s, "rbf") || strings.Contains(s, "premium"):
+		return "rbf_too_low"
+	case strings.Contains(s, "already") || strings.Contains(s, "duplicate") || strings.Contains(s, "exists"):
+		return "duplicate"
+	case strings.Contains(s, "fee cap") || strings.Contains(s, "feecap") || strings.Contains(s, "base fee"):
+		return "fee_cap_too_low"
+	case strings.Contains(s, "funds") || strings.Contains(s, "balance"):
+		return "insufficient_funds"
+	case strings.Contains(s, "validation failure"):
+		return "soft_validation"
+	default:
+		return "other"
+	}
+}
+
+func outcomeStr(r mpoolPolicyResult) string {
+	s := "accept"
+	if !r.Accepted {
+		s = "reject(" + r.Class + ")"
+	}
+	if r.Step == "pending_limit" {
+		s = fmt.Sprintf("%s@%d", s, r.Count)
+	}
+	return s
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/antithesishq/antithesis-sdk-go/lifecycle"
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ===========================================================================
+// DoMempoolConsistency — cross-node mempool propagation and convergence
+//
+// Pushes a handful of tagged transfers to one node, then polls every other
+// node's MpoolPending until each message shows up (or has already been
+// mined there). Records per-pair propagation latency into histograms keyed
+// by implementation ("lotus->forest", ...) which are emitted to the run
+// report via lifecycle.SendEvent.
+//
+// After propagation, the batch's own sender/nonce keys are diffed between
+// every node pair until they agree or mempoolPropSettle passes: a key
+// pending on one node but not another, or pending with a different CID, is
+// reported in the assertion details. Deck traffic is left out, since it
+// keeps arriving between the per-node snapshots.
+//
+// Convergence is liveness (assert.Sometimes): Antithesis network faults can
+// legitimately delay gossip past the timeout in any single run.
+// ===========================================================================
+
+const (
+	mempoolPropBatch   = 4
+	mempoolPropPoll    = 500 * time.Millisecond
+	mempoolPropTimeout = 30 * time.Second
+	mempoolPropSettle  = 15 * time.Second // for the batch's pending sets to agree
+	mempoolPropEmitN   = 10               // emit histograms every N runs
+)
+
+// latencyBucketsMs are the upper bounds of the histogram buckets; slower
+// samples land in "le_inf", and ones never observed in "timeout".
+var latencyBucketsMs = []int64{250, 500, 1000, 2000, 5000, 10000, 30000}
+
+type latencyHist struct {
+	counts  []int // len(latencyBucketsMs)+2: buckets, then +Inf, then timeout
+	samples int
+	sumMs   int64
+	maxMs   int64
+}
+
+func (h *latencyHist) add(d time.Duration, timedOut bool) {
+	if h.counts == nil {
+		h.counts = make([]int, len(latencyBucketsMs)+2)
+	}
+	if timedOut {
+		h.counts[len(latencyBucketsMs)+1]++
+		return
+	}
+	ms := d.Milliseconds()
+	h.samples++
+	h.sumMs += ms
+	if ms > h.maxMs {
+		h.maxMs = ms
+	}
+	for i, ub := range latencyBucketsMs {
+		if ms <= ub {
+			h.counts[i]++
+			return
+		}
+	}
+	h.counts[len(latencyBucketsMs)]++
+}
+
+func (h *latencyHist) details() map[string]any {
+	out := map[string]any{"samples": h.samples, "max_ms": h.maxMs}
+	if h.samples > 0 {
+		out["mean_ms"] = h.sumMs / int64(h.samples)
+	}
+	for i, ub := range latencyBucketsMs {
+		out[fmt.Sprintf("le_%dms", ub)] = h.counts[i]
+	}
+	out["le_inf"] = h.counts[len(latencyBucketsMs)]
+	out["timeout"] = h.counts[len(latencyBucketsMs)+1]
+	return out
+}
+
+var (
+	propHists   = make(map[string]*latencyHist) // "lotus->forest" -> histogram
+	propHistsMu sync.Mutex
+	propRuns    int
+)
+
+func DoMempoolConsistency() {
+	if len(nodeKeys) < 2 {
+		return
+	}
+	if partitionActive.Load() {
+		return
+	}
+
+	origin, originNode := pickNode()
+
+	// Push the batch from distinct senders so each is independently minable.
+	type pushed struct {
+		cid cid.Cid
+		key nonceSlot
+		at  time.Time
+	}
+	var batch []pushed
+	used := make(map[address.Address]bool)
+	for i := 0; i < mempoolPropBatch; i++ {
+		from, ki := pickWallet()
+		to, _ := pickWallet()
+		if from == to || used[from] {
+			continue
+		}
+		used[from] = true
+		msg := baseMsg(from, to, abi.NewTokenAmount(int64(rngIntn(100)+1)))
+		c, ok := pushMsgWithCid(originNode, msg, ki, "mempool-prop")
+		if !ok {
+			continue
+		}
+		batch = append(batch, pushed{cid: c, key: nonceSlot{msg.From, msg.Nonce}, at: time.Now()})
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	// Poll every other node until each message is seen pending there.
+	seen := make(map[string]map[cid.Cid]time.Duration) // node -> cid -> latency
+	targets := make([]string, 0, len(nodeKeys)-1)
+	for _, name := range nodeKeys {
+		if name != origin {
+			targets = append(targets, name)
+			seen[name] = make(map[cid.Cid]time.Duration)
+		}
+	}
+
+	deadline := time.Now().Add(mempoolPropTimeout)
+	for time.Now().Before(deadline) {
+		remaining := 0
+		for _, name := range targets {
+			if len(seen[name]) == len(batch) {
+				continue
+			}
+			pending, err := nodes[name].MpoolPending(ctx, types.EmptyTSK)
+			if err != nil {
+				remaining++
+				continue
+			}
+			now := time.Now()
+			have := make(map[cid.Cid]bool, len(pending))
+			for _, sm := range pending {
+				have[sm.Cid()] = true
+			}
+			for _, p := range batch {
+				if _, ok := seen[name][p.cid]; !ok && have[p.cid] {
+					seen[name][p.cid] = now.Sub(p.at)
+				}
+			}
+			if len(seen[name]) < len(batch) {
+				remaining++
+			}
+		}
+		if remaining == 0 || partitionActive.Load() {
+			break
+		}
+		time.Sleep(mempoolPropPoll)
+	}
+	if partitionActive.Load() {
+		return
+	}
+
+	// Anything not seen pending may simply have been mined before the poll
+	// caught it; that counts as converged but not as a latency sample.
+	converged := true
+	missing := make(map[string][]string)
+	minedFirst := 0
+	propHistsMu.Lock()
+	for _, name := range targets {
+		key := nodeType(origin) + "->" + nodeType(name)
+		h := propHists[key]
+		if h == nil {
+			h = &latencyHist{}
+			propHists[key] = h
+		}
+		for _, p := range batch {
+			if d, ok := seen[name][p.cid]; ok {
+				h.add(d, false)
+				continue
+			}
+			if lookup, err := nodes[name].StateSearchMsg(ctx, types.EmptyTSK, p.cid, msgSearchLookback, false); err == nil && lookup != nil {
+				minedFirst++
+				continue
+			}
+			h.add(0, true)
+			converged = false
+			missing[name] = append(missing[name], cidStr(p.cid))
+		}
+	}
+	propRuns++
+	emit := propRuns%mempoolPropEmitN == 0
+	hists := make(map[string]any, len(propHists))
+	for k, h := range propHists {
+		hists[k] = h.details()
+	}
+	propHistsMu.Unlock()
+
+	keys := make(map[nonceSlot]bool, len(batch))
+	for _, p := range batch {
+		keys[p.key] = true
+	}
+	diffs := diffPendingSets(keys)
+	for settle := time.Now().Add(mempoolPropSettle); len(diffs) > 0 && time.Now().Before(settle); {
+		time.Sleep(mempoolPropPoll)
+		if partitionActive.Load() {
+			return
+		}
+		diffs = diffPendingSets(keys)
+	}
+
+	details := map[string]any{
+		"origin":        origin,
+		"origin_type":   nodeType(origin),
+		"messages":      len(batch),
+		"mined_first":   minedFirst,
+		"missing":       missing,
+		"timeout_sec":   int(mempoolPropTimeout.Seconds()),
+		"pending_diffs": diffs,
+	}
+	assert.Sometimes(converged, "Pushed messages propagate to every node's mempool", details)
+	assert.Sometimes(len(diffs) == 0, "Pushed messages' pending state agrees across nodes per sender/nonce", details)
+
+	for _, name := range targets {
+		if nodeType(name) != nodeType(origin) && len(seen[name]) > 0 {
+			assert.Reachable("Mempool propagation observed across implementations", map[string]any{
+				"pair": nodeType(origin) + "->" + nodeType(name),
+			})
+			break
+		}
+	}
+
+	if !converged {
+		log.Printf("[mempool-prop] %d msgs from %s not seen within %s: %v", len(batch), origin, mempoolPropTimeout, missing)
+	} else {
+		debugLog("[mempool-prop] %d msgs from %s converged (mined_first=%d, diffs=%d)", len(batch), origin, minedFirst, len(diffs))
+	}
+
+	if emit {
+		lifecycle.SendEvent("mempool_propagation_latency", hists)
+		log.Printf("[mempool-prop] latency histograms after %d runs: %v", propRuns, hists)
+	}
+}
+
+// diffPendingSets snapshots MpoolPending on every node and reports, per node
+// pair, which of keys are pending on one but not the other or pending with
+// different CIDs. Differences are expected transiently (a pool that hasn't
+// pruned a just-mined message), hence Sometimes rather than Always.
+func diffPendingSets(keys map[nonceSlot]bool) map[string]map[string]int {
+	pools := make(map[string]map[nonceSlot]cid.Cid)
+	for _, name := range nodeKeys {
+		pending, err := nodes[name].MpoolPending(ctx, types.EmptyTSK)
+		if err != nil {
+			continue
+		}
+		m := make(map[nonceSlot]cid.Cid, len(keys))
+		for _, sm := range pending {
+			if k := (nonceSlot{sm.Message.From, sm.Message.Nonce}); keys[k] {
+				m[k] = sm.Cid()
+			}
+		}
+		pools[name] = m
+	}
+
+	names := make([]string, 0, len(pools))
+	for n := range pools {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	out := make(map[string]map[string]int)
+	for i := 0; i < len(names); i++ {
+		for j := i + 1; j < len(names); j++ {
+			a, b := pools[names[i]], pools[names[j]]
+			var onlyA, onlyB, conflict int
+			for k, ca := range a {
+				cb, ok := b[k]
+				switch {
+				case !ok:
+					onlyA++
+				case cb != ca:
+					conflict++
+				}
+			}
+			for k := range b {
+				if _, ok := a[k]; !ok {
+					onlyB++
+				}
+			}
+			if onlyA+onlyB+conflict > 0 {
+				out[names[i]+"|"+names[j]] = map[string]int{
+					"only_" + names[i]: onlyA,
+					"only_" + names[j]: onlyB,
+					"conflict":         conflict,
+				}
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ===========================================================================
+// Message lifecycle tracker
+//
+// pushMsg forgets a message once MpoolPush accepts it, so a message that is
+// accepted and then silently vanishes goes unnoticed. Every CID accepted by
+// pushMsg/pushMsgWithCid is recorded here, and a background goroutine
+// follows it until it reaches a terminal state:
+//
+//   included — StateSearchMsg finds it on some node
+//   replaced — the sender's on-chain nonce moved past it without it landing
+//              (a same-nonce message won, e.g. a nonce race or RBF)
+//   expired  — it or a same-nonce replacement still pending somewhere after
+//              msgTrackTTL; stuck, not lost
+//   pruned   — gone while some mempool was near its prune watermark; it may
+//              have been trimmed for low gas, so this is inconclusive
+//   lost     — absent from every node's mempool and chain, nonce unused
+//
+// "lost" raises an Always assertion, but only when every node answered the
+// tick and the pushing node has not restarted since the push (its Session
+// UUID is unchanged) — a crash before gossip legitimately drops the message.
+// ===========================================================================
+
+const (
+	msgTrackInterval = 30 * time.Second
+	msgTrackMinAge   = 20 * time.Second // give fresh pushes time to propagate
+	msgTrackTTL      = 10 * time.Minute
+	msgTrackMaxLen   = 5000 // oldest entries are dropped beyond this
+	msgLostMisses    = 2    // consecutive ticks missing everywhere before "lost"
+
+	// msgPruneLowDefault is Lotus's default mempool SizeLimitLow, used for
+	// nodes that don't answer MpoolGetConfig.
+	msgPruneLowDefault = 20000
+
+	// msgSearchLookback bounds StateSearchMsg. Tracked messages are at most
+	// msgTrackTTL old, far fewer epochs than this, so a miss here is a miss.
+	msgSearchLookback = abi.ChainEpoch(500)
+)
+
+type trackedMsg struct {
+	cid      cid.Cid
+	from     address.Address
+	nonce    uint64
+	node     string
+	tag      string
+	session  uuid.UUID // pushing node's session at push time (zero if unknown)
+	pushedAt time.Time
+	misses   int
+}
+
+var (
+	trackedMsgs   []*trackedMsg
+	trackedMsgsMu sync.Mutex
+
+	// Last observed Session UUID per node, refreshed each tick.
+	nodeSessions   = make(map[string]uuid.UUID)
+	nodeSessionsMu sync.Mutex
+
+	msgLifecycleCounts = make(map[string]int)
+
+	// Last tick at which some node's mempool was near its prune watermark.
+	mpoolPressureAt time.Time
+)
+
+// nonceSlot identifies a message by sender and nonce, so a pending
+// replace-by-fee message counts for the one it replaced.
+type nonceSlot struct {
+	from  address.Address
+	nonce uint64
+}
+
+// mempoolView is the union of every node's mempool at one tick.
+type mempoolView struct {
+	cids  map[cid.Cid]bool
+	slots map[nonceSlot]bool
+}
+
+// trackPushedMsg records an accepted message for lifecycle tracking.
+func trackPushedMsg(node api.FullNode, msgCid cid.Cid, msg *types.Message, tag string) {
+	name := nodeNameOf(node)
+
+	nodeSessionsMu.Lock()
+	sess := nodeSessions[name]
+	nodeSessionsMu.Unlock()
+
+	trackedMsgsMu.Lock()
+	defer trackedMsgsMu.Unlock()
+	trackedMsgs = append(trackedMsgs, &trackedMsg{
+		cid:      msgCid,
+		from:     msg.From,
+		nonce:    msg.Nonce,
+		node:     name,
+		tag:      tag,
+		session:  sess,
+		pushedAt: time.Now(),
+	})
+	if over := len(trackedMsgs) - msgTrackMaxLen; over > 0 {
+		trackedMsgs = trackedMsgs[over:]
+		msgLifecycleCounts["untracked"] += over
+	}
+}
+
+// nodeNameOf maps an RPC client back to its node name.
+func nodeNameOf(node api.FullNode) string {
+	for name, n := range nodes {
+		if n == node {
+			return name
+		}
+	}
+	return ""
+}
+
+func startMsgLifecycleTracker() {
+	go func() {
+		log.Println("[msg-lifecycle] background goroutine started")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(msgTrackInterval):
+				msgLifecycleTick()
+			}
+		}
+	}()
+}
+
+// msgLifecycleTick classifies every tracked message old enough to have
+// propagated, keeping the still-pending ones for the next tick.
+func msgLifecycleTick() {
+	sessions := refreshNodeSessions()
+
+	// Skip during partitions: isolated nodes legitimately can't see each
+	// other's mempools and the sender's nonce may be on a minority fork.
+	if partitionActive.Load() {
+		return
+	}
+
+	trackedMsgsMu.Lock()
+	var due []*trackedMsg
+	var keep []*trackedMsg
+	for _, m := range trackedMsgs {
+		if time.Since(m.pushedAt) >= msgTrackMinAge {
+			due = append(due, m)
+		} else {
+			keep = append(keep, m)
+		}
+	}
+	trackedMsgs = keep
+	trackedMsgsMu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	// Mempool first, chain second: a message mined between the two calls is
+	// then found by StateSearchMsg instead of looking lost.
+	pending, allResponded, pressured := pendingAcrossNodes()
+	if pressured {
+		mpoolPressureAt = time.Now()
+	}
+
+	var still []*trackedMsg
+	counts := make(map[string]int)
+	for _, m := range due {
+		status := classifyTrackedMsg(m, pending, allResponded, sessions)
+		if status == "" {
+			still = append(still, m)
+			continue
+		}
+		counts[status]++
+	}
+
+	trackedMsgsMu.Lock()
+	trackedMsgs = append(still, trackedMsgs...)
+	for k, v := range counts {
+		msgLifecycleCounts[k] += v
+	}
+	total := len(trackedMsgs)
+	trackedMsgsMu.Unlock()
+
+	assert.Sometimes(counts["included"] > 0, "Tracked messages reach the chain", map[string]any{
+		"classified": counts,
+		"tracking":   total,
+	})
+	debugLog("[msg-lifecycle] tick: classified=%v still_tracking=%d", counts, total)
+}
+
+// classifyTrackedMsg returns the terminal status for m, or "" to keep
+// tracking it.
+func classifyTrackedMsg(m *trackedMsg, pending mempoolView, allResponded bool, sessions map[string]uuid.UUID) string {
+	age := time.Since(m.pushedAt)
+
+	if pending.cids[m.cid] || pending.slots[nonceSlot{m.from, m.nonce}] {
+		m.misses = 0
+		if age > msgTrackTTL {
+			log.Printf("[msg-lifecycle] expired: %s from=%s nonce=%d tag=%s still pending after %s",
+				cidStr(m.cid), m.from, m.nonce, m.tag, age.Round(time.Second))
+			return "expired"
+		}
+		return ""
+	}
+
+	if msgOnChain(m) {
+		return "included"
+	}
+
+	chainNonce, ok := chainNonceOf(m.from)
+	if !ok {
+		return ""
+	}
+	if chainNonce > m.nonce {
+		debugLog("[msg-lifecycle] replaced: %s from=%s nonce=%d chain_nonce=%d tag=%s",
+			cidStr(m.cid), m.from, m.nonce, chainNonce, m.tag)
+		assert.Reachable("Tracked message superseded by same-nonce message", map[string]any{
+			"tag": m.tag,
+		})
+		return "replaced"
+	}
+
+	restarted := m.session == uuid.Nil || sessions[m.node] != m.session
+	if !allResponded || restarted {
+		// Can't tell lost from hidden-by-fault; give it until the TTL.
+		if age > msgTrackTTL {
+			return "expired"
+		}
+		return ""
+	}
+
+	m.misses++
+	if m.misses < msgLostMisses {
+		return ""
+	}
+
+	if mpoolPressureAt.After(m.pushedAt) {
+		debugLog("[msg-lifecycle] pruned?: %s from=%s nonce=%d tag=%s gone after mempool pressure",
+			cidStr(m.cid), m.from, m.nonce, m.tag)
+		return "pruned"
+	}
+
+	assert.Always(false, "Accepted message is never silently lost", map[string]any{
+		"cid":         m.cid.String(),
+		"from":        m.from.String(),
+		"nonce":       m.nonce,
+		"chain_nonce": chainNonce,
+		"node":        m.node,
+		"node_type":   nodeType(m.node),
+		"tag":         m.tag,
+		"age_sec":     int(age.Seconds()),
+	})
+	log.Printf("[msg-lifecycle] LOST: %s from=%s nonce=%d (chain_nonce=%d) pushed via %s tag=%s age=%s",
+		m.cid, m.from, m.nonce, chainNonce, m.node, m.tag, age.Round(time.Second))
+	return "lost"
+}
+
+// pendingAcrossNodes returns the union of every node's mempool. The first
+// bool is false if any node failed to answer; the second is true if any
+// node's mempool is within 10% of the low watermark pruning trims it to.
+func pendingAcrossNodes() (mempoolView, bool, bool) {
+	out := mempoolView{cids: make(map[cid.Cid]bool), slots: make(map[nonceSlot]bool)}
+	all, pressured := true, false
+	for _, name := range nodeKeys {
+		msgs, err := nodes[name].MpoolPending(ctx, types.EmptyTSK)
+		if err != nil {
+			debugLog("[msg-lifecycle] MpoolPending failed on %s: %v", name, err)
+			all = false
+			continue
+		}
+		for _, sm := range msgs {
+			out.cids[sm.Cid()] = true
+			out.slots[nonceSlot{sm.Message.From, sm.Message.Nonce}] = true
+		}
+		low := msgPruneLowDefault
+		if cfg, err := nodes[name].MpoolGetConfig(ctx); err == nil && cfg.SizeLimitLow > 0 {
+			low = cfg.SizeLimitLow
+		}
+		if len(msgs) >= low-low/10 {
+			pressured = true
+		}
+	}
+	return out, all, pressured
+}
+
+// msgOnChain searches for m's exact CID, starting with the node it was
+// pushed to.
+func msgOnChain(m *trackedMsg) bool {
+	order := append([]string{m.node}, nodeKeys...)
+	for _, name := range order {
+		n, ok := nodes[name]
+		if !ok {
+			continue
+		}
+		lookup, err := n.StateSearchMsg(ctx, types.EmptyTSK, m.cid, msgSearchLookback, false)
+		if err == nil && lookup != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// chainNonceOf returns the highest sender nonce any node reports at its head.
+func chainNonceOf(from address.Address) (uint64, bool) {
+	var best uint64
+	found := false
+	for _, name := range nodeKeys {
+		act, err := nodes[name].StateGetActor(ctx, from, types.EmptyTSK)
+		if err != nil {
+			continue
+		}
+		if !found || act.Nonce > best {
+			best = act.Nonce
+		}
+		found = true
+	}
+	return best, found
+}
+
+// refreshNodeSessions records each node's current Session UUID. A node that
+// restarted reports a new one.
+func refreshNodeSessions() map[string]uuid.UUID {
+	out := make(map[string]uuid.UUID)
+	for _, name := range nodeKeys {
+		if s, err := nodes[name].Session(ctx); err == nil {
+			out[name] = s
+		}
+	}
+	nodeSessionsMu.Lock()
+	for name, s := range out {
+		nodeSessions[name] = s
+	}
+	nodeSessionsMu.Unlock()
+	return out
+}
+
+// logMsgLifecycleSummary prints cumulative classification counts.
+func logMsgLifecycleSummary() {
+	trackedMsgsMu.Lock()
+	defer trackedMsgsMu.Unlock()
+	log.Printf("[msg-lifecycle] totals=%v tracking=%d", msgLifecycleCounts, len(trackedMsgs))
+}
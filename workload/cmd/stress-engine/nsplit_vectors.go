@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"math/big"
+	"os"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -58,9 +60,38 @@ const (
 	splitFullIsolation splitStrategy = iota // one miner disconnected from all
 	splitStar                              // hub miner connected to all, honest miners isolated from each other
 	splitBisection                         // network split into two ~equal halves
+	splitTopology                          // declarative spec from STRESS_PARTITION_TOPOLOGIES
 	splitCount                             // sentinel for rotation
 )
 
+// partitionTopologySpecs are the "||"-separated topology specs (see
+// topology.go) rotated through by splitTopology. "/" can't separate them: it
+// appears in the one-way "-/>" operator. Empty leaves splitTopology out of
+// the rotation.
+var partitionTopologySpecs = splitTopologySpecs(os.Getenv("STRESS_PARTITION_TOPOLOGIES"))
+
+func splitTopologySpecs(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, "||") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// activeSplitStrategies returns the strategies in the cycle rotation.
+func activeSplitStrategies() []splitStrategy {
+	var out []splitStrategy
+	for s := splitStrategy(0); s < splitCount; s++ {
+		if s == splitTopology && len(partitionTopologySpecs) == 0 {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
 func (s splitStrategy) String() string {
 	switch s {
 	case splitFullIsolation:
@@ -69,19 +100,21 @@ func (s splitStrategy) String() string {
 		return "star-split"
 	case splitBisection:
 		return "50/50-bisection"
+	case splitTopology:
+		return "topology"
 	default:
 		return "unknown"
 	}
 }
 
-// attackType enumerates the adversarial strategies.
+// attackType enumerates the transfer attacks; each is registered in
+// attackRegistry (attacks.go) alongside the non-transfer attacks.
 type attackType int
 
 const (
 	attackDoubleSpend        attackType = iota // same nonce, different recipients
 	attackGasPremiumFrontrun                   // same nonce, different gas premiums
 	attackBalanceExhaustion                    // same nonce, full balance to different recipients
-	attackCount                                // sentinel for rotation
 )
 
 func (a attackType) String() string {
@@ -117,11 +150,12 @@ type splitResult struct {
 	ecVulnerable  bool
 	f3HasQuorum   bool
 	expected      string
+	topology      *appliedTopology // splitTopology only: healed via healTopology
 }
 
 // attackResult captures the injected attack for verification.
 type attackResult struct {
-	attack     attackType
+	attack     Attack
 	fromAddr   address.Address
 	nonce      uint64
 	cidA       cid.Cid         // sent to honest node
@@ -130,6 +164,8 @@ type attackResult struct {
 	advNode    string
 	amount     abi.TokenAmount // transfer amount for balance verification
 	preBalance abi.TokenAmount // sender balance snapshot before attack
+	aLanded    bool            // set by verifyOutcome
+	bLanded    bool
 }
 
 // ---------------------------------------------------------------------------
@@ -182,14 +218,23 @@ func startConsensusTestLifecycle() {
 			time.Sleep(10 * time.Second)
 		}
 
-		cycleNum := 0
+		// YAML scenarios, if any, alternate with the built-in cycles.
+		scenarios := loadScenarios(scenarioDir)
+
+		cycleNum, scenarioNum, turn := 0, 0, 0
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			default:
-				cycleNum++
-				runConsensusCycle(cycleNum)
+				turn++
+				if len(scenarios) > 0 && turn%2 == 0 {
+					scenarioNum++
+					runScenario(scenarios[(scenarioNum-1)%len(scenarios)], scenarioNum)
+				} else {
+					cycleNum++
+					runConsensusCycle(cycleNum)
+				}
 				time.Sleep(testCooldown)
 			}
 		}
@@ -215,9 +260,11 @@ func runConsensusCycle(cycleNum int) {
 		return
 	}
 
-	// Rotate strategy and attack across cycles (3 splits × 3 attacks = 9 combinations)
-	split := splitStrategy(cycleNum % int(splitCount))
-	attack := attackType((cycleNum / int(splitCount)) % int(attackCount))
+	// Rotate strategy and attack across cycles (splits × registered attacks)
+	strategies := activeSplitStrategies()
+	split := strategies[cycleNum%len(strategies)]
+	attacks := activeAttacks()
+	attack := attacks[(cycleNum/len(strategies))%len(attacks)]
 
 	// --- Header ---
 	log.Printf("[consensus-test] === CYCLE %d === strategy=%s attack=%s f3=%v", cycleNum, split, attack, f3Active)
@@ -225,6 +272,12 @@ func runConsensusCycle(cycleNum int) {
 		log.Printf("[consensus-test]   %s: %.1f%% power", minerToNodeName(m.addr), m.pct)
 	}
 
+	// --- Prepare attack state while the network is whole ---
+	if !attack.prepare(lotusNode) {
+		log.Printf("[consensus-test] %s preparation failed, skipping cycle", attack)
+		return
+	}
+
 	// --- Snapshot ---
 	var preF3Inst uint64
 	if f3Active {
@@ -234,6 +287,7 @@ func runConsensusCycle(cycleNum int) {
 	if err != nil {
 		return
 	}
+	defer exportForkGraph(fmt.Sprintf("consensus-cycle-%03d-%s", cycleNum, split), preHead.Height()-forkGraphExportMargin)
 
 	// --- Create partition based on strategy ---
 	sr := createPartition(split, table, f3Active)
@@ -253,7 +307,7 @@ func runConsensusCycle(cycleNum int) {
 	waitForDivergence(sr.honestNode, sr.adversaryName, sr.advNode)
 
 	// --- Inject attack ---
-	ar := injectAttack(attack, sr.honestNode, sr.adversaryName, sr.advNode)
+	ar := attack.inject(sr.honestNode, sr.adversaryName, sr.advNode)
 	if ar == nil {
 		log.Printf("[consensus-test] attack injection failed, healing")
 		partitionActive.Store(false)
@@ -308,7 +362,7 @@ func runConsensusCycle(cycleNum int) {
 	// --- Verify on honest-side node (not random — avoids adversary's divergent view) ---
 	verifyNode := nodes[sr.honestNode]
 	landed := verifyOutcome(verifyNode, ar, cycleNum, sr, postF3Active, attack)
-	verifyEconomicImpact(verifyNode, ar, sr, landed)
+	attack.economicImpact(verifyNode, ar, sr, landed)
 
 	// --- F3 health ---
 	if f3Active {
@@ -363,6 +417,8 @@ func createPartition(split splitStrategy, table []minerPowerInfo, f3Active bool)
 		return createStarSplit(table, f3Active)
 	case splitBisection:
 		return createBisection(table, f3Active)
+	case splitTopology:
+		return createTopologySplit(table, f3Active)
 	default:
 		return nil
 	}
@@ -793,8 +849,91 @@ func createBisection(table []minerPowerInfo, f3Active bool) *splitResult {
 	}
 }
 
+// topologyIdx rotates through partitionTopologySpecs across cycles.
+var topologyIdx int
+
+// createTopologySplit applies the next configured topology spec. The
+// connected component holding the most power is the honest side; the
+// strongest other component is the adversary, represented by its largest
+// miner. Components beyond those two take part but are not scored.
+func createTopologySplit(table []minerPowerInfo, f3Active bool) *splitResult {
+	spec := partitionTopologySpecs[topologyIdx%len(partitionTopologySpecs)]
+	topologyIdx++
+	t, err := parseTopology(spec)
+	if err != nil {
+		log.Printf("[consensus-test] topology %q: %v", spec, err)
+		return nil
+	}
+	comps := t.components()
+	if len(comps) < 2 {
+		log.Printf("[consensus-test] topology %q does not partition the network", spec)
+		return nil
+	}
+
+	pct := make(map[string]float64)
+	for _, m := range table {
+		if name := minerToNodeName(m.addr); name != "" {
+			pct[name] = m.pct
+		}
+	}
+	power := make([]float64, len(comps))
+	for i, c := range comps {
+		for _, n := range c {
+			power[i] += pct[n]
+		}
+	}
+	honest, adv := 0, -1
+	for i := range comps {
+		if power[i] > power[honest] {
+			honest = i
+		}
+	}
+	for i := range comps {
+		if i != honest && (adv < 0 || power[i] > power[adv]) {
+			adv = i
+		}
+	}
+	advName := comps[adv][0]
+	for _, n := range comps[adv] {
+		if pct[n] > pct[advName] {
+			advName = n
+		}
+	}
+	honestNode := comps[honest][0]
+	for _, n := range comps[honest] {
+		if nodeType(n) == "lotus" {
+			honestNode = n
+			break
+		}
+	}
+
+	at := applyTopology(t)
+	f3Quorum := f3Active && power[honest] > f3QuorumPct
+	log.Printf("[consensus-test] topology %q: honest=%v (%.1f%%) adversary=%v (%.1f%%), verified=%v",
+		spec, comps[honest], power[honest], comps[adv], power[adv], at.verified)
+
+	return &splitResult{
+		strategy:      splitTopology,
+		adversaryName: advName,
+		adversaryPct:  power[adv],
+		honestPct:     power[honest],
+		honestNode:    honestNode,
+		advNode:       nodes[advName],
+		blocked:       at.blocked,
+		ecVulnerable:  power[adv] >= ecThresholdPct,
+		f3HasQuorum:   f3Quorum,
+		expected:      classifyExpected(power[adv], f3Quorum),
+		topology:      at,
+	}
+}
+
 // healPartition removes blocklist entries and reconnects all nodes.
 func healPartition(sr *splitResult) {
+	if sr.topology != nil {
+		healTopology(sr.topology)
+		return
+	}
+
 	// Step 1: Remove all blocklist entries added during partition
 	// Group by node to batch removals
 	nodeBlocked := map[string][]peer.ID{}
@@ -955,7 +1094,7 @@ func injectAttack(attack attackType, honestName, advName string, advNode api.Ful
 // ---------------------------------------------------------------------------
 
 func verifyOutcome(refNode api.FullNode, ar *attackResult, cycleNum int,
-	sr *splitResult, f3Active bool, attack attackType) int {
+	sr *splitResult, f3Active bool, attack Attack) int {
 
 	log.Printf("[consensus-test] verifying outcome...")
 
@@ -963,18 +1102,8 @@ func verifyOutcome(refNode api.FullNode, ar *attackResult, cycleNum int,
 	// and finalized. More reliable than a fixed sleep.
 	waitForFinalizedAdvance(refNode, 5, 2*time.Minute)
 
-	finalA, _ := refNode.StateSearchMsg(ctx, types.EmptyTSK, ar.cidA, 200, false)
-	finalB, _ := refNode.StateSearchMsg(ctx, types.EmptyTSK, ar.cidB, 200, false)
-
-	aLanded := finalA != nil && finalA.Receipt.ExitCode.IsSuccess()
-	bLanded := finalB != nil && finalB.Receipt.ExitCode.IsSuccess()
-
-	if finalA != nil && !finalA.Receipt.ExitCode.IsSuccess() {
-		log.Printf("[consensus-test]   tx A included but reverted (exit=%d)", finalA.Receipt.ExitCode)
-	}
-	if finalB != nil && !finalB.Receipt.ExitCode.IsSuccess() {
-		log.Printf("[consensus-test]   tx B included but reverted (exit=%d)", finalB.Receipt.ExitCode)
-	}
+	aLanded, bLanded := attack.landed(refNode, ar)
+	ar.aLanded, ar.bLanded = aLanded, bLanded
 	landed := 0
 	if aLanded {
 		landed++
@@ -983,8 +1112,8 @@ func verifyOutcome(refNode api.FullNode, ar *attackResult, cycleNum int,
 		landed++
 	}
 
-	log.Printf("[consensus-test]   tx A (honest):    landed=%v %s", aLanded, fmtHeight(finalA))
-	log.Printf("[consensus-test]   tx B (adversary): landed=%v %s", bLanded, fmtHeight(finalB))
+	log.Printf("[consensus-test]   tx A (honest):    landed=%v", aLanded)
+	log.Printf("[consensus-test]   tx B (adversary): landed=%v", bLanded)
 	log.Printf("[consensus-test]   total: %d/2", landed)
 
 	details := map[string]any{
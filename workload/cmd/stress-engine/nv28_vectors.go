@@ -34,6 +34,10 @@ const (
 	fip0115RiseFloorPct     = 110  // peak >= pre * 1.10 to count as "rose" (10% rise)
 )
 
+func init() {
+	ExpectUpgrade(network.Version28)
+}
+
 func DoFIP0115BaseFeeResponse() {
 	if partitionActive.Load() {
 		return
@@ -106,7 +110,7 @@ func DoFIP0115BaseFeeResponse() {
 	}
 }
 
-// findBoundary returns the configured upgrade boundary by name, or nil.
+// findBoundary returns the discovered upgrade boundary by name, or nil.
 func findBoundary(name string) *upgradeBoundary {
 	for i := range upgradeBoundaries {
 		if upgradeBoundaries[i].Name == name {
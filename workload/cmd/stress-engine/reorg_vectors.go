@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"time"
 
@@ -113,6 +114,11 @@ func DoReorgChaos() {
 		preF3Inst, f3ok = getF3Instance(lotusNode)
 	}
 
+	var startHeight abi.ChainEpoch
+	if head, err := victim.ChainHead(ctx); err == nil {
+		startHeight = head.Height()
+	}
+
 	// Collect known node addresses for reliable reconnection
 	knownPeers := collectNodeAddrInfos(victimName)
 
@@ -217,6 +223,7 @@ func DoReorgChaos() {
 	if successfulCycles == 0 {
 		return
 	}
+	defer exportForkGraph(fmt.Sprintf("reorg-chaos-%s-%d", victimName, time.Now().Unix()), startHeight-forkGraphExportMargin)
 
 	// Full mesh reconnect: Antithesis fault injection can sever connections
 	// between ANY nodes (not just the victim), so after all cycles we must
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/ipfs/go-cid"
+	"gopkg.in/yaml.v3"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ===========================================================================
+// Scenario DSL — YAML-authored multi-step consensus tests
+//
+// runConsensusCycle hardcodes partition → diverge → inject → heal → verify.
+// Scenarios spell out the same kind of test as a list of steps built from
+// the existing helpers, so new adversarial shapes need no Go changes:
+//
+//   name: bisection-double-spend
+//   steps:
+//     - op: partition
+//       spec: "lotus0,lotus3 | lotus1,lotus2,forest0,forest1"
+//     - op: wait_divergence
+//       honest: lotus1
+//       adversary: lotus0
+//     - op: double_spend
+//       honest: lotus1
+//       adversary: lotus0
+//       as: ds
+//     - op: wait_mined
+//       ref: ds
+//     - op: heal
+//     - op: assert_landed
+//       ref: ds
+//       max: 1
+//
+// Ops:
+//   partition            spec: topology spec (see topology.go)
+//   heal                 undo the partition, restore full mesh, wait for convergence
+//   wait_epochs          epochs, node (default first node), timeout
+//   wait_divergence      honest, adversary
+//   wait_mined           ref, timeout — until every tx under ref is on some chain
+//   push_tx              node, amount, as — transfer from an attack-reserved wallet
+//   double_spend         honest, adversary, as — same nonce, different recipients
+//   slash                miner (node name, e.g. lotus1) — ReportConsensusFault
+//   assert_landed        ref, node, min, max — txs under ref landed successfully
+//   assert_f3_finalized  ref, node, timeout — F3 finalizes past ref's inclusion
+//                        (or, without ref, past the scenario's start)
+//
+// Assertions are Always unless the step sets sometimes: true; message
+// overrides the default "Scenario <name>: <op> ..." text. A failing
+// non-assert step aborts the scenario; a partition is always healed.
+//
+// Scenarios are loaded from *.yaml in STRESS_SCENARIO_DIR and interleaved
+// with the built-in cycles by the consensus test lifecycle.
+// ===========================================================================
+
+const (
+	scenarioDefaultTimeout = 5 * time.Minute
+	scenarioPollInterval   = 4 * time.Second
+)
+
+var scenarioDir = envOrDefault("STRESS_SCENARIO_DIR", "/opt/antithesis/scenarios")
+
+type scenario struct {
+	Name  string         `yaml:"name"`
+	Steps []scenarioStep `yaml:"steps"`
+	file  string
+}
+
+type scenarioStep struct {
+	Op        string `yaml:"op"`
+	Spec      string `yaml:"spec"`
+	Node      string `yaml:"node"`
+	Honest    string `yaml:"honest"`
+	Adversary string `yaml:"adversary"`
+	Miner     string `yaml:"miner"`
+	Epochs    int    `yaml:"epochs"`
+	Amount    int64  `yaml:"amount"`
+	As        string `yaml:"as"`
+	Ref       string `yaml:"ref"`
+	Min       *int   `yaml:"min"`
+	Max       *int   `yaml:"max"`
+	Timeout   string `yaml:"timeout"`
+	Sometimes bool   `yaml:"sometimes"`
+	Message   string `yaml:"message"`
+}
+
+// scenarioTx is a labelled group of pushed messages.
+type scenarioTx struct {
+	cids   []cid.Cid
+	nodes  []string // node each cid was pushed to
+	honest string   // preferred reference node for verification
+}
+
+// scenarioRun is the mutable state of one scenario execution.
+type scenarioRun struct {
+	sc          *scenario
+	topo        *appliedTopology
+	partitioned bool
+	startHeight abi.ChainEpoch
+	txs         map[string]*scenarioTx
+}
+
+var scenarioOps = map[string]func(*scenarioRun, scenarioStep) error{
+	"partition":           scenarioPartition,
+	"heal":                scenarioHeal,
+	"wait_epochs":         scenarioWaitEpochs,
+	"wait_divergence":     scenarioWaitDivergence,
+	"wait_mined":          scenarioWaitMined,
+	"push_tx":             scenarioPushTx,
+	"double_spend":        scenarioDoubleSpend,
+	"slash":               scenarioSlash,
+	"assert_landed":       scenarioAssertLanded,
+	"assert_f3_finalized": scenarioAssertF3Finalized,
+}
+
+// loadScenarios parses every *.yaml in dir. Invalid files are logged and
+// skipped; node names are checked against the connected nodes.
+func loadScenarios(dir string) []*scenario {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	sort.Strings(files)
+	var out []*scenario
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			log.Printf("[scenario] read %s: %v", f, err)
+			continue
+		}
+		sc := &scenario{file: f}
+		if err := yaml.Unmarshal(data, sc); err != nil {
+			log.Printf("[scenario] parse %s: %v", f, err)
+			continue
+		}
+		if sc.Name == "" {
+			sc.Name = strings.TrimSuffix(filepath.Base(f), ".yaml")
+		}
+		if err := sc.validate(); err != nil {
+			log.Printf("[scenario] %s invalid: %v", f, err)
+			continue
+		}
+		out = append(out, sc)
+	}
+	if len(out) > 0 {
+		log.Printf("[scenario] loaded %d scenario(s) from %s", len(out), dir)
+	}
+	return out
+}
+
+func (sc *scenario) validate() error {
+	if len(sc.Steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+	for i, st := range sc.Steps {
+		if _, ok := scenarioOps[st.Op]; !ok {
+			return fmt.Errorf("step %d: unknown op %q", i+1, st.Op)
+		}
+		for _, n := range []string{st.Node, st.Honest, st.Adversary, st.Miner} {
+			if _, ok := nodes[n]; n != "" && !ok {
+				return fmt.Errorf("step %d: unknown node %q", i+1, n)
+			}
+		}
+		if st.Op == "partition" {
+			if _, err := parseTopology(st.Spec); err != nil {
+				return fmt.Errorf("step %d: %v", i+1, err)
+			}
+		}
+		if st.Timeout != "" {
+			if _, err := time.ParseDuration(st.Timeout); err != nil {
+				return fmt.Errorf("step %d: %v", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runScenario executes sc step by step, healing any partition it leaves
+// behind and exporting the fork graph for the run.
+func runScenario(sc *scenario, runNum int) {
+	r := &scenarioRun{sc: sc, txs: make(map[string]*scenarioTx)}
+	if head, err := nodes[nodeKeys[0]].ChainHead(ctx); err == nil {
+		r.startHeight = head.Height()
+	}
+	log.Printf("[scenario] === %s (run %d, %d steps, %s) ===", sc.Name, runNum, len(sc.Steps), sc.file)
+
+	defer exportForkGraph(fmt.Sprintf("scenario-%03d-%s", runNum, sc.Name), r.startHeight-forkGraphExportMargin)
+	defer func() {
+		if r.partitioned {
+			log.Printf("[scenario] %s: healing partition left open", sc.Name)
+			scenarioHeal(r, scenarioStep{})
+		}
+	}()
+
+	for i, st := range sc.Steps {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[scenario] %s step %d/%d: %s", sc.Name, i+1, len(sc.Steps), st.describe())
+		if err := scenarioOps[st.Op](r, st); err != nil {
+			log.Printf("[scenario] %s aborted at step %d (%s): %v", sc.Name, i+1, st.Op, err)
+			assert.Sometimes(false, "Scenario steps complete", map[string]any{
+				"scenario": sc.Name, "step": i + 1, "op": st.Op, "error": err.Error(),
+			})
+			return
+		}
+	}
+	assert.Sometimes(true, "Scenario steps complete", map[string]any{"scenario": sc.Name})
+	log.Printf("[scenario] %s completed", sc.Name)
+}
+
+func (st scenarioStep) describe() string {
+	parts := []string{st.Op}
+	add := func(k, v string) {
+		if v != "" {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	add("spec", st.Spec)
+	add("node", st.Node)
+	add("honest", st.Honest)
+	add("adversary", st.Adversary)
+	add("miner", st.Miner)
+	add("ref", st.Ref)
+	add("as", st.As)
+	if st.Epochs > 0 {
+		add("epochs", fmt.Sprint(st.Epochs))
+	}
+	if st.Min != nil {
+		add("min", fmt.Sprint(*st.Min))
+	}
+	if st.Max != nil {
+		add("max", fmt.Sprint(*st.Max))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (st scenarioStep) timeout() time.Duration {
+	if d, err := time.ParseDuration(st.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return scenarioDefaultTimeout
+}
+
+// assert raises the step's assertion, Always unless sometimes: true.
+func (r *scenarioRun) assert(st scenarioStep, cond bool, details map[string]any) {
+	msg := st.Message
+	if msg == "" {
+		msg = fmt.Sprintf("Scenario %s: %s", r.sc.Name, st.describe())
+	}
+	details["scenario"] = r.sc.Name
+	if st.Sometimes {
+		assert.Sometimes(cond, msg, details)
+	} else {
+		assert.Always(cond, msg, details)
+	}
+	log.Printf("[scenario] %s: %s → %v", r.sc.Name, st.describe(), cond)
+}
+
+// ---------------------------------------------------------------------------
+// Ops
+// ---------------------------------------------------------------------------
+
+func scenarioPartition(r *scenarioRun, st scenarioStep) error {
+	if r.partitioned {
+		return fmt.Errorf("already partitioned; heal first")
+	}
+	t, err := parseTopology(st.Spec)
+	if err != nil {
+		return err
+	}
+	partitionActive.Store(true)
+	r.partitioned = true
+	r.topo = applyTopology(t)
+	return nil
+}
+
+func scenarioHeal(r *scenarioRun, st scenarioStep) error {
+	if r.topo != nil {
+		healTopology(r.topo)
+	} else {
+		ensureFullMesh()
+	}
+	converged := waitForConvergence(st.Node)
+	partitionActive.Store(false)
+	r.partitioned = false
+	r.topo = nil
+	log.Printf("[scenario] %s: healed, converged=%v", r.sc.Name, converged)
+	return nil
+}
+
+func scenarioWaitEpochs(r *scenarioRun, st scenarioStep) error {
+	name := st.Node
+	if name == "" {
+		name = nodeKeys[0]
+	}
+	start, err := nodes[name].ChainHead(ctx)
+	if err != nil {
+		return err
+	}
+	target := start.Height() + abi.ChainEpoch(st.Epochs)
+	deadline := time.Now().Add(st.timeout())
+	for time.Now().Before(deadline) {
+		if head, err := nodes[name].ChainHead(ctx); err == nil && head.Height() >= target {
+			return nil
+		}
+		time.Sleep(scenarioPollInterval)
+	}
+	return fmt.Errorf("%s did not reach epoch %d within %s", name, target, st.timeout())
+}
+
+func scenarioWaitDivergence(r *scenarioRun, st scenarioStep) error {
+	if st.Honest == "" || st.Adversary == "" {
+		return fmt.Errorf("wait_divergence needs honest and adversary")
+	}
+	waitForDivergence(st.Honest, st.Adversary, nodes[st.Adversary])
+	return nil
+}
+
+func scenarioWaitMined(r *scenarioRun, st scenarioStep) error {
+	tx, ok := r.txs[st.Ref]
+	if !ok {
+		return fmt.Errorf("unknown ref %q", st.Ref)
+	}
+	deadline := time.Now().Add(st.timeout())
+	for time.Now().Before(deadline) {
+		mined := 0
+		for i, c := range tx.cids {
+			if l, _ := nodes[tx.nodes[i]].StateSearchMsg(ctx, types.EmptyTSK, c, 50, false); l != nil {
+				mined++
+			}
+		}
+		if mined == len(tx.cids) {
+			return nil
+		}
+		time.Sleep(scenarioPollInterval)
+	}
+	// Not mining is an outcome worth asserting on later, not a step failure.
+	log.Printf("[scenario] %s: wait_mined %s timed out", r.sc.Name, st.Ref)
+	return nil
+}
+
+func scenarioPushTx(r *scenarioRun, st scenarioStep) error {
+	name := st.Node
+	if name == "" {
+		name, _ = pickNode()
+	}
+	from, ki := pickAttackWallet()
+	to, _ := pickWallet()
+	amount := st.Amount
+	if amount <= 0 {
+		amount = 1000
+	}
+	nonce, err := nodes[name].MpoolGetNonce(ctx, from)
+	if err != nil {
+		return err
+	}
+	msg := baseMsg(from, to, abi.NewTokenAmount(amount))
+	estimateGas(nodes[name], msg, "scenario")
+	c, ok := pushMsgManualNonce(nodes[name], msg, ki, nonce, "scenario")
+	if !ok {
+		return fmt.Errorf("push to %s failed", name)
+	}
+	nonces[from] = nonce + 1
+	r.record(st.As, c, name, name)
+	return nil
+}
+
+func scenarioDoubleSpend(r *scenarioRun, st scenarioStep) error {
+	if st.Honest == "" || st.Adversary == "" {
+		return fmt.Errorf("double_spend needs honest and adversary")
+	}
+	ar := injectAttack(attackDoubleSpend, st.Honest, st.Adversary, nodes[st.Adversary])
+	if ar == nil {
+		return fmt.Errorf("injection failed")
+	}
+	r.record(st.As, ar.cidA, st.Honest, st.Honest)
+	r.record(st.As, ar.cidB, st.Adversary, st.Honest)
+	return nil
+}
+
+func scenarioSlash(r *scenarioRun, st scenarioStep) error {
+	var id uint64
+	if _, err := fmt.Sscanf(st.Miner, "lotus%d", &id); err != nil {
+		return fmt.Errorf("slash needs a lotus miner node, got %q", st.Miner)
+	}
+	target, err := address.NewIDAddress(1000 + id)
+	if err != nil {
+		return err
+	}
+	lotusNode, lotusName := pickLotusNode()
+	if lotusNode == nil {
+		return fmt.Errorf("no lotus node")
+	}
+	if !submitConsensusFault(lotusNode, lotusName, target) {
+		return fmt.Errorf("consensus fault against %s did not land", target)
+	}
+	return nil
+}
+
+func scenarioAssertLanded(r *scenarioRun, st scenarioStep) error {
+	tx, ok := r.txs[st.Ref]
+	if !ok {
+		return fmt.Errorf("unknown ref %q", st.Ref)
+	}
+	refName := st.Node
+	if refName == "" {
+		refName = tx.honest
+	}
+	refNode := nodes[refName]
+	waitForFinalizedAdvance(refNode, 5, 2*time.Minute)
+
+	landed := 0
+	for _, c := range tx.cids {
+		if l, _ := refNode.StateSearchMsg(ctx, types.EmptyTSK, c, 200, false); l != nil && l.Receipt.ExitCode.IsSuccess() {
+			landed++
+		}
+	}
+	lo, hi := 1, len(tx.cids)
+	if st.Min != nil {
+		lo = *st.Min
+	}
+	if st.Max != nil {
+		hi = *st.Max
+		if st.Min == nil {
+			lo = 0
+		}
+	}
+	r.assert(st, landed >= lo && landed <= hi, map[string]any{
+		"ref":    st.Ref,
+		"node":   refName,
+		"landed": landed,
+		"sent":   len(tx.cids),
+		"min":    lo,
+		"max":    hi,
+	})
+	return nil
+}
+
+func scenarioAssertF3Finalized(r *scenarioRun, st scenarioStep) error {
+	var node api.FullNode
+	name := st.Node
+	if name == "" {
+		node, name = pickLotusNode()
+	} else {
+		node = nodes[name]
+	}
+	if node == nil {
+		return fmt.Errorf("no node for F3 check")
+	}
+
+	target := r.startHeight
+	if st.Ref != "" {
+		tx, ok := r.txs[st.Ref]
+		if !ok {
+			return fmt.Errorf("unknown ref %q", st.Ref)
+		}
+		target = -1
+		for _, c := range tx.cids {
+			if l, _ := node.StateSearchMsg(ctx, types.EmptyTSK, c, 200, false); l != nil && (target < 0 || l.Height < target) {
+				target = l.Height
+			}
+		}
+		if target < 0 {
+			r.assert(st, false, map[string]any{"ref": st.Ref, "node": name, "reason": "no tx under ref is on chain"})
+			return nil
+		}
+	}
+
+	var finalized abi.ChainEpoch = -1
+	deadline := time.Now().Add(st.timeout())
+	for {
+		if cert, err := node.F3GetLatestCertificate(ctx); err == nil && cert != nil && !cert.ECChain.IsZero() {
+			finalized = abi.ChainEpoch(cert.ECChain.Head().Epoch)
+		}
+		if finalized >= target || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(scenarioPollInterval)
+	}
+	r.assert(st, finalized >= target, map[string]any{
+		"ref":       st.Ref,
+		"node":      name,
+		"target":    target,
+		"finalized": finalized,
+		"timeout":   st.timeout().String(),
+	})
+	return nil
+}
+
+func (r *scenarioRun) record(label string, c cid.Cid, node, honest string) {
+	if label == "" {
+		return
+	}
+	tx := r.txs[label]
+	if tx == nil {
+		tx = &scenarioTx{honest: honest}
+		r.txs[label] = tx
+	}
+	tx.cids = append(tx.cids, c)
+	tx.nodes = append(tx.nodes, node)
+}
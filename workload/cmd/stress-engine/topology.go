@@ -0,0 +1,422 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/filecoin-project/lotus/api"
+)
+
+// ===========================================================================
+// Declarative partition topologies
+//
+// The three hardcoded split strategies cover isolation, star and bisection.
+// A topology spec describes any other shape as a per-node allow matrix:
+// allow[a][b] means a does not block b. Clauses are separated by ";":
+//
+//   lotus0,lotus1 | lotus2 | forest0,forest1   groups: allowed within a
+//                                              group, blocked across; nodes
+//                                              not listed form one extra group
+//   lotus0: lotus1,forest0                     row: lotus0 allows exactly these
+//   lotus0 -> lotus2                           lotus0 stops blocking lotus2
+//   lotus0 <-> lotus2                          both stop blocking each other
+//   lotus0 -/> lotus1                          lotus0 blocks lotus1 (one-sided)
+//
+// Without a groups clause everyone starts allowed. A libp2p connection needs
+// both ends to accept it, so a pair is expected connected only when both
+// directions allow it; one-sided blocks exercise the blocking side's
+// connection gater alone.
+//
+// applyTopology blocks (NetBlockAdd) and disconnects every disallowed
+// direction on every node, connects every allowed pair, then checks
+// NetPeers on each node against the spec. healTopology removes exactly the
+// blocks it added and restores and verifies the full mesh.
+// ===========================================================================
+
+const (
+	topologyVerifyTimeout = 30 * time.Second
+	topologyVerifyPoll    = 2 * time.Second
+)
+
+// topology is a parsed spec: allow[a][b] for every ordered pair of nodes.
+type topology struct {
+	spec  string
+	allow map[string]map[string]bool
+}
+
+// appliedTopology tracks what applyTopology changed so it can be undone.
+type appliedTopology struct {
+	topo     *topology
+	blocked  []blockedPeer
+	peerIDs  map[string]peer.ID
+	noBlock  map[string]bool // nodes whose NetBlockAdd failed (e.g. Forest)
+	verified bool
+}
+
+// parseTopology parses a spec against the connected nodes. An empty spec is
+// an error: it would silently mean "no partition".
+func parseTopology(spec string) (*topology, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, fmt.Errorf("empty topology spec")
+	}
+	return parseTopologyClauses(spec)
+}
+
+// fullMeshTopology allows every pair.
+func fullMeshTopology() *topology {
+	t, _ := parseTopologyClauses("")
+	return t
+}
+
+func parseTopologyClauses(spec string) (*topology, error) {
+	known := make(map[string]bool, len(nodeKeys))
+	for _, n := range nodeKeys {
+		known[n] = true
+	}
+	names := func(s string) ([]string, error) {
+		var out []string
+		for _, n := range strings.Split(s, ",") {
+			n = strings.TrimSpace(n)
+			if n == "" {
+				continue
+			}
+			if !known[n] {
+				return nil, fmt.Errorf("unknown node %q", n)
+			}
+			out = append(out, n)
+		}
+		return out, nil
+	}
+
+	t := &topology{spec: spec, allow: make(map[string]map[string]bool)}
+	for _, a := range nodeKeys {
+		t.allow[a] = make(map[string]bool)
+		for _, b := range nodeKeys {
+			t.allow[a][b] = a != b
+		}
+	}
+
+	grouped := false
+	for _, clause := range strings.Split(spec, ";") {
+		clause = strings.TrimSpace(clause)
+		switch {
+		case clause == "":
+			continue
+
+		case strings.Contains(clause, "<->"), strings.Contains(clause, "-/>"), strings.Contains(clause, "->"):
+			op := "->"
+			for _, o := range []string{"<->", "-/>"} {
+				if strings.Contains(clause, o) {
+					op = o
+					break
+				}
+			}
+			lhs, rhs, _ := strings.Cut(clause, op)
+			a, b := strings.TrimSpace(lhs), strings.TrimSpace(rhs)
+			if !known[a] || !known[b] || a == b {
+				return nil, fmt.Errorf("bad link %q", clause)
+			}
+			switch op {
+			case "->":
+				t.allow[a][b] = true
+			case "<->":
+				t.allow[a][b], t.allow[b][a] = true, true
+			case "-/>":
+				t.allow[a][b] = false
+			}
+
+		case strings.Contains(clause, ":"):
+			lhs, rhs, _ := strings.Cut(clause, ":")
+			a := strings.TrimSpace(lhs)
+			if !known[a] {
+				return nil, fmt.Errorf("unknown node %q", a)
+			}
+			peers, err := names(rhs)
+			if err != nil {
+				return nil, err
+			}
+			for _, b := range nodeKeys {
+				t.allow[a][b] = false
+			}
+			for _, b := range peers {
+				t.allow[a][b] = b != a
+			}
+
+		default:
+			if grouped {
+				return nil, fmt.Errorf("more than one groups clause")
+			}
+			grouped = true
+			groupOf := make(map[string]int)
+			parts := strings.Split(clause, "|")
+			for i, g := range parts {
+				members, err := names(g)
+				if err != nil {
+					return nil, err
+				}
+				for _, n := range members {
+					if _, dup := groupOf[n]; dup {
+						return nil, fmt.Errorf("node %q in two groups", n)
+					}
+					groupOf[n] = i
+				}
+			}
+			for _, n := range nodeKeys {
+				if _, ok := groupOf[n]; !ok {
+					groupOf[n] = len(parts)
+				}
+			}
+			for _, a := range nodeKeys {
+				for _, b := range nodeKeys {
+					t.allow[a][b] = a != b && groupOf[a] == groupOf[b]
+				}
+			}
+		}
+	}
+	return t, nil
+}
+
+// connected reports whether a and b are expected to hold a connection.
+func (t *topology) connected(a, b string) bool {
+	return t.allow[a][b] && t.allow[b][a]
+}
+
+// components returns the connected components of the symmetric graph,
+// each sorted, largest first.
+func (t *topology) components() [][]string {
+	seen := make(map[string]bool)
+	var out [][]string
+	for _, start := range nodeKeys {
+		if seen[start] {
+			continue
+		}
+		var comp []string
+		queue := []string{start}
+		seen[start] = true
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			comp = append(comp, n)
+			for _, m := range nodeKeys {
+				if !seen[m] && t.connected(n, m) {
+					seen[m] = true
+					queue = append(queue, m)
+				}
+			}
+		}
+		sort.Strings(comp)
+		out = append(out, comp)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i]) > len(out[j]) })
+	return out
+}
+
+// applyTopology enforces t on every node and verifies it via NetPeers.
+func applyTopology(t *topology) *appliedTopology {
+	at := &appliedTopology{
+		topo:    t,
+		peerIDs: make(map[string]peer.ID),
+		noBlock: make(map[string]bool),
+	}
+	for _, name := range nodeKeys {
+		if ai, err := nodes[name].NetAddrsListen(ctx); err == nil {
+			at.peerIDs[name] = ai.ID
+		}
+	}
+
+	for _, a := range nodeKeys {
+		var deny []peer.ID
+		for _, b := range nodeKeys {
+			if a == b || t.allow[a][b] {
+				continue
+			}
+			if pid, ok := at.peerIDs[b]; ok {
+				deny = append(deny, pid)
+			}
+		}
+		if len(deny) == 0 {
+			continue
+		}
+		if err := nodes[a].NetBlockAdd(ctx, api.NetBlockList{Peers: deny}); err != nil {
+			log.Printf("[topology] NetBlockAdd on %s failed (relying on peer-side blocks): %v", a, err)
+			at.noBlock[a] = true
+		} else {
+			for _, pid := range deny {
+				at.blocked = append(at.blocked, blockedPeer{onNode: a, peerID: pid})
+			}
+		}
+		for _, pid := range deny {
+			nodes[a].NetDisconnect(ctx, pid)
+		}
+	}
+
+	// Disconnect from both ends: a node that could not block still drops
+	// the connection even if it is the allowing side.
+	for _, a := range nodeKeys {
+		for _, b := range nodeKeys {
+			if a != b && !t.connected(a, b) {
+				if pid, ok := at.peerIDs[b]; ok {
+					nodes[a].NetDisconnect(ctx, pid)
+				}
+			}
+		}
+	}
+	connectAllowed(t)
+
+	violations, missing := waitForTopology(t, at.peerIDs, at.unenforceable())
+	at.verified = len(violations) == 0
+	details := map[string]any{
+		"spec":          t.spec,
+		"components":    t.components(),
+		"violations":    violations,
+		"missing":       missing,
+		"blocked":       len(at.blocked),
+		"unenforceable": at.unenforceable(),
+	}
+	assert.Sometimes(at.verified, "Declared partition topology holds on every node", details)
+	if at.verified {
+		log.Printf("[topology] applied %q: components=%v blocked=%d missing=%v",
+			t.spec, t.components(), len(at.blocked), missing)
+	} else {
+		log.Printf("[topology] %q NOT enforced: violations=%v missing=%v", t.spec, violations, missing)
+	}
+	return at
+}
+
+// unenforceable lists disallowed pairs where every denying side failed to
+// install its block, so nothing stops the allowed side from redialling.
+func (at *appliedTopology) unenforceable() []string {
+	var out []string
+	for _, a := range nodeKeys {
+		for _, b := range nodeKeys {
+			if a >= b || at.topo.connected(a, b) {
+				continue
+			}
+			aDenies := !at.topo.allow[a][b] && !at.noBlock[a]
+			bDenies := !at.topo.allow[b][a] && !at.noBlock[b]
+			if !aDenies && !bDenies {
+				out = append(out, a+"|"+b)
+			}
+		}
+	}
+	return out
+}
+
+// connectAllowed dials every pair that the topology expects connected.
+func connectAllowed(t *topology) {
+	addrs := make(map[string]peer.AddrInfo)
+	for _, name := range nodeKeys {
+		if ai, err := nodes[name].NetAddrsListen(ctx); err == nil {
+			addrs[name] = ai
+		}
+	}
+	for _, a := range nodeKeys {
+		for _, b := range nodeKeys {
+			if a < b && t.connected(a, b) {
+				if ai, ok := addrs[b]; ok {
+					nodes[a].NetConnect(ctx, ai)
+				}
+			}
+		}
+	}
+}
+
+// waitForTopology polls NetPeers until the observed connections match t or
+// the timeout expires. Returns disallowed pairs still connected (excluding
+// unenforceable ones) and allowed pairs not connected.
+func waitForTopology(t *topology, peerIDs map[string]peer.ID, skip []string) (violations, missing []string) {
+	skipSet := make(map[string]bool, len(skip))
+	for _, s := range skip {
+		skipSet[s] = true
+	}
+	deadline := time.Now().Add(topologyVerifyTimeout)
+	for {
+		violations, missing = nil, nil
+		conns := observedConnections(peerIDs)
+		for _, a := range nodeKeys {
+			for _, b := range nodeKeys {
+				if a >= b {
+					continue
+				}
+				pair := a + "|" + b
+				has := conns[pair]
+				switch {
+				case has && !t.connected(a, b) && !skipSet[pair]:
+					violations = append(violations, pair)
+				case !has && t.connected(a, b):
+					missing = append(missing, pair)
+				}
+			}
+		}
+		if len(violations)+len(missing) == 0 || time.Now().After(deadline) {
+			return violations, missing
+		}
+		if len(missing) > 0 {
+			connectAllowed(t)
+		}
+		time.Sleep(topologyVerifyPoll)
+	}
+}
+
+// observedConnections returns "a|b" (a < b) for every pair that either
+// node reports in NetPeers.
+func observedConnections(peerIDs map[string]peer.ID) map[string]bool {
+	byID := make(map[peer.ID]string, len(peerIDs))
+	for name, pid := range peerIDs {
+		byID[pid] = name
+	}
+	out := make(map[string]bool)
+	for _, a := range nodeKeys {
+		peers, err := nodes[a].NetPeers(ctx)
+		if err != nil {
+			continue
+		}
+		for _, p := range peers {
+			b, ok := byID[p.ID]
+			if !ok || a == b {
+				continue
+			}
+			if a < b {
+				out[a+"|"+b] = true
+			} else {
+				out[b+"|"+a] = true
+			}
+		}
+	}
+	return out
+}
+
+// healTopology removes the blocks applyTopology added, reconnects every
+// node to every other and verifies the full mesh.
+func healTopology(at *appliedTopology) bool {
+	byNode := make(map[string][]peer.ID)
+	for _, bp := range at.blocked {
+		byNode[bp.onNode] = append(byNode[bp.onNode], bp.peerID)
+	}
+	for name, pids := range byNode {
+		if err := nodes[name].NetBlockRemove(ctx, api.NetBlockList{Peers: pids}); err != nil {
+			log.Printf("[topology] NetBlockRemove on %s failed: %v", name, err)
+		}
+	}
+
+	mesh := fullMeshTopology()
+	connectAllowed(mesh)
+	_, missing := waitForTopology(mesh, at.peerIDs, nil)
+
+	assert.Sometimes(len(missing) == 0, "Full mesh restored after topology heal", map[string]any{
+		"spec":    at.topo.spec,
+		"missing": missing,
+	})
+	if len(missing) > 0 {
+		log.Printf("[topology] heal of %q incomplete, unconnected pairs: %v", at.topo.spec, missing)
+	} else {
+		log.Printf("[topology] healed %q, full mesh restored", at.topo.spec)
+	}
+	return len(missing) == 0
+}
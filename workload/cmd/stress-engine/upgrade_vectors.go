@@ -2,17 +2,25 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/antithesishq/antithesis-sdk-go/assert"
+	"github.com/google/uuid"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/builtin/v15/eam"
 	"github.com/filecoin-project/go-state-types/network"
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+
+	"workload/internal/chain"
 )
 
 // ===========================================================================
@@ -28,11 +36,13 @@ import (
 //     - Network version agreement across nodes
 //     - Per-node upgrade activation
 //     - State root agreement at migration epoch±1
+//     - Builtin actor invariants on the post-migration state
 //     - Receipt root consistency at boundary
 //     - Chain progress across boundary (stall detector)
 //     - Boundary-timed message/actor-churn stress
 //
-//   FIP-SPECIFIC — registered by per-upgrade files (e.g. nv28_vectors.go):
+//   FIP-SPECIFIC — registered per network version by per-upgrade files
+//   (e.g. nv28_vectors.go):
 //     - Custom boundary stress functions
 //     - Precompile/opcode tests
 //     - Gas formula validation
@@ -49,31 +59,330 @@ import (
 
 // ---------------------------------------------------------------------------
 // Boundary configuration
+//
+// Boundaries are discovered from every Upgrade<Name>Height key in the raw
+// StateGetNetworkParams().ForkUpgradeParams JSON on every node, so an
+// upgrade the pinned Lotus API types don't know about (the nodes run newer
+// builds) still becomes a boundary without a code change. Heights <= 0 mean
+// "already active at genesis" (or unscheduled) and are skipped.
+//
+// The network version an upgrade activates is read from the chain with
+// StateNetworkVersion on either side of its height. Same-version fix-up
+// migrations (Refuel, WatermelonFix, ...) don't change it and are dropped.
+// Upgrades the chain hasn't reached yet are numbered after the current
+// version in height order, and re-read from the chain once passed.
 // ---------------------------------------------------------------------------
 
 const upgradeBoundaryWindow = 30 // epochs past upgrade before suite goes quiet
 
 type upgradeBoundary struct {
-	Name  string // e.g. "NV27", "NV28"
-	Epoch abi.ChainEpoch
+	Name     string // e.g. "NV27", "NV28"
+	Upgrade  string // ForkUpgradeParams name, e.g. "GoldenWeek"
+	Network  network.Version
+	Epoch    abi.ChainEpoch
+	Resolved bool // Network read from the chain rather than inferred
 }
 
 var (
-	upgradeOnce        sync.Once
-	upgradeBoundaries  []upgradeBoundary
+	upgradeMu         sync.Mutex
+	upgradeLoaded     bool
+	upgradeBoundaries []upgradeBoundary
+
+	// Per-node schedules keyed by the node's Session UUID; re-read only
+	// after the node restarts.
+	upgradeSchedMu    sync.Mutex
+	upgradeSchedCache = make(map[string]cachedUpgradeSchedule)
 )
 
+type cachedUpgradeSchedule struct {
+	session uuid.UUID
+	sched   []upgradeBoundary
+}
+
+// initUpgradeState loads the upgrade schedule from the nodes on first use.
+// Retried on every call until a majority of nodes respond, so a slow start
+// doesn't leave the suite permanently empty.
 func initUpgradeState() {
-	upgradeOnce.Do(func() {
-		// Only include boundaries with a real mid-test epoch (>0). Negative or
-		// zero values mean "already active at genesis" — nothing to test.
-		if g := abi.ChainEpoch(envInt("GOLDENWEEK_HEIGHT", 0)); g > 0 {
-			upgradeBoundaries = append(upgradeBoundaries, upgradeBoundary{"NV27", g})
+	upgradeMu.Lock()
+	defer upgradeMu.Unlock()
+	if upgradeLoaded {
+		return
+	}
+
+	schedules := readUpgradeSchedules()
+	if len(schedules) == 0 || len(schedules)*2 <= len(nodeKeys) {
+		return
+	}
+	assertUpgradeScheduleAgreement(schedules)
+
+	// Adopt the schedule most nodes report; a dissenting node has already
+	// failed the agreement assertion above.
+	counts := make(map[string]int)
+	best := ""
+	for _, sched := range schedules {
+		fp := scheduleFingerprint(sched)
+		counts[fp]++
+		if best == "" || counts[fp] > counts[best] {
+			best = fp
+		}
+	}
+	var adopted []upgradeBoundary
+	for _, sched := range schedules {
+		if scheduleFingerprint(sched) == best {
+			adopted = sched
+			break
+		}
+	}
+	var scheduled, unscheduled []upgradeBoundary
+	for _, b := range adopted {
+		if b.Epoch > 0 {
+			scheduled = append(scheduled, b)
+		} else if b.Epoch == 0 {
+			unscheduled = append(unscheduled, b)
+		}
+	}
+	boundaries, ok := resolveUpgradeVersions(scheduled)
+	if !ok {
+		return
+	}
+	upgradeBoundaries = boundaries
+
+	upgradeLoaded = true
+	names := make([]string, 0, len(upgradeBoundaries))
+	for _, b := range upgradeBoundaries {
+		names = append(names, fmt.Sprintf("%s(%s)@%d", b.Name, b.Upgrade, b.Epoch))
+	}
+	log.Printf("[upgrade] schedule loaded from %d/%d nodes: boundaries=%v", len(schedules), len(nodeKeys), names)
+	if len(unscheduled) > 0 {
+		zero := make([]string, 0, len(unscheduled))
+		for _, b := range unscheduled {
+			zero = append(zero, b.Upgrade)
+		}
+		log.Printf("[upgrade] upgrades reported at height 0 (not scheduled): %v", zero)
+	}
+	checkExpectedUpgrades()
+}
+
+// checkExpectedUpgrades flags expected network versions with no scheduled
+// boundary: their vectors would never run.
+func checkExpectedUpgrades() {
+	for nv := range expectedUpgrades {
+		found := false
+		for _, b := range upgradeBoundaries {
+			found = found || b.Network == nv
+		}
+		if !found {
+			log.Printf("[upgrade] WARN: no scheduled upgrade activates NV%d; its vectors will not run", nv)
+		}
+		assert.Sometimes(found, "Upgrade with version-specific vectors is scheduled", map[string]any{
+			"boundary":   fmt.Sprintf("NV%d", nv),
+			"boundaries": len(upgradeBoundaries),
+		})
+	}
+}
+
+// readUpgradeSchedules returns each responding node's full upgrade schedule,
+// zero heights included so the caller can report them. Network is left
+// unset; see resolveUpgradeVersions. A node's schedule is read once per
+// Session and served from the cache until the node restarts.
+func readUpgradeSchedules() map[string][]upgradeBoundary {
+	out := make(map[string][]upgradeBoundary)
+	upgradeSchedMu.Lock()
+	defer upgradeSchedMu.Unlock()
+	for _, name := range nodeKeys {
+		session, err := nodes[name].Session(ctx)
+		if err != nil {
+			debugLog("[upgrade] Session failed on %s: %v", name, err)
+			continue
+		}
+		if c, ok := upgradeSchedCache[name]; ok && c.session == session {
+			out[name] = c.sched
+			continue
+		}
+
+		raw, err := chain.CallRaw(ctx, nodeCfg, name, "Filecoin.StateGetNetworkParams")
+		if err != nil {
+			debugLog("[upgrade] StateGetNetworkParams failed on %s: %v", name, err)
+			continue
+		}
+		var params struct {
+			ForkUpgradeParams map[string]json.RawMessage
+		}
+		if err := json.Unmarshal(raw, &params); err != nil {
+			debugLog("[upgrade] StateGetNetworkParams on %s: %v", name, err)
+			continue
+		}
+		var sched []upgradeBoundary
+		for key, v := range params.ForkUpgradeParams {
+			upgrade, ok := strings.CutPrefix(key, "Upgrade")
+			if upgrade, ok = strings.CutSuffix(upgrade, "Height"); !ok || upgrade == "" {
+				continue
+			}
+			var h abi.ChainEpoch
+			if err := json.Unmarshal(v, &h); err != nil {
+				continue
+			}
+			sched = append(sched, upgradeBoundary{Upgrade: upgrade, Epoch: h})
+		}
+		sort.Slice(sched, func(i, j int) bool {
+			if sched[i].Epoch != sched[j].Epoch {
+				return sched[i].Epoch < sched[j].Epoch
+			}
+			return sched[i].Upgrade < sched[j].Upgrade
+		})
+		upgradeSchedCache[name] = cachedUpgradeSchedule{session: session, sched: sched}
+		out[name] = sched
+	}
+	return out
+}
+
+// resolveUpgradeVersions assigns each scheduled upgrade (sorted by epoch)
+// the network version it activates and drops same-version fix-ups. Upgrades
+// the chain has passed are read from it; later ones are numbered on from
+// the current version. Returns false if no node answered.
+func resolveUpgradeVersions(sched []upgradeBoundary) ([]upgradeBoundary, bool) {
+	for _, name := range nodeKeys {
+		node := nodes[name]
+		head, err := node.ChainHead(ctx)
+		if err != nil {
+			continue
+		}
+		cur, err := node.StateNetworkVersion(ctx, head.Key())
+		if err != nil {
+			continue
+		}
+
+		var out []upgradeBoundary
+		next := cur
+		lastFuture := abi.ChainEpoch(-1)
+		for _, b := range sched {
+			if head.Height() >= b.Epoch+5 {
+				nv, bumped, err := upgradeVersionAt(node, b.Epoch)
+				if err != nil {
+					log.Printf("[upgrade] WARN: cannot resolve %s@%d on %s, dropping: %v", b.Upgrade, b.Epoch, name, err)
+					continue
+				}
+				if !bumped {
+					debugLog("[upgrade] %s@%d keeps NV%d, skipping", b.Upgrade, b.Epoch, nv)
+					continue
+				}
+				b.Network, b.Resolved = nv, true
+			} else {
+				// Upgrades sharing an epoch land as one version bump.
+				if b.Epoch != lastFuture {
+					next++
+					lastFuture = b.Epoch
+				}
+				b.Network = next
+			}
+			b.Name = fmt.Sprintf("NV%d", b.Network)
+			out = append(out, b)
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// upgradeVersionAt reads the network version on both sides of epoch and
+// reports the post-side one and whether it changed. The post-side samples
+// epoch+5 for the null-round reason given in doUpgradeActivation.
+func upgradeVersionAt(node api.FullNode, epoch abi.ChainEpoch) (network.Version, bool, error) {
+	preTs, err := node.ChainGetTipSetByHeight(ctx, epoch, types.EmptyTSK)
+	if err != nil {
+		return 0, false, err
+	}
+	postTs, err := node.ChainGetTipSetByHeight(ctx, epoch+5, types.EmptyTSK)
+	if err != nil {
+		return 0, false, err
+	}
+	if postTs.Height() <= epoch {
+		return 0, false, fmt.Errorf("no tipset after epoch %d", epoch)
+	}
+	pre, err := node.StateNetworkVersion(ctx, preTs.Key())
+	if err != nil {
+		return 0, false, err
+	}
+	post, err := node.StateNetworkVersion(ctx, postTs.Key())
+	if err != nil {
+		return 0, false, err
+	}
+	return post, post > pre, nil
+}
+
+// refreshUpgradeVersions re-reads inferred network versions once the chain
+// is past their boundary, so activation checks compare against what the
+// upgrade actually activated.
+func refreshUpgradeVersions(currentHeight abi.ChainEpoch) {
+	upgradeMu.Lock()
+	defer upgradeMu.Unlock()
+	for i := range upgradeBoundaries {
+		b := &upgradeBoundaries[i]
+		if b.Resolved || currentHeight < b.Epoch+5 {
+			continue
+		}
+		for _, name := range nodeKeys {
+			nv, bumped, err := upgradeVersionAt(nodes[name], b.Epoch)
+			if err != nil {
+				continue
+			}
+			if !bumped || nv != b.Network {
+				log.Printf("[upgrade] %s@%d activated NV%d (bumped=%v), inferred NV%d", b.Upgrade, b.Epoch, nv, bumped, b.Network)
+			}
+			b.Network, b.Resolved = nv, true
+			b.Name = fmt.Sprintf("NV%d", nv)
+			break
 		}
-		if x := abi.ChainEpoch(envInt("FIREHORSE_HEIGHT", 0)); x > 0 {
-			upgradeBoundaries = append(upgradeBoundaries, upgradeBoundary{"NV28", x})
+	}
+}
+
+func scheduleFingerprint(sched []upgradeBoundary) string {
+	parts := make([]string, len(sched))
+	for i, b := range sched {
+		parts[i] = fmt.Sprintf("%s=%d", b.Upgrade, b.Epoch)
+	}
+	return strings.Join(parts, ",")
+}
+
+// assertUpgradeScheduleAgreement — every node must report the same height for
+// every upgrade both sides know about. A disagreement means the nodes will
+// migrate at different epochs and fork at the boundary.
+func assertUpgradeScheduleAgreement(schedules map[string][]upgradeBoundary) {
+	if len(schedules) < 2 {
+		return
+	}
+
+	perUpgrade := make(map[string]map[abi.ChainEpoch][]string) // upgrade -> height -> nodes
+	for name, sched := range schedules {
+		for _, b := range sched {
+			if perUpgrade[b.Upgrade] == nil {
+				perUpgrade[b.Upgrade] = make(map[abi.ChainEpoch][]string)
+			}
+			perUpgrade[b.Upgrade][b.Epoch] = append(perUpgrade[b.Upgrade][b.Epoch], name)
 		}
+	}
+
+	conflicts := make(map[string]map[abi.ChainEpoch][]string)
+	for upgrade, heights := range perUpgrade {
+		if len(heights) > 1 {
+			conflicts[upgrade] = heights
+		}
+	}
+
+	assert.Always(len(conflicts) == 0, "Upgrade schedule agrees across all nodes", map[string]any{
+		"responded": len(schedules),
+		"upgrades":  len(perUpgrade),
+		"conflicts": conflicts,
 	})
+	if len(conflicts) > 0 {
+		log.Printf("[upgrade] SCHEDULE DIVERGENCE across %d nodes: %v", len(schedules), conflicts)
+	}
+}
+
+// doUpgradeScheduleAgreement re-checks every node's schedule. Schedules are
+// cached per node session, so this only re-reads a node that restarted —
+// which is exactly when it could have come back with a different config.
+func doUpgradeScheduleAgreement() {
+	assertUpgradeScheduleAgreement(readUpgradeSchedules())
 }
 
 // nearUpgrade returns true if height is within the active boundary window.
@@ -86,16 +395,30 @@ func nearUpgrade(height abi.ChainEpoch, b upgradeBoundary) bool {
 // ---------------------------------------------------------------------------
 
 // UpgradeBoundaryFunc is a function that runs FIP-specific stress at the
-// upgrade boundary. It receives the current chain height and boundary so it
-// can self-gate (e.g. only fire for b.Name == "NV28").
+// upgrade boundary. It receives the current chain height and boundary; it is
+// only invoked for the boundary of the network version it registered for,
+// once that version has been read from the chain (b.Resolved) — a version
+// numbered on from the current one may belong to a different upgrade.
 type UpgradeBoundaryFunc func(currentHeight abi.ChainEpoch, b upgradeBoundary)
 
-var fipBoundaryFuncs []UpgradeBoundaryFunc
+var fipBoundaryFuncs = make(map[network.Version][]UpgradeBoundaryFunc)
+
+// RegisterFIPBoundaryFunc adds a FIP-specific stress function for the upgrade
+// that activates nv. Call from init() in per-upgrade files (e.g.
+// nv28_vectors.go).
+func RegisterFIPBoundaryFunc(nv network.Version, fn UpgradeBoundaryFunc) {
+	ExpectUpgrade(nv)
+	fipBoundaryFuncs[nv] = append(fipBoundaryFuncs[nv], fn)
+}
+
+// expectedUpgrades are the network versions some vector waits for. A missing
+// boundary for one is logged and surfaces as a failed Sometimes.
+var expectedUpgrades = make(map[network.Version]bool)
 
-// RegisterFIPBoundaryFunc adds a FIP-specific stress function to the suite.
-// Call from init() in per-upgrade files (e.g. nv28_vectors.go).
-func RegisterFIPBoundaryFunc(fn UpgradeBoundaryFunc) {
-	fipBoundaryFuncs = append(fipBoundaryFuncs, fn)
+// ExpectUpgrade marks nv as expected in the schedule. Call from init() in
+// per-upgrade files whose vectors look their boundary up with findBoundary.
+func ExpectUpgrade(nv network.Version) {
+	expectedUpgrades[nv] = true
 }
 
 // extractActorID decodes a CreateExternalReturn from a message lookup result.
@@ -118,13 +441,16 @@ func extractActorID(result *api.MsgLookup) *address.Address {
 // ---------------------------------------------------------------------------
 // DoUpgradeSuite — single deck entry
 //
-// For each configured boundary, if the current max-head is within the active
-// window, run the generic assertions and boundary-timed stress. FIP-specific
-// hooks run last, once per invocation (they self-gate internally).
+// Re-checks schedule agreement, then for each discovered boundary, if the
+// current max-head is within the active window, runs the generic assertions
+// and boundary-timed stress. FIP-specific hooks registered for the
+// boundary's network version run last, and only once that version is
+// resolved from the chain.
 // ---------------------------------------------------------------------------
 
 func DoUpgradeSuite() {
 	initUpgradeState()
+	doUpgradeScheduleAgreement()
 	if len(upgradeBoundaries) == 0 {
 		return
 	}
@@ -133,6 +459,7 @@ func DoUpgradeSuite() {
 	if currentHeight == 0 {
 		return
 	}
+	refreshUpgradeVersions(currentHeight)
 
 	for _, b := range upgradeBoundaries {
 		if !nearUpgrade(currentHeight, b) {
@@ -141,12 +468,17 @@ func DoUpgradeSuite() {
 		doNetworkVersionAgreement(b)
 		doUpgradeActivation(currentHeight, b)
 		doMigrationStateRootAgreement(b)
+		doMigrationInvariants(b)
 		doReceiptConsistencyAtBoundary(b)
 		doChainProgressAcrossBoundary(currentHeight, b)
 		doPostUpgradeNodeHealth(currentHeight, b)
 		doBoundaryMessageBurst(currentHeight, b)
 		doActorChurnAtBoundary(currentHeight, b)
-		for _, fn := range fipBoundaryFuncs {
+		if !b.Resolved {
+			debugLog("[upgrade] %s@%d version not yet read from chain (inferred NV%d), skipping FIP hooks", b.Upgrade, b.Epoch, b.Network)
+			continue
+		}
+		for _, fn := range fipBoundaryFuncs[b.Network] {
 			fn(currentHeight, b)
 		}
 	}
@@ -1,7 +1,9 @@
 package chain
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -39,20 +41,8 @@ func ConnectNodes(ctx context.Context, cfg NodeConfig) (map[string]api.FullNode,
 			continue
 		}
 
-		port := cfg.Port
-		if len(name) >= 6 && name[:6] == "forest" && cfg.ForestPort != "" {
-			port = cfg.ForestPort
-		}
-		addr := fmt.Sprintf("ws://%s:%s/rpc/v1", name, port)
-
-		// JWT token path: /root/devgen/<nodename>/<nodename>-jwt
-		tokenPath := fmt.Sprintf("/root/devgen/%s/%s-jwt", name, name)
-		tokenBytes, err := os.ReadFile(tokenPath)
-		if err != nil {
-			log.Printf("[chain] WARN: no JWT at %s for node %s, trying without auth", tokenPath, name)
-			tokenBytes = []byte("")
-		}
-		token := strings.TrimSpace(string(tokenBytes))
+		addr := fmt.Sprintf("ws://%s:%s/rpc/v1", name, cfg.port(name))
+		token := nodeToken(name, true)
 
 		node, closer, err := NewFilecoinClient(ctx, addr, token)
 		if err != nil {
@@ -72,3 +62,67 @@ func ConnectNodes(ctx context.Context, cfg NodeConfig) (map[string]api.FullNode,
 	log.Printf("[chain] connected to %d node(s): %v", len(nodes), keys)
 	return nodes, keys, nil
 }
+
+// port returns the RPC port for the named node.
+func (cfg NodeConfig) port(name string) string {
+	if strings.HasPrefix(name, "forest") && cfg.ForestPort != "" {
+		return cfg.ForestPort
+	}
+	return cfg.Port
+}
+
+// nodeToken reads the node's JWT from /root/devgen/<nodename>/<nodename>-jwt,
+// or returns "" if there is none.
+func nodeToken(name string, warn bool) string {
+	tokenPath := fmt.Sprintf("/root/devgen/%s/%s-jwt", name, name)
+	tokenBytes, err := os.ReadFile(tokenPath)
+	if err != nil {
+		if warn {
+			log.Printf("[chain] WARN: no JWT at %s for node %s, trying without auth", tokenPath, name)
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(tokenBytes))
+}
+
+// CallRaw invokes a JSON-RPC method over HTTP and returns the undecoded
+// result. Used where the pinned api types would drop fields a newer node
+// reports.
+func CallRaw(ctx context.Context, cfg NodeConfig, name, method string, params ...any) (json.RawMessage, error) {
+	if params == nil {
+		params = []any{}
+	}
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("http://%s:%s/rpc/v1", name, cfg.port(name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := nodeToken(name, false); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("%s on %s: decode: %w", method, name, err)
+	}
+	if out.Error != nil {
+		return nil, fmt.Errorf("%s on %s: %d %s", method, name, out.Error.Code, out.Error.Message)
+	}
+	return out.Result, nil
+}